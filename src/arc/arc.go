@@ -1,6 +1,6 @@
 // Adaptive Replacement Cache Implementation
 //
-// Dependencies: lru.go, utility.go
+// Dependencies: arclist.go, utility.go
 //
 // Description:
 // ARC (Adaptive Replacement Cache) is a fixed-size cache with even
@@ -10,12 +10,19 @@
 // entries. It is adapative in the sense that it will dynamically prefer
 // extending its cache size to accomodate for entries that populate T1 more
 // than T2, or vice-versa.
+//
+// Replacement follows REPLACE and case IV of the paper (Megiddo & Modha),
+// which is what keeps |T1|+|T2| <= c, |T1|+|B1| <= c and all four lists
+// within 2c. A new key evicts from T1 only while T1 is above its target p,
+// and a key seen once is remembered for at most c new keys, so a loop over
+// more than c keys misses in ARC just like in LRU.
 
 package arc
 
 import (
 	"fmt"
 	"os"
+	"time"
 )
 
 type ARC struct {
@@ -26,32 +33,50 @@ type ARC struct {
 	// determines preferences between recently-accessed items and frequently accessed.
 	p int
 
-	t1 *LRU // T1 is the list for recently accessed items, with LRU eviction
-	t2 *LRU // T2 is the list for frequently accessed items, with LRU eviction
-	// ghost lists implemented as Hash Sets, represents "metadata" of cache
-	b1    map[string]bool // B1 is the set of keys evicted from t1
-	b2    map[string]bool // B2 is the set of keys evicted from t2
-	stats *Stats          // maintains stats associated with hits/misses
+	// index maps every key ARC knows about to its entry, whichever list it is in
+	index map[string]*entry
+
+	t1 arcList // T1 is the list for recently accessed items, with LRU eviction
+	t2 arcList // T2 is the list for frequently accessed items, with LRU eviction
+	// ghost lists hold entries without values, represents "metadata" of
+	// cache. They are kept in eviction order so the oldest ghost is dropped first.
+	b1    arcList // B1 is the list of keys evicted from t1
+	b2    arcList // B2 is the list of keys evicted from t2
+	free  *entry  // entries dropped from all lists, reused for new keys
+	stats *Stats  // maintains stats associated with hits/misses
+
+	version uint64 // version of the latest write, see version.go
+
+	neg       arcList          // tombstones, kept apart from T1 and T2, see negative.go
+	negBudget int              // max number of tombstones, 0 when negative caching is off
+	now       func() time.Time // clock for expiring tombstones, time.Now outside of tests
+
+	scan    *scanDetector  // optional scan detection, nil when disabled
+	onEvict EvictCallback  // called for every evicted key-value pair, nil if unset
+	l2      SecondLevel    // where evicted values are spilled, nil if unset, see l2.go
+	values  *slabAllocator // where values are stored off the heap, nil if on the heap, see slab.go
+
+	codec          Codec // compresses large values, nil if unset, see codec.go
+	codecThreshold int   // size from which values are compressed
+	decompressing  bool  // values are stored uncompressed while the codec changes
+
+	tags map[string]map[string]struct{} // maps a tag to the keys tagged with it
 }
 
 // NewARC creates an ARC of the given size
 func NewARC(size int) *ARC {
-
-	t1 := NewLru(size) // max size of t1 or t2 is the full cache size
-	t2 := NewLru(size)
-	b1 := make(map[string]bool)
-	b2 := make(map[string]bool)
-	stats := &Stats{0, 0}
-
 	arc := &ARC{
 		size:  size,
 		p:     size / 2, // favor recency/frequency equally at start
-		t1:    t1,
-		t2:    t2,
-		b1:    b1,
-		b2:    b2,
-		stats: stats,
+		index: make(map[string]*entry),
+		stats: &Stats{},
+		now:   time.Now,
 	}
+	arc.t1.init()
+	arc.t2.init()
+	arc.b1.init()
+	arc.b2.init()
+	arc.neg.init()
 
 	return arc
 }
@@ -59,50 +84,61 @@ func NewARC(size int) *ARC {
 // Get returns the value associated with the given key, if it exists.
 //
 func (arc *ARC) Get(key string) ([]byte, bool) {
+	e, ok := arc.index[key]
 
-	valuet1, t1Contains := arc.t1.Get(key)
-	valuet2, t2Contains := arc.t2.Get(key)
+	// a ghost's value may still be in the second level
+	if ok && (e.list == listB1 || e.list == listB2) {
+		if value, ok := arc.getSpilled(e); ok {
+			return value, true
+		}
+		e, ok = arc.index[key] // a failed Set of the value drops the key
+	}
 
-	// if t1Contains, we promote it to t2 (since it was accessed a 2nd time)
-	if t1Contains {
-		arc.t1.Remove(key)       // remove from T1
-		arc.t2.Set(key, valuet1) // place in T2
-		arc.stats.Hits++
-		return valuet1, true
+	// if not in either t1 or t2, then was a miss
+	if !ok || !e.resident() {
+		arc.stats.Misses++
+		return nil, false
+	}
 
-	} else if t2Contains { // if in t2, stays in t2
-		arc.stats.Hits++
-		return valuet2, true
+	// a value that cannot be decoded is lost, the key was not really cached
+	value, ok := arc.valueOf(e)
+	if !ok {
+		arc.drop(e)
+		arc.stats.Misses++
+		return nil, false
 	}
 
-	arc.stats.Misses++
-	// if not in either t1 or t2, then was a miss
-	return nil, false
+	// if in T1, we promote it to T2 (since it was accessed a 2nd time), if in
+	// T2 it stays there as the most recently used key
+	arc.move(e, listT2)
+	arc.stats.Hits++
+	return value, true
 }
 
-// Set puts a key-value pair into cache.
-func (arc *ARC) Set(key string, value []byte) {
-
-	// check for key in T1 or T2
-	
-	t1Contains := arc.t1.Contains(key)
-	t2Contains := arc.t2.Contains(key)
+// Set puts a key-value pair into cache. Returns true if the binding was
+// added successfully, else false. A zero-size ARC never holds a binding, and
+// an off-heap ARC fails if it has no memory for the value, see slab.go. The
+// key is not cached at all after a failed Set.
+func (arc *ARC) Set(key string, value []byte) bool {
+	if arc.size <= 0 {
+		return false
+	}
 
-	// similar to Get, move key to T2 if was in T1 and update value
-	if t1Contains {
-		arc.t1.Remove(key)
-		arc.t2.Set(key, value)
-		return
-	} else if t2Contains {
-		arc.t2.Set(key, value)
-		return
+	e, ok := arc.index[key]
+	if ok && e.list == listNeg {
+		// the key exists after all, forget the tombstone
+		arc.drop(e)
+		ok = false
+	}
+	if !ok {
+		return arc.insert(key, value)
 	}
 
-	lenB1 := len(arc.b1)
-	lenB2 := len(arc.b2)
+	lenB1 := arc.b1.Len()
+	lenB2 := arc.b2.Len()
 
-	// if missed on T1 and T2, check the ghost lists B1, B2 to update p
-	if arc.b1[key] {
+	switch e.list {
+	case listB1:
 		// since B1 contained key, increase p to favor T1
 		var increaseBy int
 
@@ -114,17 +150,12 @@ func (arc *ARC) Set(key string, value []byte) {
 
 		arc.p = min(arc.p+increaseBy, arc.size) // don't want to exceed size, so take arc.size upper bound
 
-		// if arc len at max size, need to evict a key from T2 to increase T1
+		// if arc len at max size, need to make room according to the new p
 		if arc.Len() >= arc.size {
-			arc.evictToGhost("B2")
+			arc.replace(false)
 		}
 
-		// Delete from B1, and add key to T2 (since accessed 2nd time)
-		delete(arc.b1, key)
-		arc.t2.Set(key, value)
-		return
-
-	} else if arc.b2[key] {
+	case listB2:
 		// Since B2 contained key, decrease p to favor T2
 		var decreaseBy int
 
@@ -136,56 +167,170 @@ func (arc *ARC) Set(key string, value []byte) {
 
 		arc.p = max(arc.p-decreaseBy, 0) // Can't have negative, so take 0 as lower bound
 
-		// need to evict a key from T1 to increase T2
+		// need to make room according to the new p
 		if arc.Len() >= arc.size {
-			arc.evictToGhost("B1")
+			arc.replace(true)
 		}
+	}
 
-		// Delete key from B2, move to T2 (means it was accessed min of 3 times)
-		delete(arc.b2, key)
-		arc.t2.Set(key, value)
-		return
+	// similar to Get, a key in T1 or T2 moves to T2 with the new value. A ghost
+	// moves to T2 as well, since it was accessed a 2nd (B1) or 3rd (B2) time.
+	arc.unspill(e)
+	if !arc.setValue(e, value) {
+		arc.drop(e)
+		return false
 	}
+	e.version = arc.nextVersion()
+	arc.move(e, listT2)
+	return true
+}
 
-	// Case when encountering a brand new key 
+// insert adds a brand new key, which is in none of the lists, to T1. Returns
+// false if there is no memory for its value.
+func (arc *ARC) insert(key string, value []byte) bool {
+	arc.makeRoomForNewKey()
+
+	// Add to the recently seen list. Keys that are part of a sequential scan
+	// go to the LRU end instead, so the scan only ever recycles one slot.
+	e := arc.newEntry(key, nil)
+	e.version = arc.nextVersion()
+	e.list = listT1
+	if arc.scan.observe(key) {
+		arc.t1.pushLRU(e)
+	} else {
+		arc.t1.pushMRU(e)
+	}
+	if !arc.setValue(e, value) {
+		arc.drop(e)
+		return false
+	}
+	return true
+}
 
-	// if size going to be exceeded, introduce new key into T1 (after evicting from B1)
-	if arc.Len() >= arc.size {
-		arc.evictToGhost("B1")
+// makeRoomForNewKey frees a slot for a key that is in none of the four lists
+// (case IV of the paper). It also trims the ghost lists, so that T1+B1 never
+// holds more than size keys and all four lists never more than 2*size keys.
+func (arc *ARC) makeRoomForNewKey() {
+	lenL1 := arc.t1.Len() + arc.b1.Len()
+	lenAll := lenL1 + arc.t2.Len() + arc.b2.Len()
+
+	if lenL1 >= arc.size {
+		if arc.t1.Len() < arc.size {
+			// drop the oldest B1 ghost, then evict as usual
+			arc.dropLRU(listB1)
+			if arc.Len() >= arc.size {
+				arc.replace(false)
+			}
+		} else {
+			// B1 is empty and T1 fills the cache, drop the T1 key outright
+			e := arc.t1.lru()
+			arc.notifyEvict(e)
+			arc.drop(e)
+		}
+	} else if lenAll >= arc.size {
+		if lenAll >= 2*arc.size {
+			arc.dropLRU(listB2)
+		}
+		if arc.Len() >= arc.size {
+			arc.replace(false)
+		}
+	}
+}
+
+// replace evicts one key from T1 or T2 into its ghost list. T1 gives up a key
+// if it holds more than its target p (or exactly p, when the key being
+// brought in was found in B2), otherwise T2 does.
+func (arc *ARC) replace(inB2 bool) {
+	lenT1 := arc.t1.Len()
+
+	if lenT1 > 0 && (lenT1 > arc.p || (inB2 && lenT1 == arc.p) || arc.t2.Len() == 0) {
+		arc.evictToGhost(listT1)
+	} else {
+		arc.evictToGhost(listT2)
 	}
+}
+
+// evictToGhost is used to evict the least recently used key of T1 or T2
+// (from passed in list) into B1 or B2 respectively
+func (arc *ARC) evictToGhost(list listID) {
+	if e := arc.list(list).lru(); e != nil {
+		arc.ghost(e)
+	}
+}
 
-	// Add to the recently seen list
-	arc.t1.Set(key, value)
-	arc.handleGhostLists() // control the size of B lists from growing indefinitely
+// ghost evicts e from T1 or T2 into B1 or B2 respectively. The entry itself
+// becomes the ghost, only its value is dropped.
+func (arc *ARC) ghost(e *entry) {
+	arc.notifyEvict(e)
+	arc.spill(e)
+	arc.setValue(e, nil)
+	e.version = 0
+	if e.list == listT1 {
+		arc.move(e, listB1)
+	} else {
+		arc.move(e, listB2)
+	}
 }
 
-// B1 and B2 are the metadata of evicted keys, to prevent size of this metadata
-// growing indefinitely, we start removing keys (at random) from it
-func (arc *ARC) handleGhostLists() {
-	if len(arc.b1) > arc.size {
-		removeRandKey(arc.b1)
+// notifyEvict passes a key-value pair that leaves the cache to the eviction
+// callback, if one is set. A value that cannot be decoded is not passed on.
+func (arc *ARC) notifyEvict(e *entry) {
+	if arc.onEvict == nil {
+		return
 	}
-	if len(arc.b2) > arc.size {
-		removeRandKey(arc.b2)
+	if value, ok := arc.valueOf(e); ok {
+		arc.onEvict(e.key, value)
 	}
 }
 
-// evictToGhost is used to evict a key from the
-// cache (T1 + T2) into B1 or B2 (from passed in whichList)
-func (arc *ARC) evictToGhost(whichList string) {
-	
-	if arc.t1.Len() > 0 && whichList == "B1" {
-		key, ok := arc.t1.RemoveLRU()
+// SetOnEvict sets the callback that is called for every key-value pair ARC
+// evicts from T1 or T2, including the ones dropped by Purge and Reset. A
+// nil callback turns notifications off.
+func (arc *ARC) SetOnEvict(onEvict EvictCallback) {
+	arc.onEvict = onEvict
+}
 
-		if ok {
-			arc.b1[key] = true
+// Purge empties the cache, evicting every key in T1 and T2 and dropping all
+// tombstones. The ghost lists and p are kept, so keys that come back are still recognized as recently
+// or frequently used, and the adaptation carries on where it left off.
+// Values spilled to the second level are deleted, they are cached data too.
+func (arc *ARC) Purge() {
+	arc.clear(listT1)
+	arc.clear(listT2)
+	arc.clear(listNeg)
+	for _, list := range []*arcList{&arc.b1, &arc.b2} {
+		for e := list.sentinel.next; e != &list.sentinel; e = e.next {
+			arc.unspill(e)
 		}
-	} else if arc.t2.Len() > 0 && whichList == "B2" {
-		key, ok := arc.t2.RemoveLRU()
+	}
+}
 
-		if ok {
-			arc.b2[key] = true
+// Reset empties the cache and forgets its history as well: B1 and B2 are
+// cleared and p goes back to size/2, as in a new ARC. If clearStats is set,
+// the hits and misses are zeroed too.
+func (arc *ARC) Reset(clearStats bool) {
+	arc.Purge()
+	arc.clear(listB1)
+	arc.clear(listB2)
+	arc.p = arc.size / 2
+
+	if arc.scan != nil {
+		arc.scan = &scanDetector{minRun: arc.scan.minRun}
+	}
+	if clearStats {
+		*arc.stats = Stats{} // in place, callers may hold on to the pointer
+	}
+}
+
+// clear drops every entry of the given list, from the least recently used.
+// Keys in T1 or T2 are passed to the eviction callback.
+func (arc *ARC) clear(id listID) {
+	list := arc.list(id)
+	for e := list.lru(); e != nil; e = list.lru() {
+		if e.resident() {
+			arc.notifyEvict(e)
 		}
+		arc.drop(e)
 	}
 }
 
@@ -200,20 +345,47 @@ func (arc *ARC) Len() int {
 // Remove removes and returns the value associated with the given key, if it exists.
 // If key not in the cache, returns nil,false
 func (arc *ARC) Remove(key string) ([]byte, bool) {
-
-	// figure whether in T1 or T2, and remove
-	val1, ok1 := arc.t1.Remove(key)
-	if ok1 {
-		return val1, true
+	e, ok := arc.index[key]
+	if ok && e.list == listNeg {
+		arc.drop(e) // Remove forgets tombstones too
+		return nil, false
 	}
 
-	val2, ok2 := arc.t2.Remove(key)
-	if ok2 {
-		return val2, true
+	// if not in either t1 or t2, then was a miss
+	if !ok || !e.resident() {
+		if ok {
+			arc.unspill(e) // the ghost stays, its spilled value must not come back
+		}
+		return nil, false
 	}
 
-	// if not in either t1 or t2, then was a miss
-	return nil, false
+	value, ok := arc.valueOf(e)
+	arc.drop(e)
+	return value, ok
+}
+
+// Resize changes the number of entries the ARC can store. If it shrinks, the
+// entries that no longer fit are evicted into the ghost lists as usual (T1
+// gives up keys while it holds more than p), and the ghost lists are trimmed
+// to the bounds of the new size.
+func (arc *ARC) Resize(size int) {
+	size = max(size, 0)
+	arc.size = size
+	arc.p = min(arc.p, size)
+
+	for arc.Len() > size {
+		arc.replace(false)
+	}
+	for arc.t1.Len()+arc.b1.Len() > size {
+		arc.dropLRU(listB1)
+	}
+	for arc.Len()+arc.b1.Len()+arc.b2.Len() > 2*size {
+		if arc.b2.Len() > 0 {
+			arc.dropLRU(listB2)
+		} else {
+			arc.dropLRU(listB1)
+		}
+	}
 }
 
 // returns to the size of the ARC cache
@@ -231,37 +403,111 @@ func (arc *ARC) ReportStats() {
 	fmt.Println("ARC Hits/Misses")
 	fmt.Println("Number of Hits:", arc.stats.Hits)
 	fmt.Println("Number of Misses:", arc.stats.Misses)
-	fmt.Println("Percentage of Hits:", 100 * float64(arc.stats.Hits) / float64(arc.stats.Misses + arc.stats.Hits))
+	fmt.Println("Percentage of Hits:", arc.stats.HitRate())
+	if arc.l2 != nil {
+		fmt.Println("Number of Disk Hits:", arc.stats.DiskHits)
+		fmt.Println("Number of Disk Errors:", arc.stats.DiskErrors)
+	}
+	if arc.codec != nil {
+		fmt.Println("Compression Ratio:", arc.stats.CompressionRatio())
+	}
 }
 
-// for debugging
+// for debugging, reports a violated invariant on stderr
 func (arc *ARC) invariant() bool {
-
-	// check duplicate keys between T1 and T2
-	t1L := arc.t1.ReturnKeys()
-	t2L := arc.t2.ReturnKeys()
-
-	keepTrackKeys := make(map[string]bool)
-	for _, k := range t1L {
-		keepTrackKeys[k] = true
+	if err := arc.checkInvariants(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false
 	}
+	return true
+}
 
-	for _, key := range t2L {
-		if keepTrackKeys[key] { // means intersection
-			fmt.Fprintf(os.Stderr, "A key in T1 found in T2")
-			return false
+// checkInvariants verifies the structure of the cache against the bounds of
+// the paper (Megiddo & Modha, Section III), and returns the first violation:
+//   - |T1| + |T2| <= c
+//   - |T1| + |B1| <= c
+//   - |T1| + |T2| + |B1| + |B2| <= 2c
+//   - 0 <= p <= c
+//   - T1, T2, B1 and B2 are pairwise disjoint
+// It also checks that the index agrees with the linked lists, and that the
+// tags of every entry agree with the keys recorded for each tag.
+func (arc *ARC) checkInvariants() error {
+	// check every entry is linked into the list it claims, and that the
+	// index points at it. Since the index holds one entry per key, this also
+	// means every key is in exactly one list.
+	linked, tagged, stored := 0, 0, 0
+	for _, id := range []listID{listT1, listT2, listB1, listB2, listNeg} {
+		list := arc.list(id)
+		count := 0
+		for e := list.sentinel.next; e != &list.sentinel; e = e.next {
+			if e.list != id {
+				return fmt.Errorf("key %q is linked into %s but marked as %s", e.key, id, e.list)
+			}
+			if arc.index[e.key] != e {
+				return fmt.Errorf("key %q is linked into %s but not indexed", e.key, id)
+			}
+			if !e.resident() && (e.value != nil || e.slot != (valueSlot{}) || e.compressed || e.version != 0) {
+				return fmt.Errorf("key %q in %s holds a value but is not cached", e.key, id)
+			}
+			if e.resident() && (e.version == 0 || e.version > arc.version) {
+				return fmt.Errorf("key %q in %s has version %d, latest is %d", e.key, id, e.version, arc.version)
+			}
+			for _, tag := range e.tags {
+				if _, ok := arc.tags[tag][e.key]; !ok {
+					return fmt.Errorf("key %q is tagged %q but not recorded under the tag", e.key, tag)
+				}
+			}
+			tagged += len(e.tags)
+			if e.slot.length > 0 {
+				stored++
+			}
+			count++
+		}
+		if count != list.Len() {
+			return fmt.Errorf("%s links %d entries but counts %d", id, count, list.Len())
+		}
+		linked += count
+	}
+	if linked != len(arc.index) {
+		return fmt.Errorf("the index holds %d keys but the lists link %d", len(arc.index), linked)
+	}
+	recorded := 0
+	for _, keys := range arc.tags {
+		recorded += len(keys)
+	}
+	if recorded != tagged {
+		return fmt.Errorf("tags record %d keys but entries carry %d tags", recorded, tagged)
+	}
+	if arc.values != nil {
+		used := 0
+		for _, c := range arc.values.classes {
+			used += c.used
+		}
+		if used != stored {
+			return fmt.Errorf("%d slab chunks are used but entries hold %d values", used, stored)
 		}
 	}
 
-	// fmt.Println("T1 size:", arc.t1.Len())
-	// fmt.Println("T2 size:", arc.t2.Len())
-	// fmt.Println("B1 size:", len(arc.b1))
-	// fmt.Println("B1:", arc.b1)
-
-	// fmt.Println("B2 size:", len(arc.b2))
-	// fmt.Println("B2:", arc.b2)
+	// check the size bounds of the four lists
+	lenT1, lenT2 := arc.t1.Len(), arc.t2.Len()
+	lenB1, lenB2 := arc.b1.Len(), arc.b2.Len()
 
-	// fmt.Println("size:", arc.size)
+	if lenT1+lenT2 > arc.size {
+		return fmt.Errorf("|T1| + |T2| = %d exceeds size %d", lenT1+lenT2, arc.size)
+	}
+	if lenT1+lenB1 > arc.size {
+		return fmt.Errorf("|T1| + |B1| = %d exceeds size %d", lenT1+lenB1, arc.size)
+	}
+	if lenT1+lenT2+lenB1+lenB2 > 2*arc.size {
+		return fmt.Errorf("|T1| + |T2| + |B1| + |B2| = %d exceeds 2 * size %d",
+			lenT1+lenT2+lenB1+lenB2, 2*arc.size)
+	}
+	if arc.neg.Len() > arc.negBudget {
+		return fmt.Errorf("%d tombstones exceed the budget of %d", arc.neg.Len(), arc.negBudget)
+	}
+	if arc.p < 0 || arc.p > arc.size {
+		return fmt.Errorf("p = %d is outside [0, %d]", arc.p, arc.size)
+	}
 
-	return true
+	return nil
 }
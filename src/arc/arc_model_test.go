@@ -0,0 +1,292 @@
+package arc
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+/******************************************************************************/
+/*                            Reference model                                 */
+/******************************************************************************/
+
+// refARC is a slow ARC that follows the pseudocode of the paper line by line,
+// with every list kept as a slice ordered from LRU to MRU. The only liberty
+// it takes is the one ARC takes for Remove: keys are only moved to a ghost
+// list when the cache is actually full.
+type refARC struct {
+	c      int
+	p      int
+	t1     []string
+	t2     []string
+	b1     []string
+	b2     []string
+	values map[string][]byte
+	stats  Stats
+}
+
+func newRefARC(c int) *refARC {
+	return &refARC{c: c, p: c / 2, values: make(map[string][]byte)}
+}
+
+// index returns the position of key in list, or -1
+func index(list []string, key string) int {
+	for i, k := range list {
+		if k == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// without returns list with the element at i removed
+func without(list []string, i int) []string {
+	return append(list[:i:i], list[i+1:]...)
+}
+
+func (ref *refARC) full() bool {
+	return len(ref.t1)+len(ref.t2) >= ref.c
+}
+
+// replace is REPLACE(x, p) of the paper
+func (ref *refARC) replace(inB2 bool) {
+	if len(ref.t1) >= 1 && (len(ref.t1) > ref.p || (inB2 && len(ref.t1) == ref.p) || len(ref.t2) == 0) {
+		key := ref.t1[0]
+		ref.t1 = ref.t1[1:]
+		delete(ref.values, key)
+		ref.b1 = append(ref.b1, key)
+	} else {
+		key := ref.t2[0]
+		ref.t2 = ref.t2[1:]
+		delete(ref.values, key)
+		ref.b2 = append(ref.b2, key)
+	}
+}
+
+func (ref *refARC) Get(key string) ([]byte, bool) {
+	if i := index(ref.t1, key); i >= 0 {
+		ref.t1 = without(ref.t1, i)
+		ref.t2 = append(ref.t2, key)
+	} else if i := index(ref.t2, key); i >= 0 {
+		ref.t2 = append(without(ref.t2, i), key)
+	} else {
+		ref.stats.Misses++
+		return nil, false
+	}
+	ref.stats.Hits++
+	return ref.values[key], true
+}
+
+func (ref *refARC) Set(key string, value []byte) {
+	// case I: hit in T1 or T2
+	if i := index(ref.t1, key); i >= 0 {
+		ref.t1 = without(ref.t1, i)
+		ref.t2 = append(ref.t2, key)
+		ref.values[key] = value
+		return
+	}
+	if i := index(ref.t2, key); i >= 0 {
+		ref.t2 = append(without(ref.t2, i), key)
+		ref.values[key] = value
+		return
+	}
+
+	// case II: ghost hit in B1
+	if i := index(ref.b1, key); i >= 0 {
+		delta := 1
+		if len(ref.b2) > len(ref.b1) {
+			delta = len(ref.b2) / len(ref.b1)
+		}
+		ref.p = min(ref.p+delta, ref.c)
+		if ref.full() {
+			ref.replace(false)
+		}
+		ref.b1 = without(ref.b1, index(ref.b1, key))
+		ref.t2 = append(ref.t2, key)
+		ref.values[key] = value
+		return
+	}
+
+	// case III: ghost hit in B2
+	if i := index(ref.b2, key); i >= 0 {
+		delta := 1
+		if len(ref.b1) > len(ref.b2) {
+			delta = len(ref.b1) / len(ref.b2)
+		}
+		ref.p = max(ref.p-delta, 0)
+		if ref.full() {
+			ref.replace(true)
+		}
+		ref.b2 = without(ref.b2, index(ref.b2, key))
+		ref.t2 = append(ref.t2, key)
+		ref.values[key] = value
+		return
+	}
+
+	// case IV: complete miss
+	lenL1 := len(ref.t1) + len(ref.b1)
+	lenAll := lenL1 + len(ref.t2) + len(ref.b2)
+	if lenL1 == ref.c {
+		if len(ref.t1) < ref.c {
+			ref.b1 = ref.b1[1:]
+			if ref.full() {
+				ref.replace(false)
+			}
+		} else {
+			delete(ref.values, ref.t1[0])
+			ref.t1 = ref.t1[1:]
+		}
+	} else if lenAll >= ref.c {
+		if lenAll == 2*ref.c {
+			ref.b2 = ref.b2[1:]
+		}
+		if ref.full() {
+			ref.replace(false)
+		}
+	}
+	ref.t1 = append(ref.t1, key)
+	ref.values[key] = value
+}
+
+func (ref *refARC) Remove(key string) ([]byte, bool) {
+	value := ref.values[key]
+	if i := index(ref.t1, key); i >= 0 {
+		ref.t1 = without(ref.t1, i)
+	} else if i := index(ref.t2, key); i >= 0 {
+		ref.t2 = without(ref.t2, i)
+	} else {
+		return nil, false
+	}
+	delete(ref.values, key)
+	return value, true
+}
+
+/******************************************************************************/
+/*                                  Tests                                     */
+/******************************************************************************/
+
+// compareWithModel returns a description of the first difference between
+// the state of arc and ref, or "" if they agree
+func compareWithModel(arc *ARC, ref *refARC) string {
+	lists := []struct {
+		name     string
+		got      []string
+		expected []string
+	}{
+		{"T1", arc.t1.keys(), ref.t1},
+		{"T2", arc.t2.keys(), ref.t2},
+		{"B1", arc.b1.keys(), ref.b1},
+		{"B2", arc.b2.keys(), ref.b2},
+	}
+	for _, list := range lists {
+		if len(list.got) != len(list.expected) || (len(list.got) > 0 && !reflect.DeepEqual(list.got, list.expected)) {
+			return fmt.Sprintf("%s is %v, model has %v", list.name, list.got, list.expected)
+		}
+	}
+	if arc.p != ref.p {
+		return fmt.Sprintf("p is %d, model has %d", arc.p, ref.p)
+	}
+	if !arc.stats.Equals(&ref.stats) {
+		return fmt.Sprintf("stats are %+v, model has %+v", *arc.stats, ref.stats)
+	}
+	return ""
+}
+
+// random Set/Get/Remove sequences against ARC and the reference model must
+// leave both in the same state after every single operation
+func TestARCMatchesModel(t *testing.T) {
+	r := newTestRand(t)
+
+	for run := 0; run < 300; run++ {
+		size := 1 + r.Intn(8)
+		keySpace := 1 + r.Intn(4*size)
+		arc := NewARC(size)
+		ref := newRefARC(size)
+		var history []string
+
+		for step := 0; step < 400; step++ {
+			key := fmt.Sprint("k", r.Intn(keySpace))
+			value := []byte(fmt.Sprint(step))
+
+			var op string
+			switch n := r.Intn(10); {
+			case n < 4:
+				op = "Set"
+				arc.Set(key, value)
+				ref.Set(key, value)
+			case n < 9:
+				op = "Get"
+				got, gotOk := arc.Get(key)
+				expected, expectedOk := ref.Get(key)
+				if gotOk != expectedOk || string(got) != string(expected) {
+					t.Fatalf("run %d: Get(%s) = %q, %v, model has %q, %v\nhistory: %v",
+						run, key, got, gotOk, expected, expectedOk, history)
+				}
+			default:
+				op = "Remove"
+				got, gotOk := arc.Remove(key)
+				expected, expectedOk := ref.Remove(key)
+				if gotOk != expectedOk || string(got) != string(expected) {
+					t.Fatalf("run %d: Remove(%s) = %q, %v, model has %q, %v\nhistory: %v",
+						run, key, got, gotOk, expected, expectedOk, history)
+				}
+			}
+			history = append(history, op+"("+key+")")
+
+			if err := arc.checkInvariants(); err != nil {
+				t.Fatalf("run %d (size %d): %v\nhistory: %v", run, size, err, history)
+			}
+			if diff := compareWithModel(arc, ref); diff != "" {
+				t.Fatalf("run %d (size %d): %s\nhistory: %v", run, size, diff, history)
+			}
+		}
+	}
+}
+
+// the checker has to catch every kind of violation it promises to
+func TestCheckInvariantsDetectsViolations(t *testing.T) {
+	// link indexes a new entry for key and links it into the given list
+	link := func(arc *ARC, list listID, key string) *entry {
+		e := arc.newEntry(key, nil)
+		e.list = list
+		arc.list(list).pushMRU(e)
+		return e
+	}
+
+	corruptions := map[string]func(arc *ARC){
+		"key in T1 and T2": func(arc *ARC) { arc.t2.pushMRU(&entry{key: "k0", list: listT2}) },
+		"key in T1 and B2": func(arc *ARC) { arc.b2.pushMRU(&entry{key: "k1", list: listB2}) },
+		"wrong list":       func(arc *ARC) { arc.index["k0"].list = listB1 },
+		"ghost with value": func(arc *ARC) { link(arc, listB1, "g").value = []byte("v") },
+		"p above size":     func(arc *ARC) { arc.p = arc.size + 1 },
+		"p below zero":     func(arc *ARC) { arc.p = -1 },
+		"T1+T2 above size": func(arc *ARC) {
+			for i := 0; i < 3; i++ {
+				link(arc, listT2, fmt.Sprint("t2-", i))
+			}
+		},
+		"T1+B1 above size": func(arc *ARC) {
+			for i := 0; i < 3; i++ {
+				link(arc, listB1, fmt.Sprint("b1-", i))
+			}
+		},
+		"unindexed entry":   func(arc *ARC) { delete(arc.index, "k0") },
+		"stale index entry": func(arc *ARC) { arc.index["gone"] = &entry{key: "gone", list: listT1} },
+		"wrong length":      func(arc *ARC) { arc.t1.length++ },
+	}
+
+	for name, corrupt := range corruptions {
+		arc := NewARC(4)
+		for i := 0; i < 2; i++ {
+			arc.Set(fmt.Sprint("k", i), nil)
+		}
+		if err := arc.checkInvariants(); err != nil {
+			t.Fatalf("healthy cache reported %v", err)
+		}
+
+		corrupt(arc)
+		if err := arc.checkInvariants(); err == nil {
+			t.Errorf("%s: violation not detected", name)
+		}
+	}
+}
@@ -1,52 +1,47 @@
 /******************************************************************************
- * lru_test.go
+ * arc_test.go
  * Author:
- * Usage:    `go test`  or  `go test -v`
+ * Usage:    `go test`  or  `go test -v`  or  `go test -seed=<n>`
  * Description:
- *    An incomplete unit testing suite for lru.go. You are welcome to change
- *    anything in this file however you would like. You are strongly encouraged
- *    to create additional tests for your implementation, as the ones provided
- *    here are extremely basic, and intended only to demonstrate how to test
- *    your program.
+ *    Unit tests for lru.go and arc.go, and hit rate comparisons of ARC vs.
+ *    LRU. The random workloads are generated from a fixed seed so every run
+ *    is reproducible; a failing test logs its seed, and -seed replays the
+ *    same workload (or explores a different one).
  ******************************************************************************/
 
 package arc
 
 import (
-	"bufio"
+	"flag"
 	"fmt"
+	"math"
 	"math/rand"
 	"os"
-	"strings"
 	"testing"
-	"time"
 )
 
 /******************************************************************************/
 /*                                Functions                                   */
 /******************************************************************************/
 
-// Fails test t with an error message if fifo.MaxStorage() is not equal to capacity
-// func checkCapacity(t *testing.T, cache Cache, capacity int) {
-// 	max := cache.MaxStorage()
-// 	if max != capacity {
-// 		t.Errorf("Expected %s to have %d MaxStorage, but it had %d", cacheType(cache), capacity, max)
-// 	}
-// }
-/******************************************************************************/
-/*                                  Tests                                     */
-/******************************************************************************/
-
-// function for testing LRU cache algorithm
-func TestLRU(t *testing.T) {
-	fmt.Println("Test LRU\n--------------")
-	capacity := 10
-	lru := NewLru(10)
-
-	if lru.MaxSize() != capacity {
-		t.Errorf("WRONG CAPACITY")
-	}
-
+// every random workload is generated from this seed, so a failing run can be
+// reproduced with `go test -seed=<seed>`
+var seed = flag.Int64("seed", 316, "seed for the random ARC vs. LRU workloads")
+
+// hit rates of ARC and LRU on the same random workload are compared with this
+// tolerance (in percentage points), to absorb the noise of a single seed
+const hitRateTolerance = 2.0
+
+// newTestRand returns a generator seeded from -seed, and logs the seed when
+// the test fails
+func newTestRand(t *testing.T) *rand.Rand {
+	s := *seed
+	t.Cleanup(func() {
+		if t.Failed() {
+			t.Logf("random workload seeded with %d, rerun with -seed=%d", s, s)
+		}
+	})
+	return rand.New(rand.NewSource(s))
 }
 
 // function for increasing probabiliy of getting same key
@@ -63,666 +58,356 @@ func mapToSame(val int) int {
 	return val
 }
 
-// function for testing ARC vs. LRU comparison (control)
-func TestARCControl(t *testing.T) {
-	fmt.Println("Test ARC vs LRU\n-----------------")
-	arc := NewARC(20)
-	lru := NewLru(20)
-
-	for i := 0; i < 5000; i++ {
-		rand.Seed(time.Now().UnixNano())
-		key := fmt.Sprint("k", mapToSame(rand.Intn(40))) // k30
-		arc.Set(key, []byte(""))                         // value: ""
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key2 := fmt.Sprint("k", mapToSame(rand.Intn(40)))
-
-		arc.Get(key2)
-		lru.Get(key2)
-
-	}
-
-	if !arc.invariant() {
-		t.Errorf("INVARIANT VIOLATED")
-	}
-
-	lru.ReportStats()
-	arc.ReportStats()
-	if lru.Stats().Hits < arc.Stats().Hits {
-		fmt.Println("ARC outperforms LRU (good)")
-	} else {
-		fmt.Println("LRU outperforms ARC (idk)")
-	}
-
+// comparison describes one ARC vs. LRU experiment. Every iteration does sets
+// Sets followed by gets Gets, on keys k<mapToSame(n)> with n in [0, keyRange).
+type comparison struct {
+	name       string
+	size       int
+	keyRange   int
+	iterations int
+	sets       int
+	gets       int
 }
 
-// function for testing ARC vs. LRU with different Set-Get ratios
-func TestARCSetGetRatio(t *testing.T) {
-	fmt.Println("Test ARC vs LRU Set-Get Ratio\n-----------------")
-	arc := NewARC(20)
-	lru := NewLru(20)
-
-	for i := 0; i < 5000; i++ {
-		rand.Seed(time.Now().UnixNano())
-		key := fmt.Sprint("k", mapToSame(rand.Intn(40))) // k30
-		arc.Set(key, []byte(""))                         // value: ""
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key2 := fmt.Sprint("k", mapToSame(rand.Intn(40)))
-
-		arc.Get(key2)
-		lru.Get(key2)
-
-		rand.Seed(time.Now().UnixNano())
-		key2 = fmt.Sprint("k", mapToSame(rand.Intn(40)))
+// runComparison replays the experiment against an ARC and an LRU of the same
+// size, checking the ARC invariant after every operation
+func runComparison(t *testing.T, r *rand.Rand, c comparison) (*LRU, *ARC) {
+	arc := NewARC(c.size)
+	lru := NewLru(c.size)
+
+	for i := 0; i < c.iterations; i++ {
+		for s := 0; s < c.sets; s++ {
+			key := fmt.Sprint("k", mapToSame(r.Intn(c.keyRange)))
+			arc.Set(key, []byte(""))
+			lru.Set(key, []byte(""))
+
+			if !arc.invariant() {
+				t.Fatalf("INVARIANT VIOLATED after Set(%s) in iteration %d", key, i)
+			}
+		}
 
-		arc.Get(key2)
-		lru.Get(key2)
+		for g := 0; g < c.gets; g++ {
+			key := fmt.Sprint("k", mapToSame(r.Intn(c.keyRange)))
+			arc.Get(key)
+			lru.Get(key)
 
+			if !arc.invariant() {
+				t.Fatalf("INVARIANT VIOLATED after Get(%s) in iteration %d", key, i)
+			}
+		}
 	}
 
-	if !arc.invariant() {
-		t.Errorf("INVARIANT VIOLATED")
-	}
-
-	fmt.Printf("%v,%v,%v\n", "Set-Get Ratio 1:2", LRUHitRate(lru), ARCHitRate(arc))
-
-	arc = NewARC(20)
-	lru = NewLru(20)
-
-	for i := 0; i < 5000; i++ {
-		rand.Seed(time.Now().UnixNano())
-		key := fmt.Sprint("k", mapToSame(rand.Intn(40))) // k30
-		arc.Set(key, []byte(""))                         // value: ""
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key2 := fmt.Sprint("k", mapToSame(rand.Intn(40)))
-
-		arc.Get(key2)
-		lru.Get(key2)
-
-		rand.Seed(time.Now().UnixNano())
-		key2 = fmt.Sprint("k", mapToSame(rand.Intn(40)))
-
-		arc.Get(key2)
-		lru.Get(key2)
-
-		rand.Seed(time.Now().UnixNano())
-		key2 = fmt.Sprint("k", mapToSame(rand.Intn(40)))
-
-		arc.Get(key2)
-		lru.Get(key2)
+	fmt.Printf("%v,%v,%v\n", c.name, LRUHitRate(lru), ARCHitRate(arc))
+	return lru, arc
+}
 
+// checkARCNotWorse fails t if ARC's hit rate is below LRU's by more than the tolerance
+func checkARCNotWorse(t *testing.T, lru *LRU, arc *ARC) {
+	if ARCHitRate(arc) < LRUHitRate(lru)-hitRateTolerance {
+		t.Errorf("ARC hit rate %.2f%% is more than %.1f points below LRU's %.2f%%",
+			ARCHitRate(arc), hitRateTolerance, LRUHitRate(lru))
 	}
+}
 
-	if !arc.invariant() {
-		t.Errorf("INVARIANT VIOLATED")
+// checkClose fails t if the hit rates a and b are further apart than the tolerance
+func checkClose(t *testing.T, what string, a, b float64) {
+	if math.Abs(a-b) > hitRateTolerance {
+		t.Errorf("%s: hit rates %.2f%% and %.2f%% differ by more than %.1f points",
+			what, a, b, hitRateTolerance)
 	}
+}
 
-	fmt.Printf("%v,%v,%v\n", "Set-Get Ratio 1:3", LRUHitRate(lru), ARCHitRate(arc))
-
-	arc = NewARC(20)
-	lru = NewLru(20)
-
-	for i := 0; i < 5000; i++ {
-		rand.Seed(time.Now().UnixNano())
-		key := fmt.Sprint("k", mapToSame(rand.Intn(40))) // k30
-		arc.Set(key, []byte(""))                         // value: ""
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key2 := fmt.Sprint("k", mapToSame(rand.Intn(40)))
-
-		arc.Get(key2)
-		lru.Get(key2)
-
-		rand.Seed(time.Now().UnixNano())
-		key2 = fmt.Sprint("k", mapToSame(rand.Intn(40)))
-
-		arc.Get(key2)
-		lru.Get(key2)
-
-		rand.Seed(time.Now().UnixNano())
-		key2 = fmt.Sprint("k", mapToSame(rand.Intn(40)))
-
-		arc.Get(key2)
-		lru.Get(key2)
-
-		rand.Seed(time.Now().UnixNano())
-		key2 = fmt.Sprint("k", mapToSame(rand.Intn(40)))
+/******************************************************************************/
+/*                                  Tests                                     */
+/******************************************************************************/
 
-		arc.Get(key2)
-		lru.Get(key2)
+// function for testing LRU cache algorithm
+func TestLRU(t *testing.T) {
+	capacity := 10
+	lru := NewLru(10)
 
+	if lru.MaxSize() != capacity {
+		t.Errorf("WRONG CAPACITY")
 	}
 
-	if !arc.invariant() {
-		t.Errorf("INVARIANT VIOLATED")
+	for i := 0; i < 3*capacity; i++ {
+		lru.Set(fmt.Sprint("k", i), []byte(""))
+		if lru.Len() > capacity {
+			t.Fatalf("LRU holds %d entries, more than its capacity %d", lru.Len(), capacity)
+		}
 	}
 
-	fmt.Printf("%v,%v,%v\n", "Set-Get Ratio 1:4", LRUHitRate(lru), ARCHitRate(arc))
-
-	arc = NewARC(20)
-	lru = NewLru(20)
-
-	for i := 0; i < 5000; i++ {
-		rand.Seed(time.Now().UnixNano())
-		key := fmt.Sprint("k", mapToSame(rand.Intn(40))) // k30
-		arc.Set(key, []byte(""))                         // value: ""
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key2 := fmt.Sprint("k", mapToSame(rand.Intn(40)))
-
-		arc.Get(key2)
-		lru.Get(key2)
-
-		rand.Seed(time.Now().UnixNano())
-		key2 = fmt.Sprint("k", mapToSame(rand.Intn(40)))
-
-		arc.Get(key2)
-		lru.Get(key2)
-
-		rand.Seed(time.Now().UnixNano())
-		key2 = fmt.Sprint("k", mapToSame(rand.Intn(40)))
-
-		arc.Get(key2)
-		lru.Get(key2)
-
-		rand.Seed(time.Now().UnixNano())
-		key2 = fmt.Sprint("k", mapToSame(rand.Intn(40)))
-
-		arc.Get(key2)
-		lru.Get(key2)
-
-		rand.Seed(time.Now().UnixNano())
-		key2 = fmt.Sprint("k", mapToSame(rand.Intn(40)))
-
-		arc.Get(key2)
-		lru.Get(key2)
-
+	// only the 10 most recently set keys are left
+	for i := 0; i < 3*capacity; i++ {
+		_, ok := lru.Get(fmt.Sprint("k", i))
+		if ok != (i >= 2*capacity) {
+			t.Errorf("Get(k%d) = %v", i, ok)
+		}
 	}
-
-	if !arc.invariant() {
-		t.Errorf("INVARIANT VIOLATED")
+	if lru.Stats().Hits != capacity || lru.Stats().Misses != 2*capacity {
+		t.Errorf("unexpected stats %+v", *lru.Stats())
 	}
+}
 
-	fmt.Printf("%v,%v,%v\n", "Set-Get Ratio 1:5", LRUHitRate(lru), ARCHitRate(arc))
-
-	arc = NewARC(20)
-	lru = NewLru(20)
-
-	for i := 0; i < 5000; i++ {
-		rand.Seed(time.Now().UnixNano())
-		key := fmt.Sprint("k", mapToSame(rand.Intn(40))) // k30
-		arc.Set(key, []byte(""))                         // value: ""
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key = fmt.Sprint("k", mapToSame(rand.Intn(40)))
-
-		arc.Set(key, []byte(""))
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key = fmt.Sprint("k", mapToSame(rand.Intn(40)))
-
-		arc.Set(key, []byte(""))
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key = fmt.Sprint("k", mapToSame(rand.Intn(40)))
-
-		arc.Set(key, []byte(""))
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key = fmt.Sprint("k", mapToSame(rand.Intn(40)))
-
-		arc.Set(key, []byte(""))
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key2 := fmt.Sprint("k", mapToSame(rand.Intn(40)))
+// function for testing ARC vs. LRU comparison (control)
+func TestARCControl(t *testing.T) {
+	r := newTestRand(t)
+	lru, arc := runComparison(t, r, comparison{"Control", 20, 40, 5000, 1, 1})
+	checkARCNotWorse(t, lru, arc)
+}
 
-		arc.Get(key2)
-		lru.Get(key2)
+// function for testing ARC vs. LRU with different Set-Get ratios
+func TestARCSetGetRatio(t *testing.T) {
+	ratios := []struct{ sets, gets int }{
+		{1, 2}, {1, 3}, {1, 4}, {1, 5}, {5, 1}, {4, 1}, {3, 1}, {2, 1}, {1, 1},
 	}
 
-	if !arc.invariant() {
-		t.Errorf("INVARIANT VIOLATED")
+	for _, ratio := range ratios {
+		name := fmt.Sprintf("Set-Get Ratio %d:%d", ratio.sets, ratio.gets)
+		t.Run(name, func(t *testing.T) {
+			r := newTestRand(t)
+			lru, arc := runComparison(t, r, comparison{name, 20, 40, 5000, ratio.sets, ratio.gets})
+			checkARCNotWorse(t, lru, arc)
+		})
 	}
+}
 
-	fmt.Printf("%v,%v,%v\n", "Set-Get Ratio 5:1", LRUHitRate(lru), ARCHitRate(arc))
-
-	arc = NewARC(20)
-	lru = NewLru(20)
-
-	for i := 0; i < 5000; i++ {
-		rand.Seed(time.Now().UnixNano())
-		key := fmt.Sprint("k", mapToSame(rand.Intn(40))) // k30
-		arc.Set(key, []byte(""))                         // value: ""
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key = fmt.Sprint("k", mapToSame(rand.Intn(40)))
-
-		arc.Set(key, []byte(""))
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key = fmt.Sprint("k", mapToSame(rand.Intn(40)))
-
-		arc.Set(key, []byte(""))
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key = fmt.Sprint("k", mapToSame(rand.Intn(40)))
-
-		arc.Set(key, []byte(""))
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key2 := fmt.Sprint("k", mapToSame(rand.Intn(40)))
-
-		arc.Get(key2)
-		lru.Get(key2)
+// function for testing ARC vs. LRU with different cache sizes
+func TestARCCacheSize(t *testing.T) {
+	previous := 0.0
+	for _, size := range []int{20, 30, 60, 120, 200} {
+		name := fmt.Sprint("Cache Size: ", size)
+		t.Run(name, func(t *testing.T) {
+			r := newTestRand(t)
+			lru, arc := runComparison(t, r, comparison{name, size, 300, 5000, 1, 1})
+			checkARCNotWorse(t, lru, arc)
+
+			// a bigger cache never hurts
+			if ARCHitRate(arc) < previous-hitRateTolerance {
+				t.Errorf("hit rate dropped from %.2f%% to %.2f%% with a bigger cache", previous, ARCHitRate(arc))
+			}
+			previous = ARCHitRate(arc)
+		})
 	}
+}
 
-	if !arc.invariant() {
-		t.Errorf("INVARIANT VIOLATED")
+// function for testing ARC vs. LRU with different range of keys
+func TestARCRangeKeys(t *testing.T) {
+	previous := 100.0
+	for _, keyRange := range []int{80, 160, 320, 400} {
+		name := fmt.Sprint("Range of Keys: ", keyRange)
+		t.Run(name, func(t *testing.T) {
+			r := newTestRand(t)
+			lru, arc := runComparison(t, r, comparison{name, 20, keyRange, 5000, 1, 1})
+			checkARCNotWorse(t, lru, arc)
+
+			// more distinct keys never help
+			if ARCHitRate(arc) > previous+hitRateTolerance {
+				t.Errorf("hit rate rose from %.2f%% to %.2f%% with more keys", previous, ARCHitRate(arc))
+			}
+			previous = ARCHitRate(arc)
+		})
 	}
+}
 
-	fmt.Printf("%v,%v,%v\n", "Set-Get Ratio 4:1", LRUHitRate(lru), ARCHitRate(arc))
-
-	arc = NewARC(20)
-	lru = NewLru(20)
-
-	for i := 0; i < 5000; i++ {
-		rand.Seed(time.Now().UnixNano())
-		key := fmt.Sprint("k", mapToSame(rand.Intn(40))) // k30
-		arc.Set(key, []byte(""))                         // value: ""
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key = fmt.Sprint("k", mapToSame(rand.Intn(40)))
-
-		arc.Set(key, []byte(""))
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key = fmt.Sprint("k", mapToSame(rand.Intn(40)))
-
-		arc.Set(key, []byte(""))
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key2 := fmt.Sprint("k", mapToSame(rand.Intn(40)))
+// function for testing ARC vs. LRU with different number of requests
+func TestARCMoreIterations(t *testing.T) {
+	r := newTestRand(t)
+	_, base := runComparison(t, r, comparison{"Iterations: 5000", 20, 40, 5000, 1, 1})
 
-		arc.Get(key2)
-		lru.Get(key2)
-	}
+	for _, iterations := range []int{10000, 15000, 20000, 25000} {
+		name := fmt.Sprint("Iterations: ", iterations)
+		t.Run(name, func(t *testing.T) {
+			r := newTestRand(t)
+			lru, arc := runComparison(t, r, comparison{name, 20, 40, iterations, 1, 1})
+			checkARCNotWorse(t, lru, arc)
 
-	if !arc.invariant() {
-		t.Errorf("INVARIANT VIOLATED")
+			// the workload is stationary, so the hit rate should not drift
+			checkClose(t, name, ARCHitRate(base), ARCHitRate(arc))
+		})
 	}
+}
 
-	fmt.Printf("%v,%v,%v\n", "Set-Get Ratio 3:1", LRUHitRate(lru), ARCHitRate(arc))
-
-	arc = NewARC(20)
-	lru = NewLru(20)
-
-	for i := 0; i < 5000; i++ {
-		rand.Seed(time.Now().UnixNano())
-		key := fmt.Sprint("k", mapToSame(rand.Intn(40))) // k30
-		arc.Set(key, []byte(""))                         // value: ""
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key = fmt.Sprint("k", mapToSame(rand.Intn(40)))
+// evictionLog collects the keys and values passed to an eviction callback
+type evictionLog map[string]string
 
-		arc.Set(key, []byte(""))
-		lru.Set(key, []byte(""))
+func (log evictionLog) record(key string, value []byte) {
+	log[key] = string(value)
+}
 
-		rand.Seed(time.Now().UnixNano())
-		key2 := fmt.Sprint("k", mapToSame(rand.Intn(40)))
+// every key that ARC drops to make room is reported once, with its value
+func TestARCEvictCallback(t *testing.T) {
+	arc := NewARC(4)
+	evicted := evictionLog{}
+	arc.SetOnEvict(evicted.record)
 
-		arc.Get(key2)
-		lru.Get(key2)
+	for i := 0; i < 10; i++ {
+		arc.Set(fmt.Sprint("k", i), []byte(fmt.Sprint("v", i)))
 	}
+	arc.Remove("k9") // removed, not evicted
 
-	if !arc.invariant() {
-		t.Errorf("INVARIANT VIOLATED")
-	}
-
-	fmt.Printf("%v,%v,%v\n", "Set-Get Ratio 2:1", LRUHitRate(lru), ARCHitRate(arc))
-
-	arc = NewARC(20)
-	lru = NewLru(20)
-
-	for i := 0; i < 5000; i++ {
-		rand.Seed(time.Now().UnixNano())
-		key := fmt.Sprint("k", mapToSame(rand.Intn(40))) // k30
-		arc.Set(key, []byte(""))                         // value: ""
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key2 := fmt.Sprint("k", mapToSame(rand.Intn(40)))
-
-		arc.Get(key2)
-		lru.Get(key2)
+	if len(evicted) != 6 {
+		t.Errorf("expected 6 evictions, got %v", evicted)
 	}
-
-	if !arc.invariant() {
-		t.Errorf("INVARIANT VIOLATED")
+	for i := 0; i < 6; i++ {
+		if value, ok := evicted[fmt.Sprint("k", i)]; !ok || value != fmt.Sprint("v", i) {
+			t.Errorf("expected k%d to be evicted with value v%d, got %q", i, i, value)
+		}
 	}
-
-	fmt.Printf("%v,%v,%v\n", "Set-Get Ratio 1:1", LRUHitRate(lru), ARCHitRate(arc))
 }
 
-// function for testing ARC vs. LRU with different cache sizes
-func TestARCCacheSize(t *testing.T) {
-	fmt.Println("Test ARC vs LRU Cache Size\n-----------------\nControl")
-	// MAIN CHANGE IS HERE: CHANGED FROM 20 to 30
-	arc := NewARC(20)
-	lru := NewLru(20)
-	// ALSO NEED TO CHANGE KEY SPACE TO 200
-	for i := 0; i < 5000; i++ {
-		rand.Seed(time.Now().UnixNano())
-		key := fmt.Sprint("k", mapToSame(rand.Intn(300))) // k30
-		arc.Set(key, []byte(""))                          // value: ""
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key2 := fmt.Sprint("k", mapToSame(rand.Intn(300)))
-
-		arc.Get(key2)
-		lru.Get(key2)
-
+// a new key never grows the cache past its size, even when T1 is empty, and
+// the history of keys seen once never outgrows the cache
+func TestARCNewKeyBounds(t *testing.T) {
+	arc := NewARC(10)
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprint("hot", i)
+		arc.Set(key, []byte{})
+		arc.Get(key)
 	}
-
-	if !arc.invariant() {
-		t.Errorf("INVARIANT VIOLATED")
-	}
-
-	fmt.Printf("%v,%v,%v\n", "Cache Size: 20", LRUHitRate(lru), ARCHitRate(arc))
-
-	if lru.Stats().Hits < arc.Stats().Hits {
-		fmt.Println("ARC outperforms LRU (good)\n-----------------")
-	} else {
-		fmt.Println("LRU outperforms ARC (idk)")
+	arc.Set("new", []byte{})
+	if arc.Len() != 10 || arc.b2.Len() != 1 {
+		t.Errorf("expected a T2 key to make room, got %d keys and %d B2 ghosts", arc.Len(), arc.b2.Len())
 	}
 
-	arc = NewARC(30)
-	lru = NewLru(30)
-
-	for i := 0; i < 5000; i++ {
-		rand.Seed(time.Now().UnixNano())
-		key := fmt.Sprint("k", mapToSame(rand.Intn(300))) // k30
-		arc.Set(key, []byte(""))                          // value: ""
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key2 := fmt.Sprint("k", mapToSame(rand.Intn(300)))
-
-		arc.Get(key2)
-		lru.Get(key2)
-
+	for i := 0; i < 100; i++ {
+		arc.Set(fmt.Sprint("once", i), []byte{})
+		if arc.t1.Len()+arc.b1.Len() > 10 {
+			t.Fatalf("|T1| + |B1| = %d exceeds the size", arc.t1.Len()+arc.b1.Len())
+		}
 	}
-
 	if !arc.invariant() {
 		t.Errorf("INVARIANT VIOLATED")
 	}
+}
 
-	fmt.Printf("%v,%v,%v\n", "Cache Size: 30", LRUHitRate(lru), ARCHitRate(arc))
-
-	arc = NewARC(60)
-	lru = NewLru(60)
-
-	for i := 0; i < 5000; i++ {
-		rand.Seed(time.Now().UnixNano())
-		key := fmt.Sprint("k", mapToSame(rand.Intn(300))) // k30
-		arc.Set(key, []byte(""))                          // value: ""
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key2 := fmt.Sprint("k", mapToSame(rand.Intn(300)))
-
-		arc.Get(key2)
-		lru.Get(key2)
-
+// Purge empties T1 and T2 but keeps the history that drives adaptation
+func TestARCPurge(t *testing.T) {
+	arc := NewARC(10)
+	warmT2(arc, seqKeys("hot", 0, 10))
+	replayKeys(seqKeys("cold", 0, 20), arc)
+	if !inList(arc, listB2, "hot0") {
+		t.Fatalf("expected hot0 to be evicted into B2")
 	}
-
-	if !arc.invariant() {
-		t.Errorf("INVARIANT VIOLATED")
+	arc.Set("hot0", []byte("v")) // back from B2, p moves
+	stats := arc.Stats()
+	before := *stats
+	p, lenB1, lenB2 := arc.p, arc.b1.Len(), arc.b2.Len()
+	if lenB1 == 0 || lenB2 == 0 {
+		t.Fatalf("expected both ghost lists to be in use, got |B1| = %d, |B2| = %d", lenB1, lenB2)
 	}
 
-	fmt.Printf("%v,%v,%v\n", "Cache Size: 60", LRUHitRate(lru), ARCHitRate(arc))
-
-	arc = NewARC(120)
-	lru = NewLru(120)
-
-	for i := 0; i < 5000; i++ {
-		rand.Seed(time.Now().UnixNano())
-		key := fmt.Sprint("k", mapToSame(rand.Intn(300))) // k30
-		arc.Set(key, []byte(""))                          // value: ""
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key2 := fmt.Sprint("k", mapToSame(rand.Intn(300)))
-
-		arc.Get(key2)
-		lru.Get(key2)
+	evicted := evictionLog{}
+	arc.SetOnEvict(evicted.record)
+	resident := append(arc.t1.keys(), arc.t2.keys()...)
+	arc.Purge()
 
+	if arc.Len() != 0 {
+		t.Errorf("expected an empty cache after Purge, got %d entries", arc.Len())
 	}
-
-	if !arc.invariant() {
-		t.Errorf("INVARIANT VIOLATED")
+	if len(evicted) != len(resident) {
+		t.Errorf("expected all %d resident keys to be evicted, got %d", len(resident), len(evicted))
 	}
-
-	fmt.Printf("%v,%v,%v\n", "Cache Size: 120", LRUHitRate(lru), ARCHitRate(arc))
-
-	arc = NewARC(200)
-	lru = NewLru(200)
-
-	for i := 0; i < 5000; i++ {
-		rand.Seed(time.Now().UnixNano())
-		key := fmt.Sprint("k", mapToSame(rand.Intn(300))) // k30
-		arc.Set(key, []byte(""))                          // value: ""
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key2 := fmt.Sprint("k", mapToSame(rand.Intn(300)))
-
-		arc.Get(key2)
-		lru.Get(key2)
-
+	if evicted["hot0"] != "v" {
+		t.Errorf("expected hot0 to be evicted with its value, got %q", evicted["hot0"])
 	}
-
-	if !arc.invariant() {
-		t.Errorf("INVARIANT VIOLATED")
+	if arc.p != p || arc.b1.Len() != lenB1 || arc.b2.Len() != lenB2 {
+		t.Errorf("expected Purge to keep p = %d, |B1| = %d, |B2| = %d, got %d, %d, %d",
+			p, lenB1, lenB2, arc.p, arc.b1.Len(), arc.b2.Len())
 	}
-
-	fmt.Printf("%v,%v,%v\n", "Cache Size: 200", LRUHitRate(lru), ARCHitRate(arc))
-
-}
-
-// function for testing ARC vs. LRU with different range of keys
-func TestARCRangeKeys(t *testing.T) {
-	fmt.Println("Test ARC vs LRU Range of Keys\n-----------------")
-	arc := NewARC(20)
-	lru := NewLru(20)
-
-	for i := 0; i < 5000; i++ {
-		rand.Seed(time.Now().UnixNano())
-		// MAIN CHANGE IS HERE: changed from 40 to 80
-		key := fmt.Sprint("k", mapToSame(rand.Intn(80))) // k30
-		arc.Set(key, []byte(""))                         // value: ""
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key2 := fmt.Sprint("k", mapToSame(rand.Intn(80)))
-
-		arc.Get(key2)
-		lru.Get(key2)
-
-	}
-	fmt.Printf("%v,%v,%v\n", "Range of Keys: 80", LRUHitRate(lru), ARCHitRate(arc))
-
-	arc = NewARC(20)
-	lru = NewLru(20)
-
-	for i := 0; i < 5000; i++ {
-		rand.Seed(time.Now().UnixNano())
-		key := fmt.Sprint("k", mapToSame(rand.Intn(160))) // k30
-		arc.Set(key, []byte(""))                          // value: ""
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key2 := fmt.Sprint("k", mapToSame(rand.Intn(160)))
-
-		arc.Get(key2)
-		lru.Get(key2)
-
+	if arc.Stats() != stats || !stats.Equals(&before) {
+		t.Errorf("expected Purge to keep the stats %+v, got %+v", before, *arc.Stats())
 	}
-	fmt.Printf("%v,%v,%v\n", "Range of Keys: 160", LRUHitRate(lru), ARCHitRate(arc))
-
-	arc = NewARC(20)
-	lru = NewLru(20)
-
-	for i := 0; i < 5000; i++ {
-		rand.Seed(time.Now().UnixNano())
-		key := fmt.Sprint("k", mapToSame(rand.Intn(320))) // k30
-		arc.Set(key, []byte(""))                          // value: ""
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key2 := fmt.Sprint("k", mapToSame(rand.Intn(320)))
-
-		arc.Get(key2)
-		lru.Get(key2)
-
+	if err := arc.checkInvariants(); err != nil {
+		t.Error(err)
 	}
-	fmt.Printf("%v,%v,%v\n", "Range of Keys: 320", LRUHitRate(lru), ARCHitRate(arc))
-
-	arc = NewARC(20)
-	lru = NewLru(20)
-
-	for i := 0; i < 5000; i++ {
-		rand.Seed(time.Now().UnixNano())
-		key := fmt.Sprint("k", mapToSame(rand.Intn(400))) // k30
-		arc.Set(key, []byte(""))                          // value: ""
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key2 := fmt.Sprint("k", mapToSame(rand.Intn(400)))
-
-		arc.Get(key2)
-		lru.Get(key2)
 
+	// the ghosts are still recognized, a ghost hit goes straight to T2
+	ghost := arc.b1.keys()[0]
+	arc.Set(ghost, nil)
+	if !inList(arc, listT2, ghost) {
+		t.Errorf("expected ghost %s to go to T2 after Purge", ghost)
 	}
-	fmt.Printf("%v,%v,%v\n", "Range of Keys: 400", LRUHitRate(lru), ARCHitRate(arc))
-
 }
 
-// function for testing ARC vs. LRU with different number of requests
-func TestARCMoreIterations(t *testing.T) {
-	fmt.Println("Test ARC vs LRU Iterations\n-----------------")
-	arc := NewARC(20)
-	lru := NewLru(20)
-
-	// MAIN CHANGE IS HERE (changed from 5k to 10k)
-	for i := 0; i < 10000; i++ {
-		rand.Seed(time.Now().UnixNano())
-		key := fmt.Sprint("k", mapToSame(rand.Intn(40))) // k30
-		arc.Set(key, []byte(""))                         // value: ""
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key2 := fmt.Sprint("k", mapToSame(rand.Intn(40)))
-
-		arc.Get(key2)
-		lru.Get(key2)
+// Reset leaves the cache as good as new, optionally keeping the stats
+func TestARCReset(t *testing.T) {
+	for _, clearStats := range []bool{false, true} {
+		arc := NewARC(10)
+		arc.EnableScanDetection(3)
+		warmT2(arc, seqKeys("hot", 0, 10))
+		replayKeys(seqKeys("cold", 0, 20), arc)
+		arc.Set("hot0", nil)
+		stats := arc.Stats()
+		before := *stats
+
+		evicted := evictionLog{}
+		arc.SetOnEvict(evicted.record)
+		resident := arc.Len()
+		arc.Reset(clearStats)
+
+		if arc.Len() != 0 || arc.b1.Len() != 0 || arc.b2.Len() != 0 || len(arc.index) != 0 {
+			t.Errorf("expected Reset to clear all four lists, got |T1|+|T2| = %d, |B1| = %d, |B2| = %d",
+				arc.Len(), arc.b1.Len(), arc.b2.Len())
+		}
+		if len(evicted) != resident {
+			t.Errorf("expected the %d resident keys to be evicted, got %d", resident, len(evicted))
+		}
+		if arc.p != 5 {
+			t.Errorf("expected p to be reset to 5, got %d", arc.p)
+		}
 
-	}
+		expected := before
+		if clearStats {
+			expected = Stats{}
+		}
+		if arc.Stats() != stats || !stats.Equals(&expected) {
+			t.Errorf("Reset(%v): expected stats %+v, got %+v", clearStats, expected, *arc.Stats())
+		}
 
-	if !arc.invariant() {
-		t.Errorf("INVARIANT VIOLATED")
+		// the scan detector starts over, so a new run is not a scan right away
+		arc.Set("cold20", nil)
+		if arc.scan.run != 1 {
+			t.Errorf("expected a new scan run after Reset, got a run of %d", arc.scan.run)
+		}
+		if err := arc.checkInvariants(); err != nil {
+			t.Error(err)
+		}
 	}
+}
 
-	fmt.Printf("%v,%v,%v\n", "Iterations: 10000", LRUHitRate(lru), ARCHitRate(arc))
-
-	arc = NewARC(20)
-	lru = NewLru(20)
-
-	for i := 0; i < 15000; i++ {
-		rand.Seed(time.Now().UnixNano())
-		key := fmt.Sprint("k", mapToSame(rand.Intn(40))) // k30
-		arc.Set(key, []byte(""))                         // value: ""
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key2 := fmt.Sprint("k", mapToSame(rand.Intn(40)))
-
-		arc.Get(key2)
-		lru.Get(key2)
+// Purge and Reset on LRU evict everything, Reset(true) also zeroes the stats
+func TestLRUPurgeReset(t *testing.T) {
+	lru := NewLru(5)
+	evicted := evictionLog{}
+	lru.SetOnEvict(evicted.record)
 
+	for i := 0; i < 8; i++ {
+		lru.Set(fmt.Sprint("k", i), []byte(fmt.Sprint("v", i)))
 	}
-
-	if !arc.invariant() {
-		t.Errorf("INVARIANT VIOLATED")
+	lru.Get("k7")
+	if len(evicted) != 3 {
+		t.Errorf("expected 3 evictions, got %v", evicted)
 	}
 
-	fmt.Printf("%v,%v,%v\n", "Iterations: 15000", LRUHitRate(lru), ARCHitRate(arc))
-
-	arc = NewARC(20)
-	lru = NewLru(20)
-
-	for i := 0; i < 20000; i++ {
-		rand.Seed(time.Now().UnixNano())
-		key := fmt.Sprint("k", mapToSame(rand.Intn(40))) // k30
-		arc.Set(key, []byte(""))                         // value: ""
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key2 := fmt.Sprint("k", mapToSame(rand.Intn(40)))
-
-		arc.Get(key2)
-		lru.Get(key2)
-
+	lru.Purge()
+	if lru.Len() != 0 || len(evicted) != 8 || evicted["k7"] != "v7" {
+		t.Errorf("expected Purge to evict every key, got %d left and %v evicted", lru.Len(), evicted)
 	}
-
-	if !arc.invariant() {
-		t.Errorf("INVARIANT VIOLATED")
+	if lru.Stats().Hits != 1 {
+		t.Errorf("expected Purge to keep the stats, got %+v", *lru.Stats())
 	}
 
-	fmt.Printf("%v,%v,%v\n", "Iterations: 20000", LRUHitRate(lru), ARCHitRate(arc))
-
-	arc = NewARC(20)
-	lru = NewLru(20)
-
-	for i := 0; i < 25000; i++ {
-		rand.Seed(time.Now().UnixNano())
-		key := fmt.Sprint("k", mapToSame(rand.Intn(40))) // k30
-		arc.Set(key, []byte(""))                         // value: ""
-		lru.Set(key, []byte(""))
-
-		rand.Seed(time.Now().UnixNano())
-		key2 := fmt.Sprint("k", mapToSame(rand.Intn(40)))
-
-		arc.Get(key2)
-		lru.Get(key2)
-
+	lru.Set("k0", nil)
+	stats := lru.Stats()
+	lru.Reset(true)
+	if lru.Len() != 0 || lru.Stats() != stats || !stats.Equals(&Stats{}) {
+		t.Errorf("expected Reset(true) to empty the LRU and zero its stats, got %d entries and %+v",
+			lru.Len(), *lru.Stats())
 	}
-
-	if !arc.invariant() {
-		t.Errorf("INVARIANT VIOLATED")
+	if lru.Set("k1", nil); !lru.Contains("k1") {
+		t.Errorf("expected the LRU to be usable after Reset")
 	}
-
-	fmt.Printf("%v,%v,%v\n", "Iterations: 25000", LRUHitRate(lru), ARCHitRate(arc))
 }
 
 func LRUHitRate(lru *LRU) float64 {
@@ -737,67 +422,46 @@ func ARCHitRate(arc *ARC) float64 {
 	return hits / (hits + misses) * 100
 }
 
-// function for testing ARC vs. LRU with Wikipedia 2019 trace
+// function for testing ARC vs. LRU, S3-FIFO, SIEVE and CLOCK-Pro with Wikipedia 2019 trace
 func TestWikipediaTrace(t *testing.T) {
+	if _, err := os.Stat("wiki2019.tr"); err != nil {
+		t.Skip("wiki2019.tr trace not available: ", err)
+	}
+
 	fmt.Println("Testing first 10m lines of trace with different cache sizes")
+	fmt.Println("size,LRU,ARC,S3-FIFO,SIEVE,CLOCK-Pro")
 	cacheSize := 500
 	batch := 2
 	for cacheSize <= 5_000_000 {
-		lru, arc, err := testOnTrace("wiki2019.tr", cacheSize, batch*10_000_000, (batch+1)*10_000_000)
+		caches, err := testOnTrace("wiki2019.tr", cacheSize, batch*10_000_000, (batch+1)*10_000_000)
 		if err != nil {
-			fmt.Println("Encountered error: ", err)
+			t.Fatal("Encountered error: ", err)
 		}
 
-		fmt.Printf("%v,%v,%v\n", cacheSize, LRUHitRate(lru), ARCHitRate(arc))
+		fmt.Print(cacheSize)
+		for _, cache := range caches {
+			fmt.Print(",", cache.Stats().HitRate())
+		}
+		fmt.Println()
 
 		cacheSize *= 10
 	}
 }
 
-func testOnTrace(filename string, size int, start, end int) (*LRU, *ARC, error) {
-	arc := NewARC(size)
-	lru := NewLru(size)
+// testOnTrace replays lines [start, end) of the trace in filename against
+// every policy of the given size, in the order LRU, ARC, S3-FIFO, SIEVE, CLOCK-Pro
+func testOnTrace(filename string, size int, start, end int) ([]Cache, error) {
+	caches := []Cache{NewLru(size), NewARC(size), NewS3FIFO(size), NewSIEVE(size), NewClockPro(size)}
 
 	// Open the file for reading
 	file, err := os.Open(filename)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 	defer file.Close()
 
-	// Create a new buffered reader
-	reader := bufio.NewReader(file)
-
-	i := 0
-
-	// Read the rows from the file
-	for i < end {
-		i++
-		// Read a line from the file
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			return nil, nil, err
-		}
-
-		if i < start {
-			continue
-		}
-
-		// Split the line into columns
-		columns := strings.Split(line, " ")
-
-		// Convert the columns to integers
-
-		key := columns[1]
-
-		if _, arcHit := arc.Get(key); !arcHit {
-			arc.Set(key, []byte{})
-		}
-		if _, lruHit := lru.Get(key); !lruHit {
-			lru.Set(key, []byte{})
-		}
-
+	if err := ReplayTrace(file, start, end, caches...); err != nil {
+		return nil, err
 	}
-
-	return lru, arc, nil
+	return caches, nil
 }
@@ -0,0 +1,183 @@
+// Intrusive Lists for ARC
+//
+// Dependencies: arc.go
+//
+// Description:
+// ARC keeps one index from key to entry, and every entry is linked directly
+// into exactly one of the four lists T1, T2, B1 or B2. Moving a key between
+// lists (a promotion, an eviction into a ghost list, a ghost hit) is an O(1)
+// relink of the same entry, and entries that are dropped altogether go back
+// to a free list that new keys are taken from, so a full ARC does not
+// allocate in steady state.
+
+package arc
+
+// listID names the ARC list an entry is linked into
+type listID uint8
+
+const (
+	listT1  listID = iota // recently used keys, seen once
+	listT2                // frequently used keys, seen at least twice
+	listB1                // ghosts of keys evicted from T1
+	listB2                // ghosts of keys evicted from T2
+	listNeg               // tombstones of keys known to be missing, see negative.go
+)
+
+func (id listID) String() string {
+	return [...]string{"T1", "T2", "B1", "B2", "Negative"}[id]
+}
+
+// entry is a key tracked by ARC, doubly linked into one of its lists. Ghost
+// entries (in B1 or B2) never hold a value.
+type entry struct {
+	prev  *entry
+	next  *entry
+	key   string
+	value []byte
+	slot  valueSlot // where the value is instead, in an off-heap ARC
+	list  listID
+	tags  []string // tags set with SetWithTags, see invalidate.go
+
+	compressed bool // whether the value is compressed, see codec.go
+	rawLen     int  // length of the value before the codec, 0 if it skipped the codec
+
+	version uint64 // version of the value, 0 for ghosts
+	expires int64  // when a tombstone expires, in Unix nanoseconds
+	loaded  int64  // when a LoadingARC stored the value, in Unix nanoseconds
+}
+
+// resident reports whether the entry is in the cache (T1 or T2), as opposed
+// to being a ghost or a tombstone
+func (e *entry) resident() bool {
+	return e.list == listT1 || e.list == listT2
+}
+
+// arcList is a sentinel based list of entries, sentinel.next = least recently
+// used entry, sentinel.prev = most recently used entry (same as LRU)
+type arcList struct {
+	sentinel entry
+	length   int
+}
+
+// init makes the list empty, it must be called before the list is used
+func (l *arcList) init() {
+	l.sentinel.prev = &l.sentinel
+	l.sentinel.next = &l.sentinel
+	l.length = 0
+}
+
+// Len returns the number of entries in the list
+func (l *arcList) Len() int {
+	return l.length
+}
+
+// pushMRU links e in as the most recently used entry
+func (l *arcList) pushMRU(e *entry) {
+	e.prev = l.sentinel.prev
+	e.next = &l.sentinel
+	l.sentinel.prev.next = e
+	l.sentinel.prev = e
+	l.length++
+}
+
+// pushLRU links e in as the least recently used entry
+func (l *arcList) pushLRU(e *entry) {
+	e.prev = &l.sentinel
+	e.next = l.sentinel.next
+	l.sentinel.next.prev = e
+	l.sentinel.next = e
+	l.length++
+}
+
+// lru returns the least recently used entry, or nil if the list is empty
+func (l *arcList) lru() *entry {
+	if l.sentinel.next == &l.sentinel {
+		return nil
+	}
+	return l.sentinel.next
+}
+
+// unlink removes e from the list, leaving its key and value intact
+func (l *arcList) unlink(e *entry) {
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	e.prev = nil
+	e.next = nil
+	l.length--
+}
+
+// keys returns the keys from the least to the most recently used. Used for
+// debugging and checking invariants.
+func (l *arcList) keys() []string {
+	ans := make([]string, 0, l.length)
+	for e := l.sentinel.next; e != &l.sentinel; e = e.next {
+		ans = append(ans, e.key)
+	}
+	return ans
+}
+
+/**********************************************************************************/
+// Helper functions for moving entries between the lists of an ARC
+
+// list returns the list with the given id
+func (arc *ARC) list(id listID) *arcList {
+	switch id {
+	case listT1:
+		return &arc.t1
+	case listT2:
+		return &arc.t2
+	case listB1:
+		return &arc.b1
+	case listB2:
+		return &arc.b2
+	}
+	return &arc.neg
+}
+
+// move relinks e as the most recently used entry of the list to
+func (arc *ARC) move(e *entry, to listID) {
+	arc.list(e.list).unlink(e)
+	e.list = to
+	arc.list(to).pushMRU(e)
+}
+
+// newEntry returns an entry for key, taken from the free list if possible.
+// The entry is indexed but not linked into any list yet.
+func (arc *ARC) newEntry(key string, value []byte) *entry {
+	e := arc.free
+	if e != nil {
+		arc.free = e.next
+		e.next = nil
+	} else {
+		e = &entry{}
+	}
+	e.key = key
+	arc.setValue(e, value)
+	arc.index[key] = e
+	return e
+}
+
+// drop unlinks e, removes it from the index and puts it on the free list
+func (arc *ARC) drop(e *entry) {
+	arc.unspill(e)
+	arc.list(e.list).unlink(e)
+	delete(arc.index, e.key)
+	if len(e.tags) > 0 {
+		arc.untag(e)
+	}
+
+	e.key = ""
+	arc.setValue(e, nil)
+	e.version = 0
+	e.next = arc.free
+	arc.free = e
+}
+
+// dropLRU drops the least recently used entry of the list, if there is one
+func (arc *ARC) dropLRU(id listID) {
+	if e := arc.list(id).lru(); e != nil {
+		arc.drop(e)
+	}
+}
+
+/**********************************************************************************/
@@ -0,0 +1,114 @@
+// Off-Heap Value Storage for ARC
+//
+// Dependencies: arc.go, arclist.go, slab.go
+//
+// Description:
+// With millions of keys, the values of an ARC are millions of small objects
+// that the garbage collector has to track on every cycle. An off-heap ARC
+// (NewOffHeapARC) copies every value into memory mapped outside of the Go
+// heap instead, where the GC never looks, managed by a slab allocator (see
+// slab.go). An entry only records where its value is, as a page index, an
+// offset and a length, and holds no pointer to it.
+//
+// Freed memory (on eviction, Remove or an update) is reused for the next
+// value of the same size class, so a cache in steady state stops mapping new
+// pages. Since the memory of a value is reused, Get and the eviction callback
+// hand out copies of the values.
+
+package arc
+
+// valueSlot locates a value in the pages of a slab allocator. The zero slot
+// is an empty value that takes no memory.
+type valueSlot struct {
+	page   uint32
+	offset uint32
+	length uint32
+}
+
+// NewOffHeapARC creates an ARC of the given size that stores its values off
+// the Go heap, see arena.go. Values are copied in by Set and out by Get, so
+// a value returned by Get belongs to the caller. A value can be at most one
+// slab page of 1 MiB (after compression, if a codec is set): Set of a larger
+// value returns false and the key is not cached.
+func NewOffHeapARC(size int) *ARC {
+	arc := NewARC(size)
+	arc.values = newSlabAllocator()
+	return arc
+}
+
+// storedBytes returns what e holds, its value or the compressed value,
+// without copying it
+func (arc *ARC) storedBytes(e *entry) []byte {
+	if arc.values == nil {
+		return e.value
+	}
+	return arc.values.bytes(e.slot)
+}
+
+// valueOf returns the value of e. A value off the heap is copied, its memory
+// may be reused as soon as e lets go of it. It returns false if the value is
+// compressed and cannot be decoded, see codec.go.
+func (arc *ARC) valueOf(e *entry) ([]byte, bool) {
+	stored := arc.storedBytes(e)
+	if e.compressed {
+		return arc.decode(stored)
+	}
+	if arc.values == nil {
+		return stored, true
+	}
+	value := make([]byte, len(stored))
+	copy(value, stored)
+	return value, true
+}
+
+// peekValue returns the value of e without copying it if possible. It is
+// only valid until the value of e changes. It returns false if the value
+// cannot be decoded.
+func (arc *ARC) peekValue(e *entry) ([]byte, bool) {
+	if e.compressed {
+		return arc.decode(arc.storedBytes(e))
+	}
+	return arc.storedBytes(e), true
+}
+
+// setValue replaces the value of e, a nil value clears it. The value is
+// compressed first if it is large enough, see codec.go. Off the heap,
+// setValue returns false if there is no memory for the value, and e is left
+// without a value. The caller must drop e then if it is resident.
+func (arc *ARC) setValue(e *entry, value []byte) bool {
+	arc.countEncoded(e, -1)
+	rawLen := 0
+	if arc.compressible(value) {
+		rawLen = len(value)
+	}
+	value, e.compressed = arc.encode(value)
+	if arc.values == nil {
+		e.value = value
+		e.rawLen = rawLen
+		arc.countEncoded(e, 1)
+		return true
+	}
+
+	arc.values.release(e.slot)
+	e.slot = valueSlot{}
+	for {
+		slot, ok := arc.values.alloc(value)
+		if ok {
+			e.slot = slot
+			e.rawLen = rawLen
+			arc.countEncoded(e, 1)
+			return true
+		}
+
+		class := classFor(len(value))
+		if class < 0 {
+			e.compressed = false
+			return false // larger than a page
+		}
+		if !arc.values.evictPerClass || !arc.evictFromClass(class) {
+			arc.values.classes[class].outOfMemory++
+			e.compressed = false
+			return false
+		}
+	}
+}
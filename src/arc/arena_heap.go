@@ -0,0 +1,22 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+// Off-Heap Pages on the Heap
+//
+// Dependencies: slab.go
+//
+// Description:
+// Where mmap is not available, the pages of a slab allocator are plain byte
+// slices. They are on the heap, but they hold no pointers and there are few
+// of them, so the garbage collector still has far less to track than one
+// object per value.
+
+package arc
+
+// mapPage allocates a new page of size bytes
+func mapPage(size int) ([]byte, error) {
+	return make([]byte, size), nil
+}
+
+// unmapPage leaves the page to the garbage collector
+func unmapPage(page []byte) {}
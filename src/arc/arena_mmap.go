@@ -0,0 +1,24 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+// Off-Heap Pages from mmap
+//
+// Dependencies: slab.go
+//
+// Description:
+// On Unix systems the pages of a slab allocator are anonymous private
+// mappings, which the Go runtime knows nothing about.
+
+package arc
+
+import "syscall"
+
+// mapPage maps a new page of size bytes
+func mapPage(size int) ([]byte, error) {
+	return syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+}
+
+// unmapPage unmaps a page returned by mapPage
+func unmapPage(page []byte) {
+	syscall.Munmap(page)
+}
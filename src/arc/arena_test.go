@@ -0,0 +1,124 @@
+package arc
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	"cos316.princeton.edu/final_proj/workload"
+)
+
+// an off-heap ARC makes the same decisions as one on the heap, and gives back
+// the same values, evicted ones included
+func TestOffHeapARCMatchesHeap(t *testing.T) {
+	r := newTestRand(t)
+	heap, offHeap := NewARC(50), NewOffHeapARC(50)
+	var heapEvicted, offHeapEvicted []string
+	heap.SetOnEvict(func(key string, value []byte) {
+		heapEvicted = append(heapEvicted, key+"="+string(value))
+	})
+	offHeap.SetOnEvict(func(key string, value []byte) {
+		offHeapEvicted = append(offHeapEvicted, key+"="+string(value))
+	})
+
+	gen := workload.NewZipf(r.Int63(), "k", 200, 0.9)
+	for i, op := range workload.New(r.Int63(), gen, 0.3).Ops(20000) {
+		var expected, got []byte
+		var expectedOK, gotOK bool
+		switch {
+		case r.Intn(20) == 0:
+			expected, expectedOK = heap.Remove(op.Key)
+			got, gotOK = offHeap.Remove(op.Key)
+		case op.Write:
+			// values of all sizes, empty ones too
+			value := bytes.Repeat([]byte{byte(i)}, r.Intn(100))
+			heap.Set(op.Key, value)
+			offHeap.Set(op.Key, value)
+			continue
+		default:
+			expected, expectedOK = heap.Get(op.Key)
+			got, gotOK = offHeap.Get(op.Key)
+		}
+		if gotOK != expectedOK || !bytes.Equal(got, expected) {
+			t.Fatalf("op %d on %s returned %v, %v, expected %v, %v", i, op.Key, got, gotOK, expected, expectedOK)
+		}
+	}
+
+	if diff := sameState(heap, offHeap); diff != "" {
+		t.Errorf("expected the same state, but %s", diff)
+	}
+	if fmt.Sprint(heapEvicted) != fmt.Sprint(offHeapEvicted) {
+		t.Errorf("expected the same evictions, got %d and %d", len(heapEvicted), len(offHeapEvicted))
+	}
+	if err := offHeap.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+// evicted blocks are reused, so churning a full cache maps no new memory, and
+// values handed out are copies
+func TestOffHeapARCReusesBlocks(t *testing.T) {
+	arc := NewOffHeapARC(100)
+	for _, key := range benchKeys("k", 100_000) {
+		arc.Set(key, []byte("value of "+key))
+	}
+	if len(arc.values.pages) != 1 {
+		t.Errorf("expected a single page to hold 100 values, got %d", len(arc.values.pages))
+	}
+
+	value, _ := arc.Get("k99999")
+	value[0] = 'V'
+	if value, _ := arc.Get("k99999"); string(value) != "value of k99999" {
+		t.Errorf("expected Get to return a copy, the cached value became %q", value)
+	}
+}
+
+// a full ARC of n keys with 64-byte values, half of them in T2
+func newGCTestARC(n int, offHeap bool) *ARC {
+	arc := NewARC(n)
+	if offHeap {
+		arc = NewOffHeapARC(n)
+	}
+	for i, key := range benchKeys("k", n) {
+		arc.Set(key, make([]byte, 64))
+		if i%2 == 0 {
+			arc.Get(key)
+		}
+	}
+	return arc
+}
+
+// duration of a full garbage collection with a big ARC on the heap, with its
+// values on the heap or off it. The values of a heap ARC are one object per
+// key that every GC cycle has to mark. Most of the marking runs concurrently,
+// pause-ns/op is the part that stops the world.
+func BenchmarkARCGarbageCollection(b *testing.B) {
+	for _, n := range []int{100_000, 1_000_000} {
+		for _, offHeap := range []bool{false, true} {
+			name := fmt.Sprint(n, "/heap")
+			if offHeap {
+				name = fmt.Sprint(n, "/offheap")
+			}
+			b.Run(name, func(b *testing.B) {
+				arc := newGCTestARC(n, offHeap)
+				runtime.GC()
+
+				var pauses time.Duration
+				var before, after runtime.MemStats
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					runtime.ReadMemStats(&before)
+					runtime.GC()
+					runtime.ReadMemStats(&after)
+					pauses += time.Duration(after.PauseTotalNs - before.PauseTotalNs)
+				}
+				b.StopTimer()
+				b.ReportMetric(float64(pauses.Nanoseconds())/float64(b.N), "pause-ns/op")
+				b.ReportMetric(float64(after.HeapObjects), "heap-objects")
+				runtime.KeepAlive(arc)
+			})
+		}
+	}
+}
@@ -0,0 +1,404 @@
+// Store-Backed ARC
+//
+// Dependencies: arc.go, arclist.go, remote.go, version.go
+//
+// Description:
+// BackedARC puts ARC in front of a backing Store, such as a slow key-value
+// file store, and owns the writes to it. A miss loads the key from the store,
+// and every Set and Remove goes to the store in one of two modes:
+//   - WriteThrough writes the store first, on every Set and Remove, and only
+//     then the cache. The cache never holds anything the store does not.
+//   - WriteBack only writes the cache, and marks the key dirty. Dirty values
+//     are written to the store when ARC evicts them from T1 or T2, on Flush,
+//     and every flush interval (see SetFlushInterval). Removals are queued
+//     the same way and written on the next flush.
+//
+// A dirty value is never lost: when it is evicted, it moves to a queue of
+// pending writes until the store has it, and Get serves the keys in the
+// queue from there. The Get or Set that evicts a value writes it right away,
+// but only that value: a write that fails stays dirty or pending, and is
+// tried again on the next flush, so a store that is down does not make every
+// call retry the whole queue. The queue grows while the store is down, see
+// StoreStats.Pending. Writes to the store are never made concurrently, so a
+// flush can never overwrite a newer value with an older one.
+
+package arc
+
+import (
+	"sync"
+	"time"
+)
+
+// Store is the backing store of a BackedARC. Load returns ErrNotFound for a
+// key the store does not have.
+type Store interface {
+	Load(key string) ([]byte, error)
+	Store(key string, value []byte) error
+	Delete(key string) error
+}
+
+// WriteMode decides when a BackedARC writes to its store
+type WriteMode int
+
+const (
+	WriteThrough WriteMode = iota // write the store on every Set and Remove
+	WriteBack                     // write the store on eviction and on flushes
+)
+
+// StoreStats counts the writes of a BackedARC to its store
+type StoreStats struct {
+	Writes      int // values written
+	Deletes     int // keys deleted
+	WriteErrors int // writes and deletes that failed
+	Pending     int // evicted values and removals waiting for the store
+}
+
+// pendingWrite is a write that has yet to reach the store
+type pendingWrite struct {
+	value   []byte
+	deleted bool   // the key was removed
+	version uint64 // version of the write, to tell if it was superseded
+}
+
+// BackedARC is an ARC in front of a Store. It is safe for concurrent use.
+type BackedARC struct {
+	mu    sync.Mutex
+	arc   *ARC
+	store Store
+	mode  WriteMode
+
+	dirty   map[string]bool         // cached keys whose value the store does not have
+	pending map[string]pendingWrite // evicted dirty values and queued removals
+	evicted []string                // keys queued by the current call, which writes them
+
+	// fills maps the keys being loaded after a miss to the number of their
+	// load. A write of the key deletes it, so the loaded value is not cached.
+	fills    map[string]int
+	lastFill int
+
+	storeMu sync.Mutex // held while writing to the store
+	stats   StoreStats // guarded by mu
+
+	stopFlusher chan struct{} // stops the flush timer, nil if there is none
+	flusherDone chan struct{}
+}
+
+// NewBackedARC creates an ARC of the given size in front of store, that
+// writes to it in the given mode
+func NewBackedARC(size int, store Store, mode WriteMode) *BackedARC {
+	c := &BackedARC{
+		arc:     NewARC(size),
+		store:   store,
+		mode:    mode,
+		dirty:   make(map[string]bool),
+		pending: make(map[string]pendingWrite),
+		fills:   make(map[string]int),
+	}
+	c.arc.SetOnEvict(c.onEvict)
+	return c
+}
+
+// Get returns the value of key, from the cache or the store. Errors of the
+// store are returned, ErrNotFound if it does not have the key.
+func (c *BackedARC) Get(key string) ([]byte, error) {
+	c.mu.Lock()
+	if value, ok := c.arc.Get(key); ok {
+		c.mu.Unlock()
+		return value, nil
+	}
+	if w, ok := c.pending[key]; ok {
+		c.mu.Unlock()
+		if w.deleted {
+			return nil, ErrNotFound
+		}
+		return w.value, nil
+	}
+	c.lastFill++
+	fill := c.lastFill
+	c.fills[key] = fill
+	c.mu.Unlock()
+
+	value, err := c.store.Load(key)
+
+	c.mu.Lock()
+	current := c.fills[key] == fill
+	if current {
+		delete(c.fills, key)
+	}
+	if err != nil || !current {
+		c.mu.Unlock()
+		return value, err // a value written since is not replaced with the one loaded
+	}
+	c.arc.Set(key, value)
+	evicted := c.takeEvicted()
+	c.mu.Unlock()
+
+	c.writeEvicted(evicted)
+	return value, nil
+}
+
+// Set puts a key-value pair into the cache and, depending on the mode, into
+// the store. In WriteThrough mode the error of the store is returned, and the
+// cache is left as it was. In WriteBack mode Set always succeeds.
+func (c *BackedARC) Set(key string, value []byte) error {
+	if c.mode == WriteThrough {
+		c.storeMu.Lock()
+		defer c.storeMu.Unlock()
+		err := c.store.Store(key, value)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if err != nil {
+			c.stats.WriteErrors++
+			return err
+		}
+		c.stats.Writes++
+		delete(c.fills, key)
+		c.arc.Set(key, value)
+		return nil
+	}
+
+	c.mu.Lock()
+	delete(c.fills, key)
+	delete(c.pending, key) // superseded by the new value
+	if c.arc.Set(key, value) {
+		c.dirty[key] = true
+	} else {
+		// the cache has no room for the value, it goes to the store directly
+		delete(c.dirty, key)
+		c.pending[key] = pendingWrite{value: value, version: c.arc.nextVersion()}
+		c.evicted = append(c.evicted, key)
+	}
+	evicted := c.takeEvicted()
+	c.mu.Unlock()
+
+	c.writeEvicted(evicted)
+	return nil
+}
+
+// Remove removes key from the cache and the store, and returns the value it
+// had in the cache, if any. In WriteThrough mode the error of the store is
+// returned, and the cache is left as it was. In WriteBack mode the removal
+// from the store is queued.
+func (c *BackedARC) Remove(key string) ([]byte, bool, error) {
+	if c.mode == WriteThrough {
+		c.storeMu.Lock()
+		defer c.storeMu.Unlock()
+		err := c.store.Delete(key)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if err != nil {
+			c.stats.WriteErrors++
+			return nil, false, err
+		}
+		c.stats.Deletes++
+		delete(c.fills, key)
+		value, ok := c.arc.Remove(key)
+		return value, ok, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.fills, key)
+	delete(c.dirty, key)
+	value, version, ok := c.arc.RemoveVersioned(key)
+	c.pending[key] = pendingWrite{deleted: true, version: version}
+	return value, ok, nil
+}
+
+// Flush writes every dirty value and queued removal to the store, and
+// returns the first error. Writes that fail are tried again on the next
+// flush. In WriteThrough mode there is nothing to flush.
+func (c *BackedARC) Flush() error {
+	c.storeMu.Lock()
+	defer c.storeMu.Unlock()
+	return c.flush(true)
+}
+
+// SetFlushInterval makes a WriteBack cache flush every interval, in the
+// background. An interval <= 0 stops the background flushes.
+func (c *BackedARC) SetFlushInterval(interval time.Duration) {
+	c.mu.Lock()
+	stop, done := c.stopFlusher, c.flusherDone
+	c.stopFlusher, c.flusherDone = nil, nil
+	if interval > 0 && c.mode == WriteBack {
+		c.stopFlusher, c.flusherDone = make(chan struct{}), make(chan struct{})
+		go c.flushEvery(interval, c.stopFlusher, c.flusherDone)
+	}
+	c.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// Close stops the background flushes and flushes one last time
+func (c *BackedARC) Close() error {
+	c.SetFlushInterval(0)
+	return c.Flush()
+}
+
+// Dirty returns the number of keys whose latest write has not reached the
+// store yet
+func (c *BackedARC) Dirty() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.dirty) + len(c.pending)
+}
+
+// StoreStats returns the writes to the store so far, and the number of writes
+// waiting in the queue
+func (c *BackedARC) StoreStats() StoreStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Pending = len(c.pending)
+	return stats
+}
+
+// Len returns the number of entries in the cache
+func (c *BackedARC) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.arc.Len()
+}
+
+// MaxSize returns the number of entries supported by the cache
+func (c *BackedARC) MaxSize() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.arc.MaxSize()
+}
+
+// Stats returns a snapshot of the hits and misses so far
+func (c *BackedARC) Stats() *Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := *c.arc.Stats()
+	return &stats
+}
+
+// report hits/misses from Get calls to stdout
+func (c *BackedARC) ReportStats() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.arc.ReportStats()
+}
+
+// onEvict is the eviction callback of the ARC. A dirty value that is evicted
+// is queued to be written. Called with c.mu held.
+func (c *BackedARC) onEvict(key string, value []byte) {
+	if !c.dirty[key] {
+		return
+	}
+	delete(c.dirty, key)
+	c.pending[key] = pendingWrite{value: value, version: c.arc.index[key].version}
+	c.evicted = append(c.evicted, key)
+}
+
+// takeEvicted returns the keys queued since the last call. Must be called
+// with c.mu held.
+func (c *BackedARC) takeEvicted() []string {
+	evicted := c.evicted
+	c.evicted = nil
+	return evicted
+}
+
+// writeEvicted writes the queued writes of keys, which the caller evicted,
+// to the store. Writes that fail stay queued for the next flush.
+func (c *BackedARC) writeEvicted(keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	c.storeMu.Lock()
+	defer c.storeMu.Unlock()
+
+	c.mu.Lock()
+	writes := make(map[string]pendingWrite, len(keys))
+	for _, key := range keys {
+		if w, ok := c.pending[key]; ok {
+			writes[key] = w
+		}
+	}
+	c.mu.Unlock()
+	c.write(writes)
+}
+
+// flush writes the queued writes to the store, and the dirty values as well
+// if all is set. Returns the first error. Must be called with c.storeMu held.
+func (c *BackedARC) flush(all bool) error {
+	c.mu.Lock()
+	writes := make(map[string]pendingWrite, len(c.pending))
+	for key, w := range c.pending {
+		writes[key] = w
+	}
+	if all {
+		for key := range c.dirty {
+			e := c.arc.index[key]
+			value, _ := c.arc.valueOf(e) // the ARC has no codec
+			writes[key] = pendingWrite{value: value, version: e.version}
+		}
+	}
+	c.mu.Unlock()
+	return c.write(writes)
+}
+
+// write writes writes to the store, and returns the first error. Must be
+// called with c.storeMu held.
+func (c *BackedARC) write(writes map[string]pendingWrite) error {
+	var first error
+	for key, w := range writes {
+		var err error
+		if w.deleted {
+			err = c.store.Delete(key)
+		} else {
+			err = c.store.Store(key, w.value)
+		}
+
+		c.mu.Lock()
+		if err != nil {
+			c.stats.WriteErrors++
+			if first == nil {
+				first = err
+			}
+		} else {
+			c.written(key, w)
+		}
+		c.mu.Unlock()
+	}
+	return first
+}
+
+// written records that w reached the store. The key stays dirty or pending if
+// it was written again in the meantime. Must be called with c.mu held.
+func (c *BackedARC) written(key string, w pendingWrite) {
+	if w.deleted {
+		c.stats.Deletes++
+	} else {
+		c.stats.Writes++
+	}
+
+	if p, ok := c.pending[key]; ok && p.version == w.version {
+		delete(c.pending, key)
+	}
+	if e, ok := c.arc.index[key]; ok && c.dirty[key] && e.version == w.version {
+		delete(c.dirty, key)
+	}
+}
+
+// flushEvery flushes every interval until stop is closed
+func (c *BackedARC) flushEvery(interval time.Duration, stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.Flush()
+		case <-stop:
+			return
+		}
+	}
+}
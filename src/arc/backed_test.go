@@ -0,0 +1,208 @@
+package arc
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memStore is a Store in memory that counts its writes. While down, every
+// call fails.
+type memStore struct {
+	mu     sync.Mutex
+	values map[string]string
+	writes int
+	down   bool
+}
+
+func newMemStore() *memStore {
+	return &memStore{values: make(map[string]string)}
+}
+
+func (s *memStore) Load(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down {
+		return nil, errors.New("store down")
+	}
+	value, ok := s.values[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return []byte(value), nil
+}
+
+func (s *memStore) Store(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down {
+		return errors.New("store down")
+	}
+	s.values[key] = string(value)
+	s.writes++
+	return nil
+}
+
+func (s *memStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down {
+		return errors.New("store down")
+	}
+	delete(s.values, key)
+	return nil
+}
+
+func (s *memStore) setDown(down bool) {
+	s.mu.Lock()
+	s.down = down
+	s.mu.Unlock()
+}
+
+func (s *memStore) get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.values[key]
+	return value, ok
+}
+
+// checkBackedGet fails the test unless Get returns the expected value
+func checkBackedGet(t *testing.T, cache *BackedARC, key, expected string) {
+	t.Helper()
+	if value, err := cache.Get(key); err != nil || string(value) != expected {
+		t.Errorf("Get(%s) = %q, %v, expected %q", key, value, err, expected)
+	}
+}
+
+// write-through writes the store before the cache, and a failed write
+// leaves both as they were
+func TestBackedARCWriteThrough(t *testing.T) {
+	store := newMemStore()
+	store.values["a"] = "stored"
+	cache := NewBackedARC(4, store, WriteThrough)
+
+	checkBackedGet(t, cache, "a", "stored")
+	if _, err := cache.Get("missing"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound for a key the store does not have, got %v", err)
+	}
+
+	if err := cache.Set("a", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := store.get("a"); value != "new" || cache.Dirty() != 0 {
+		t.Errorf("expected the Set to reach the store, got %q", value)
+	}
+
+	store.setDown(true)
+	if err := cache.Set("a", []byte("lost")); err == nil {
+		t.Errorf("expected the error of the store")
+	}
+	if _, _, err := cache.Remove("a"); err == nil {
+		t.Errorf("expected the error of the store")
+	}
+	checkBackedGet(t, cache, "a", "new")
+
+	store.setDown(false)
+	if _, ok, err := cache.Remove("a"); !ok || err != nil {
+		t.Errorf("expected a to be removed, got %v, %v", ok, err)
+	}
+	if _, ok := store.get("a"); ok {
+		t.Errorf("expected a to be deleted from the store")
+	}
+	if stats := cache.StoreStats(); stats.Writes != 1 || stats.Deletes != 1 || stats.WriteErrors != 2 {
+		t.Errorf("unexpected store stats %+v", stats)
+	}
+}
+
+// write-back keeps writes in the cache until they are flushed
+func TestBackedARCWriteBack(t *testing.T) {
+	store := newMemStore()
+	store.values["gone"] = "stored"
+	cache := NewBackedARC(10, store, WriteBack)
+	for i := 0; i < 5; i++ {
+		cache.Set("k", []byte(fmt.Sprint(i)))
+		cache.Set(fmt.Sprint("k", i), []byte("v"))
+	}
+	cache.Remove("gone")
+
+	if store.writes != 0 || cache.Dirty() != 7 {
+		t.Errorf("expected 7 dirty keys and no writes, got %d dirty and %d writes", cache.Dirty(), store.writes)
+	}
+	if _, err := cache.Get("gone"); err != ErrNotFound {
+		t.Errorf("expected a queued removal to hide the stored value, got %v", err)
+	}
+
+	if err := cache.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := store.get("k"); value != "4" || store.writes != 6 || cache.Dirty() != 0 {
+		t.Errorf("expected one write per key with the latest value, got k = %q and %d writes", value, store.writes)
+	}
+	if _, ok := store.get("gone"); ok {
+		t.Errorf("expected the removal to be flushed")
+	}
+	if cache.Flush(); store.writes != 6 {
+		t.Errorf("expected nothing to write after a flush")
+	}
+}
+
+// dirty values are written when they are evicted, and are kept until the
+// store has them
+func TestBackedARCEviction(t *testing.T) {
+	store := newMemStore()
+	cache := NewBackedARC(2, store, WriteBack)
+	cache.Set("a", []byte("a"))
+	cache.Set("b", []byte("b"))
+	cache.Set("c", []byte("c"))
+	if value, _ := store.get("a"); value != "a" || cache.Dirty() != 2 {
+		t.Errorf("expected the evicted value of a to be written, got %q", value)
+	}
+
+	// while the store is down, evicted values wait for it
+	store.setDown(true)
+	for i := 0; i < 10; i++ {
+		cache.Set(fmt.Sprint("k", i), []byte(fmt.Sprint(i)))
+	}
+	checkBackedGet(t, cache, "b", "b")
+	checkBackedGet(t, cache, "k0", "0")
+	// each Set only tried to write the value it evicted
+	if stats := cache.StoreStats(); stats.WriteErrors != 10 || stats.Pending != 10 {
+		t.Errorf("expected 10 failed writes and 10 pending, got %+v", stats)
+	}
+	if err := cache.Flush(); err == nil || cache.Dirty() != 12 {
+		t.Errorf("expected the flush to fail and keep 12 dirty keys, got %v, %d", err, cache.Dirty())
+	}
+
+	store.setDown(false)
+	if err := cache.Close(); err != nil || cache.Dirty() != 0 {
+		t.Fatalf("expected every dirty key to be written, got %v, %d dirty", err, cache.Dirty())
+	}
+	for i := 0; i < 10; i++ {
+		if value, _ := store.get(fmt.Sprint("k", i)); value != fmt.Sprint(i) {
+			t.Errorf("expected k%d = %d in the store, got %q", i, i, value)
+		}
+	}
+	if value, _ := store.get("b"); value != "b" {
+		t.Errorf("expected b in the store, got %q", value)
+	}
+}
+
+// dirty values are flushed in the background
+func TestBackedARCFlushInterval(t *testing.T) {
+	store := newMemStore()
+	cache := NewBackedARC(10, store, WriteBack)
+	cache.SetFlushInterval(time.Millisecond)
+	defer cache.Close()
+
+	cache.Set("a", []byte("a"))
+	for deadline := time.Now().Add(time.Second); cache.Dirty() > 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a to be flushed within a second")
+		}
+	}
+	if value, _ := store.get("a"); value != "a" {
+		t.Errorf("expected a in the store, got %q", value)
+	}
+}
@@ -0,0 +1,51 @@
+// Batch Operations for ARC
+//
+// Dependencies: arc.go
+//
+// Description:
+// GetMany, SetMany and RemoveMany apply a whole batch of operations, one key
+// after the other, exactly as the same sequence of Get, Set and Remove calls
+// would: recency, p and the stats are updated in the same order. The results
+// are returned per key, in the order of the batch. On their own they only
+// save the caller a loop; their point is ConcurrentARC, which runs a batch in
+// a single critical section.
+
+package arc
+
+// KeyValue is a key-value pair, the unit of SetMany
+type KeyValue struct {
+	Key   string
+	Value []byte
+}
+
+// GetMany looks up every key in order, as Get would. values[i] and found[i]
+// are the results for keys[i].
+func (arc *ARC) GetMany(keys []string) (values [][]byte, found []bool) {
+	values = make([][]byte, len(keys))
+	found = make([]bool, len(keys))
+	for i, key := range keys {
+		values[i], found[i] = arc.Get(key)
+	}
+	return values, found
+}
+
+// SetMany puts every key-value pair into cache in order, as Set would.
+// stored[i] is the result for pairs[i].
+func (arc *ARC) SetMany(pairs []KeyValue) (stored []bool) {
+	stored = make([]bool, len(pairs))
+	for i, pair := range pairs {
+		stored[i] = arc.Set(pair.Key, pair.Value)
+	}
+	return stored
+}
+
+// RemoveMany removes every key in order, as Remove would. values[i] and
+// found[i] are the results for keys[i].
+func (arc *ARC) RemoveMany(keys []string) (values [][]byte, found []bool) {
+	values = make([][]byte, len(keys))
+	found = make([]bool, len(keys))
+	for i, key := range keys {
+		values[i], found[i] = arc.Remove(key)
+	}
+	return values, found
+}
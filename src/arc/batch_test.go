@@ -0,0 +1,160 @@
+package arc
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"cos316.princeton.edu/final_proj/workload"
+)
+
+// batchOp is one request of a replay, kind is "get", "set" or "remove"
+type batchOp struct {
+	kind string
+	key  string
+}
+
+// batchResult is the result of one request, for every kind of request
+type batchResult struct {
+	value []byte
+	ok    bool
+}
+
+// sameState returns a description of the first difference between two ARCs,
+// or "" if their lists, p and stats agree
+func sameState(a, b *ARC) string {
+	for _, id := range []listID{listT1, listT2, listB1, listB2} {
+		keysA, keysB := a.list(id).keys(), b.list(id).keys()
+		if !reflect.DeepEqual(keysA, keysB) {
+			return fmt.Sprintf("%s is %v and %v", id, keysA, keysB)
+		}
+	}
+	if a.p != b.p {
+		return fmt.Sprintf("p is %d and %d", a.p, b.p)
+	}
+	if !a.stats.Equals(b.stats) {
+		return fmt.Sprintf("stats are %+v and %+v", *a.stats, *b.stats)
+	}
+	return ""
+}
+
+// replaying a trace in batches leaves ARC in exactly the state of replaying
+// it one request at a time, with the same result for every request
+func TestARCBatchMatchesSequential(t *testing.T) {
+	r := newTestRand(t)
+	gen := workload.NewZipf(r.Int63(), "k", 200, 0.9)
+	var ops []batchOp
+	for _, op := range workload.New(r.Int63(), gen, 0.3).Ops(20000) {
+		switch {
+		case r.Intn(20) == 0:
+			ops = append(ops, batchOp{"remove", op.Key})
+		case op.Write:
+			ops = append(ops, batchOp{"set", op.Key})
+		default:
+			ops = append(ops, batchOp{"get", op.Key})
+		}
+	}
+
+	sequential := NewARC(50)
+	var expected []batchResult
+	for _, op := range ops {
+		var result batchResult
+		switch op.kind {
+		case "get":
+			result.value, result.ok = sequential.Get(op.key)
+		case "set":
+			result.ok = sequential.Set(op.key, []byte(op.key))
+		case "remove":
+			result.value, result.ok = sequential.Remove(op.key)
+		}
+		expected = append(expected, result)
+	}
+
+	// cut the trace into batches of up to 64 requests of the same kind
+	batched := NewARC(50)
+	var got []batchResult
+	for start := 0; start < len(ops); {
+		end, limit := start+1, start+1+r.Intn(64)
+		for end < len(ops) && end < limit && ops[end].kind == ops[start].kind {
+			end++
+		}
+		keys := make([]string, 0, end-start)
+		pairs := make([]KeyValue, 0, end-start)
+		for _, op := range ops[start:end] {
+			keys = append(keys, op.key)
+			pairs = append(pairs, KeyValue{op.key, []byte(op.key)})
+		}
+
+		var values [][]byte
+		var found []bool
+		switch ops[start].kind {
+		case "get":
+			values, found = batched.GetMany(keys)
+		case "set":
+			found = batched.SetMany(pairs)
+			values = make([][]byte, len(found))
+		case "remove":
+			values, found = batched.RemoveMany(keys)
+		}
+		for i := range found {
+			got = append(got, batchResult{values[i], found[i]})
+		}
+		start = end
+	}
+
+	for i := range expected {
+		if got[i].ok != expected[i].ok || string(got[i].value) != string(expected[i].value) {
+			t.Fatalf("request %d (%s %s): batched result %q, %v, sequential %q, %v", i,
+				ops[i].kind, ops[i].key, got[i].value, got[i].ok, expected[i].value, expected[i].ok)
+		}
+	}
+	if diff := sameState(batched, sequential); diff != "" {
+		t.Errorf("batched and sequential replays differ: %s", diff)
+	}
+	if err := batched.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+// a batch is applied atomically, other goroutines never see half of it
+func TestConcurrentARCBatchesAreAtomic(t *testing.T) {
+	cache := NewConcurrentARC(100)
+	keys := []string{"x", "y", "z"}
+	var wg sync.WaitGroup
+
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				value := []byte(fmt.Sprint(w, "-", i))
+				cache.SetMany([]KeyValue{{"x", value}, {"y", value}, {"z", value}})
+				cache.Set(fmt.Sprint("filler", w, "-", i), nil) // keeps evicting
+			}
+		}(w)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 2000; i++ {
+			values, found := cache.GetMany(keys)
+			if found[0] && found[1] && found[2] &&
+				(string(values[0]) != string(values[1]) || string(values[1]) != string(values[2])) {
+				t.Errorf("GetMany saw a partial SetMany: %q", values)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	cache.Do(func(arc *ARC) {
+		if err := arc.checkInvariants(); err != nil {
+			t.Error(err)
+		}
+	})
+	if stats := cache.Stats(); stats.Hits+stats.Misses != 3*2000 {
+		t.Errorf("expected 6000 lookups to be counted, got %+v", *stats)
+	}
+}
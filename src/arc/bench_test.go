@@ -0,0 +1,243 @@
+package arc
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// testdata/bench holds the ARC benchmarks run with
+//
+//	go test -run '^$' -bench ARC -benchmem -count 6 ./arc
+//
+// on the layout with four LRUs and their own maps (four-lrus.txt), and on the
+// single index with intrusive lists that replaced it (single-index.txt).
+// Compare them with benchstat, or rerun the command on the commit before
+// the single index to get a baseline for the machine at hand.
+
+// size of the caches under benchmark
+const benchSize = 1024
+
+// benchKeys pre-formats n keys, so formatting is not part of the measurements
+func benchKeys(prefix string, n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprint(prefix, i)
+	}
+	return keys
+}
+
+// newWarmARC returns a full ARC whose keys all sit in T2
+func newWarmARC(keys []string) *ARC {
+	arc := NewARC(len(keys))
+	for _, key := range keys {
+		arc.Set(key, []byte(key))
+		arc.Get(key)
+	}
+	return arc
+}
+
+/******************************************************************************/
+/*                                   ARC                                      */
+/******************************************************************************/
+
+// Get of a key in T2
+func BenchmarkARCGetHit(b *testing.B) {
+	keys := benchKeys("k", benchSize)
+	arc := newWarmARC(keys)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		arc.Get(keys[i%len(keys)])
+	}
+}
+
+// Get of a key that is in none of the lists
+func BenchmarkARCGetMiss(b *testing.B) {
+	arc := newWarmARC(benchKeys("k", benchSize))
+	missing := benchKeys("missing", benchSize)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		arc.Get(missing[i%len(missing)])
+	}
+}
+
+// Set of a key that was never seen, on a full cache
+func BenchmarkARCSetNew(b *testing.B) {
+	arc := newWarmARC(benchKeys("k", benchSize))
+	fresh := benchKeys("new", 1<<20)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		arc.Set(fresh[i%len(fresh)], nil)
+	}
+}
+
+// Set of a key in T2
+func BenchmarkARCSetUpdate(b *testing.B) {
+	keys := benchKeys("k", benchSize)
+	arc := newWarmARC(keys)
+	value := []byte("value")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		arc.Set(keys[i%len(keys)], value)
+	}
+}
+
+// Set of a new key followed by a Get that promotes it from T1 to T2. Once the
+// cache is full the Set also evicts a key, so this is the whole life of a key
+// that is seen twice.
+func BenchmarkARCPromotion(b *testing.B) {
+	arc := NewARC(benchSize)
+	keys := benchKeys("k", 1<<20)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := keys[i%len(keys)]
+		arc.Set(key, nil)
+		arc.Get(key)
+	}
+}
+
+// Set of keys drawn from twice the cache size, so most misses are found in
+// B1 or B2 and move p
+func BenchmarkARCGhostHit(b *testing.B) {
+	arc := NewARC(benchSize)
+	keys := benchKeys("k", 2*benchSize)
+	r := rand.New(rand.NewSource(316))
+	order := make([]string, 1<<16)
+	for i := range order {
+		order[i] = keys[r.Intn(len(keys))]
+	}
+	for _, key := range order {
+		arc.Set(key, nil) // warm up the ghost lists
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		arc.Set(order[i%len(order)], nil)
+	}
+}
+
+func BenchmarkARCGetHitParallel(b *testing.B) {
+	keys := benchKeys("k", benchSize)
+	cache := &ConcurrentARC{arc: newWarmARC(keys)}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			cache.Get(keys[i%len(keys)])
+		}
+	})
+}
+
+func BenchmarkARCSetNewParallel(b *testing.B) {
+	cache := &ConcurrentARC{arc: newWarmARC(benchKeys("k", benchSize))}
+	fresh := benchKeys("new", 1<<20)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := rand.Intn(len(fresh)); pb.Next(); i++ {
+			cache.Set(fresh[i%len(fresh)], nil)
+		}
+	})
+}
+
+func BenchmarkARCMixedParallel(b *testing.B) {
+	keys := benchKeys("k", 4*benchSize)
+	cache := &ConcurrentARC{arc: newWarmARC(keys[:benchSize])}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			key := keys[r.Intn(len(keys))]
+			if _, hit := cache.Get(key); !hit {
+				cache.Set(key, nil)
+			}
+		}
+	})
+}
+
+/******************************************************************************/
+/*                                   LRU                                      */
+/******************************************************************************/
+
+func BenchmarkLRUGetHit(b *testing.B) {
+	keys := benchKeys("k", benchSize)
+	lru := NewLru(benchSize)
+	for _, key := range keys {
+		lru.Set(key, nil)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lru.Get(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkLRUSetNew(b *testing.B) {
+	lru := NewLru(benchSize)
+	fresh := benchKeys("new", 1<<20)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lru.Set(fresh[i%len(fresh)], nil)
+	}
+}
+
+// a promotion from T1 to T2 relinks the existing node instead of allocating
+func TestARCPromotionDoesNotAllocate(t *testing.T) {
+	keys := benchKeys("k", 100)
+	arc := NewARC(len(keys))
+	for _, key := range keys {
+		arc.Set(key, nil) // all keys start out in T1
+	}
+
+	i := 0
+	allocs := testing.AllocsPerRun(50, func() {
+		arc.Get(keys[i]) // promotes a different key every run
+		i++
+	})
+	if allocs != 0 {
+		t.Errorf("expected a T1 to T2 promotion not to allocate, got %v allocs", allocs)
+	}
+	if arc.t2.Len() != i {
+		t.Errorf("expected %d keys to be promoted to T2, got %d", i, arc.t2.Len())
+	}
+}
+
+// once the ghost lists are full, a new key reuses the entry of the ghost it
+// drops instead of allocating one
+func TestARCSetNewReusesEntries(t *testing.T) {
+	keys := benchKeys("k", 300)
+	arc := NewARC(100)
+	for _, key := range keys[:200] {
+		arc.Set(key, nil) // fills T1 and B1
+	}
+
+	i := 200
+	allocs := testing.AllocsPerRun(50, func() {
+		arc.Set(keys[i], nil)
+		i++
+	})
+	if allocs != 0 {
+		t.Errorf("expected a new key on a full ARC not to allocate, got %v allocs", allocs)
+	}
+	if err := arc.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,308 @@
+// CLOCK-Pro Cache Implementation
+//
+// Dependencies: utility.go
+//
+// Description:
+// CLOCK-Pro (Jiang, Chen and Zhang, USENIX ATC '05) is the page replacement
+// algorithm behind Linux's page cache designs. It approximates LIRS with a
+// single circular list (the clock) that holds three kinds of pages:
+//   - hot pages, resident pages with a short reuse distance
+//   - cold pages, resident pages that are on probation
+//   - test pages, non-resident cold pages whose keys are remembered for a
+//     while (their "test period"), like the ghost lists of ARC
+//
+// Three hands sweep the clock. HAND_cold evicts unreferenced cold pages (they
+// become test pages) and promotes referenced ones to hot. HAND_hot demotes
+// hot pages that were not referenced since its last pass. HAND_test ends the
+// test period of the oldest test pages. A miss on a test page means the cold
+// allocation was too small, so the target number of cold pages grows; a test
+// period that ends without a reuse shrinks it again.
+
+package arc
+
+import "fmt"
+
+// the three kinds of pages kept on the clock
+type clockProPage int
+
+const (
+	clockProHot clockProPage = iota
+	clockProCold
+	clockProTest
+)
+
+// helper node class, doubly linked into the circular clock
+type clockProNode struct {
+	prev  *clockProNode
+	next  *clockProNode
+	key   string
+	value []byte
+	page  clockProPage
+	ref   bool // reference bit, set on every hit
+}
+
+type ClockPro struct {
+	size int // size is the fixed number of key-value pairs the cache stores
+
+	// coldTarget is the adaptive number of resident pages reserved for cold
+	// pages, hot pages may use the remaining size-coldTarget slots
+	coldTarget int
+
+	handHot  *clockProNode // next hot page to consider for demotion
+	handCold *clockProNode // next cold page to consider for eviction
+	handTest *clockProNode // next test page to consider for removal
+
+	countHot  int
+	countCold int
+	countTest int
+
+	mapNode map[string]*clockProNode // maps key to its node on the clock
+	stats   *Stats                   // maintains stats associated with hits/misses
+}
+
+// NewClockPro creates a CLOCK-Pro cache of the given size
+func NewClockPro(size int) *ClockPro {
+	return &ClockPro{
+		size:       size,
+		coldTarget: max(size/100, 1), // start with 1% cold pages, as in the paper
+		mapNode:    make(map[string]*clockProNode),
+		stats:      &Stats{},
+	}
+}
+
+// Get returns the value associated with the given key, if it exists.
+// A hit only sets the reference bit of the page.
+func (c *ClockPro) Get(key string) ([]byte, bool) {
+	node, ok := c.mapNode[key]
+	if !ok || node.page == clockProTest {
+		c.stats.Misses++
+		return nil, false
+	}
+
+	node.ref = true
+	c.stats.Hits++
+	return node.value, true
+}
+
+// Set puts a key-value pair into cache. Returns true if the binding was
+// added successfully, else false.
+func (c *ClockPro) Set(key string, value []byte) bool {
+	if c.size <= 0 {
+		return false
+	}
+
+	node, ok := c.mapNode[key]
+	if !ok {
+		// brand new key, starts out as a cold page
+		c.insert(&clockProNode{key: key, value: value, page: clockProCold})
+		c.countCold++
+		return true
+	}
+
+	if node.page != clockProTest {
+		node.value = value
+		node.ref = true
+		return true
+	}
+
+	// reuse during the test period: cold pages deserve more room, and the
+	// page itself had a short reuse distance so it comes back hot
+	c.coldTarget = min(c.coldTarget+1, c.size)
+	c.remove(node)
+	c.countTest--
+
+	node.value = value
+	node.page = clockProHot
+	node.ref = false
+	c.insert(node)
+	c.countHot++
+	return true
+}
+
+// insert makes room for one more resident page and links node at the head
+// of the clock, which is right behind HAND_hot
+func (c *ClockPro) insert(node *clockProNode) {
+	for c.countHot+c.countCold >= c.size {
+		c.runHandCold()
+	}
+
+	if c.handHot == nil {
+		node.prev = node
+		node.next = node
+		c.handHot = node
+		c.handCold = node
+		c.handTest = node
+	} else {
+		node.prev = c.handHot.prev
+		node.next = c.handHot
+		c.handHot.prev.next = node
+		c.handHot.prev = node
+	}
+	c.mapNode[node.key] = node
+}
+
+// remove unlinks node from the clock, moving any hand that points at it
+// to the following node
+func (c *ClockPro) remove(node *clockProNode) {
+	delete(c.mapNode, node.key)
+
+	if node.next == node {
+		c.handHot = nil
+		c.handCold = nil
+		c.handTest = nil
+	} else {
+		if c.handHot == node {
+			c.handHot = node.next
+		}
+		if c.handCold == node {
+			c.handCold = node.next
+		}
+		if c.handTest == node {
+			c.handTest = node.next
+		}
+		node.prev.next = node.next
+		node.next.prev = node.prev
+	}
+	node.prev = nil
+	node.next = nil
+}
+
+// runHandCold moves HAND_cold by one page, turning an unreferenced cold page
+// into a test page (freeing its slot) or promoting a referenced one to hot
+func (c *ClockPro) runHandCold() {
+	node := c.handCold
+	c.handCold = node.next
+
+	if node.page == clockProCold {
+		if node.ref {
+			node.page = clockProHot
+			node.ref = false
+			c.countCold--
+			c.countHot++
+		} else {
+			node.page = clockProTest
+			node.value = nil
+			c.countCold--
+			c.countTest++
+			for c.countTest > c.size {
+				c.runHandTest()
+			}
+		}
+	}
+
+	for c.countHot > c.size-c.coldTarget {
+		c.runHandHot()
+	}
+}
+
+// runHandHot moves HAND_hot by one page, demoting a hot page that was not
+// referenced since the last pass. Test pages it passes are too old to count
+// as a short reuse distance anymore, so their test period ends.
+func (c *ClockPro) runHandHot() {
+	node := c.handHot
+	c.handHot = node.next
+
+	switch node.page {
+	case clockProHot:
+		if node.ref {
+			node.ref = false
+		} else {
+			node.page = clockProCold
+			c.countHot--
+			c.countCold++
+		}
+	case clockProTest:
+		c.endTest(node)
+	}
+}
+
+// runHandTest moves HAND_test by one page, ending the test period of the
+// first test page it finds
+func (c *ClockPro) runHandTest() {
+	for i := 0; i <= c.countHot+c.countCold+c.countTest; i++ {
+		node := c.handTest
+		c.handTest = node.next
+		if node.page == clockProTest {
+			c.endTest(node)
+			return
+		}
+	}
+}
+
+// endTest drops a test page that was not reused in time, which means the
+// cold allocation can shrink
+func (c *ClockPro) endTest(node *clockProNode) {
+	c.remove(node)
+	c.countTest--
+	c.coldTarget = max(c.coldTarget-1, 1)
+}
+
+// Remove removes and returns the value associated with the given key, if it exists.
+// If key not in the cache, returns nil,false
+func (c *ClockPro) Remove(key string) ([]byte, bool) {
+	node, ok := c.mapNode[key]
+	if !ok {
+		return nil, false
+	}
+
+	c.remove(node)
+	switch node.page {
+	case clockProHot:
+		c.countHot--
+	case clockProCold:
+		c.countCold--
+	case clockProTest:
+		c.countTest--
+		return nil, false
+	}
+	return node.value, true
+}
+
+// Len returns the number of resident entries in the cache
+func (c *ClockPro) Len() int {
+	return c.countHot + c.countCold
+}
+
+// MaxSize returns the number of entries supported by the cache
+func (c *ClockPro) MaxSize() int {
+	return c.size
+}
+
+// Stats returns statistics about how many search hits and misses have occurred.
+func (c *ClockPro) Stats() *Stats {
+	return c.stats
+}
+
+// report hits/misses from Get calls to stdout
+func (c *ClockPro) ReportStats() {
+	fmt.Println("CLOCK-Pro Hits/Misses")
+	fmt.Println("Number of Hits:", c.stats.Hits)
+	fmt.Println("Number of Misses:", c.stats.Misses)
+	fmt.Println("Percentage of Hits:", c.stats.HitRate())
+}
+
+// for debugging, checks that the page counters agree with the clock
+func (c *ClockPro) invariant() bool {
+	hot, cold, test := 0, 0, 0
+	if c.handHot != nil {
+		node := c.handHot
+		for {
+			switch node.page {
+			case clockProHot:
+				hot++
+			case clockProCold:
+				cold++
+			case clockProTest:
+				test++
+			}
+			node = node.next
+			if node == c.handHot {
+				break
+			}
+		}
+	}
+
+	return hot == c.countHot && cold == c.countCold && test == c.countTest &&
+		hot+cold+test == len(c.mapNode) && hot+cold <= c.size && test <= c.size &&
+		c.coldTarget >= 1 && c.coldTarget <= c.size
+}
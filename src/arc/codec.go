@@ -0,0 +1,167 @@
+// Value Compression for ARC
+//
+// Dependencies: arc.go, arena.go
+//
+// Description:
+// Cached values such as JSON documents often compress well. With a Codec set
+// (SetCodec), ARC compresses every value of at least a threshold size when it
+// is stored, and decompresses it again when it is read, so Get and the
+// eviction callback always see the original value. A value that does not get
+// smaller is stored as it is. Smaller values are never compressed, for them
+// the work is not worth the few bytes saved. A value the codec fails to
+// decode is lost: Get treats it as a miss and its key is dropped.
+//
+// The cache size counts keys, so compression does not let ARC hold more of
+// them, but it shrinks the memory the values take. Stats.RawBytes and
+// Stats.StoredBytes tell how much, for the values ARC holds right now. In an off-heap ARC the
+// compressed values are what is stored in the slabs, so they are what counts
+// toward the memory limit (see slab.go).
+
+package arc
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"sync"
+)
+
+// Codec compresses and decompresses values. Decode must accept everything
+// that Encode returns.
+type Codec interface {
+	Encode(value []byte) ([]byte, error)
+	Decode(data []byte) ([]byte, error)
+}
+
+// flateCodec is a Codec that uses DEFLATE
+type flateCodec struct {
+	level   int
+	writers sync.Pool // *flate.Writer
+}
+
+// NewFlateCodec returns a Codec that compresses with DEFLATE at the given
+// level, from flate.BestSpeed to flate.BestCompression, or
+// flate.DefaultCompression
+func NewFlateCodec(level int) Codec {
+	return &flateCodec{level: level}
+}
+
+func (c *flateCodec) Encode(value []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, ok := c.writers.Get().(*flate.Writer)
+	if ok {
+		w.Reset(&buf)
+	} else {
+		var err error
+		if w, err = flate.NewWriter(&buf, c.level); err != nil {
+			return nil, err
+		}
+	}
+
+	// a writer that failed is not reused
+	if _, err := w.Write(value); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	c.writers.Put(w)
+	return buf.Bytes(), nil
+}
+
+func (c *flateCodec) Decode(data []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// SetCodec makes ARC compress values of at least threshold bytes with codec
+// from now on, a nil codec turns compression off. Values that were
+// compressed by the previous codec are stored uncompressed first. If that
+// makes an off-heap ARC run out of memory, or a value cannot be decoded, its
+// key is dropped.
+func (arc *ARC) SetCodec(codec Codec, threshold int) {
+	if arc.codec != nil {
+		arc.decompressAll()
+	}
+	arc.codec = codec
+	arc.codecThreshold = threshold
+}
+
+// decompressAll stores every compressed value uncompressed, so no value
+// counts as encoded anymore. The codec stays set meanwhile: storing one value
+// may evict another one off the heap (see slab.go), which is still compressed
+// and decoded for the eviction callback.
+func (arc *ARC) decompressAll() {
+	type decoded struct {
+		e     *entry
+		value []byte
+	}
+	var values []decoded
+	var broken []*entry
+	for _, list := range []*arcList{&arc.t1, &arc.t2} {
+		for e := list.sentinel.next; e != &list.sentinel; e = e.next {
+			if !e.compressed {
+				arc.countEncoded(e, -1) // went through the old codec
+				continue
+			}
+			if value, ok := arc.valueOf(e); ok {
+				values = append(values, decoded{e, value})
+			} else {
+				broken = append(broken, e)
+			}
+		}
+	}
+	for _, e := range broken {
+		arc.drop(e)
+	}
+
+	arc.decompressing = true
+	for _, v := range values {
+		if v.e.resident() && v.e.compressed && !arc.setValue(v.e, v.value) {
+			arc.drop(v.e)
+		}
+	}
+	arc.decompressing = false
+}
+
+// compressible reports whether value is large enough to go through the codec
+func (arc *ARC) compressible(value []byte) bool {
+	return arc.codec != nil && !arc.decompressing && len(value) >= arc.codecThreshold && len(value) > 0
+}
+
+// encode returns what to store for value, and whether it is compressed
+func (arc *ARC) encode(value []byte) ([]byte, bool) {
+	if !arc.compressible(value) {
+		return value, false
+	}
+	data, err := arc.codec.Encode(value)
+	if err != nil || len(data) >= len(value) {
+		return value, false
+	}
+	return data, true
+}
+
+// countEncoded adds the value of e to RawBytes and StoredBytes (sign 1), or
+// takes it out again (sign -1), if it went through the codec
+func (arc *ARC) countEncoded(e *entry, sign int64) {
+	if e.rawLen == 0 {
+		return
+	}
+	arc.stats.RawBytes += sign * int64(e.rawLen)
+	arc.stats.StoredBytes += sign * int64(len(arc.storedBytes(e)))
+	if sign < 0 {
+		e.rawLen = 0
+	}
+}
+
+// decode returns the value that was compressed into data, or false if the
+// codec fails to decode it. Values are only ever decoded by the codec that
+// encoded them, so that is a broken codec, and the caller drops the value.
+func (arc *ARC) decode(data []byte) ([]byte, bool) {
+	value, err := arc.codec.Decode(data)
+	if err != nil {
+		return nil, false
+	}
+	return value, true
+}
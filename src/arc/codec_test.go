@@ -0,0 +1,283 @@
+package arc
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// jsonValue returns a JSON document of about n bytes that compresses well
+func jsonValue(n int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("[")
+	for i := 0; buf.Len() < n; i++ {
+		fmt.Fprintf(&buf, `{"id":%d,"name":"user%d","active":true,"tags":["a","b"]},`, i, i%10)
+	}
+	buf.WriteString("{}]")
+	return buf.Bytes()
+}
+
+// halfCodec is a Codec that stores a value made of two equal halves as one of
+// them, so the size of a compressed value is exact. It fails to decode while
+// broken is set.
+type halfCodec struct {
+	broken bool
+}
+
+func (c *halfCodec) Encode(value []byte) ([]byte, error) {
+	half := len(value) / 2
+	if len(value)%2 != 0 || !bytes.Equal(value[:half], value[half:]) {
+		return value, nil // not smaller, stored as it is
+	}
+	return append([]byte(nil), value[:half]...), nil
+}
+
+func (c *halfCodec) Decode(data []byte) ([]byte, error) {
+	if c.broken {
+		return nil, errors.New("broken codec")
+	}
+	return append(append([]byte(nil), data...), data...), nil
+}
+
+// halves returns a value of n bytes, made of two equal halves that are
+// different for every i
+func halves(n, i int) []byte {
+	half := []byte(fmt.Sprintf("%0*d", n/2, i))
+	return append(half, half...)
+}
+
+// large values are stored compressed and come back as they were, small and
+// incompressible ones are stored as they are
+func TestARCCodec(t *testing.T) {
+	for _, arc := range []*ARC{NewARC(10), NewOffHeapARC(10)} {
+		arc.SetCodec(NewFlateCodec(flate.BestSpeed), 256)
+		var evicted [][]byte
+		arc.SetOnEvict(func(key string, value []byte) {
+			evicted = append(evicted, value)
+		})
+
+		random := make([]byte, 1000)
+		newTestRand(t).Read(random)
+		values := map[string][]byte{
+			"json":   jsonValue(10_000),
+			"small":  jsonValue(100),
+			"random": random,
+		}
+		for key, value := range values {
+			arc.Set(key, value)
+		}
+		for key, value := range values {
+			if got, ok := arc.Get(key); !ok || !bytes.Equal(got, value) {
+				t.Errorf("expected %s to come back as it was stored", key)
+			}
+		}
+		if !arc.index["json"].compressed || arc.index["small"].compressed || arc.index["random"].compressed {
+			t.Errorf("expected only the large compressible value to be compressed")
+		}
+
+		stats := arc.Stats()
+		if stats.RawBytes != int64(len(values["json"])+len(random)) || stats.CompressionRatio() < 5 {
+			t.Errorf("expected the JSON to compress at least 5 times, got %+v, ratio %.1f", *stats, stats.CompressionRatio())
+		}
+
+		arc.Purge()
+		for _, value := range evicted {
+			if bytes.Equal(value, values["json"]) {
+				evicted = nil
+			}
+		}
+		if evicted != nil {
+			t.Errorf("expected the eviction callback to get the original JSON")
+		}
+		if err := arc.checkInvariants(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// compressed values take less memory off the heap, and a value larger than a
+// page fits if it compresses
+func TestOffHeapARCCodec(t *testing.T) {
+	arc := NewOffHeapARC(10)
+	arc.SetCodec(NewFlateCodec(flate.DefaultCompression), 256)
+	large := jsonValue(2 * slabPageSize)
+	if !arc.Set("large", large) {
+		t.Fatalf("expected a compressed value larger than a page to be stored")
+	}
+	if got, _ := arc.Get("large"); !bytes.Equal(got, large) {
+		t.Errorf("expected the large value to come back as it was stored")
+	}
+	if stats := arc.SlabStats(); len(stats) != 1 || stats[0].Requested != arc.Stats().StoredBytes {
+		t.Errorf("expected the slabs to hold the %d compressed bytes, got %+v", arc.Stats().StoredBytes, stats)
+	}
+
+	// turning the codec off stores the values uncompressed, as far as they fit
+	arc.Set("json", jsonValue(1000))
+	arc.SetCodec(nil, 0)
+	if _, ok := arc.index["large"]; ok {
+		t.Errorf("expected the large value to be dropped once it cannot be compressed")
+	}
+	e := arc.index["json"]
+	if value, _ := arc.valueOf(e); e.compressed || !bytes.Equal(value, jsonValue(1000)) {
+		t.Errorf("expected the JSON to be stored uncompressed")
+	}
+	if err := arc.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+// turning the codec off can evict values that are still compressed, to make
+// room for the ones already decompressed. They are decoded for the eviction
+// callback by the codec that compressed them.
+func TestOffHeapARCCodecOffEvictsCompressed(t *testing.T) {
+	arc := NewOffHeapARC(10_000)
+	arc.SetSlabMemoryLimit(2 * slabPageSize)
+	arc.EnableSlabClassEviction(true)
+	arc.SetCodec(&halfCodec{}, 256)
+	evicted := make(map[string][]byte)
+	arc.SetOnEvict(func(key string, value []byte) {
+		evicted[key] = value
+	})
+
+	// one page of large values in T2, compressed into the class that the
+	// small values need once they are decompressed
+	perPage := slabPageSize / slabChunkSizes[classFor(1000)]
+	for i := 0; i < perPage; i++ {
+		key := fmt.Sprint("large", i)
+		arc.Set(key, halves(2000, i))
+		arc.Get(key)
+	}
+	// and a page of small values in T1, which are decompressed first
+	for i := 0; i < 10; i++ {
+		arc.Set(fmt.Sprint("small", i), halves(1000, i))
+	}
+
+	arc.SetCodec(nil, 0)
+	for i := 0; i < 10; i++ {
+		if value, ok := arc.Get(fmt.Sprint("small", i)); !ok || !bytes.Equal(value, halves(1000, i)) {
+			t.Errorf("expected small%d to be stored uncompressed", i)
+		}
+	}
+	if len(evicted) != 10 {
+		t.Errorf("expected 10 large values evicted for the small ones, got %d", len(evicted))
+	}
+	for key, value := range evicted {
+		var i int
+		fmt.Sscanf(key, "large%d", &i)
+		if !bytes.Equal(value, halves(2000, i)) {
+			t.Errorf("expected the evicted value of %s to be decoded", key)
+		}
+	}
+	if err := arc.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+// a value the codec cannot decode is a miss, and its key is dropped
+func TestARCCodecDecodeError(t *testing.T) {
+	arc := NewARC(10)
+	codec := &halfCodec{}
+	arc.SetCodec(codec, 0)
+	var evicted []string
+	arc.SetOnEvict(func(key string, value []byte) {
+		evicted = append(evicted, key)
+	})
+	for i, key := range []string{"a", "b", "c"} {
+		arc.Set(key, halves(100, i))
+	}
+	arc.Set("plain", []byte("odd")) // not compressed
+	codec.broken = true
+
+	if _, ok := arc.Get("a"); ok || arc.Len() != 3 {
+		t.Errorf("expected a miss and a dropped key, got %d keys", arc.Len())
+	}
+	if value, ok := arc.Get("plain"); !ok || string(value) != "odd" {
+		t.Errorf("expected the uncompressed value to be served, got %q", value)
+	}
+	if _, ok := arc.Remove("b"); ok {
+		t.Errorf("expected Remove to report no value for a broken one")
+	}
+	arc.SetCodec(nil, 0)
+	if _, ok := arc.index["c"]; ok {
+		t.Errorf("expected turning the codec off to drop the broken value")
+	}
+
+	arc.Purge()
+	if len(evicted) != 1 || evicted[0] != "plain" {
+		t.Errorf("expected only the value that can be read to be evicted, got %v", evicted)
+	}
+	expected := Stats{Hits: 1, Misses: 1} // no values left to count
+	if !arc.Stats().Equals(&expected) {
+		t.Errorf("expected stats %+v, got %+v", expected, *arc.Stats())
+	}
+	if err := arc.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+// RawBytes and StoredBytes count the values ARC holds, not every value it
+// ever compressed
+func TestARCCodecBytesAreCurrent(t *testing.T) {
+	arc := NewOffHeapARC(4)
+	arc.SetCodec(&halfCodec{}, 0)
+	check := func(when string) {
+		t.Helper()
+		var raw, stored int64
+		for _, list := range []*arcList{&arc.t1, &arc.t2} {
+			for e := list.sentinel.next; e != &list.sentinel; e = e.next {
+				value, _ := arc.peekValue(e)
+				raw += int64(len(value))
+				stored += int64(len(arc.storedBytes(e)))
+			}
+		}
+		if stats := arc.Stats(); stats.RawBytes != raw || stats.StoredBytes != stored {
+			t.Errorf("after %s expected %d raw and %d stored bytes, got %+v", when, raw, stored, *stats)
+		}
+	}
+
+	for i := 0; i < 4; i++ {
+		arc.Set(fmt.Sprint("k", i), halves(100, i))
+	}
+	check("filling the cache")
+	arc.Set("k0", halves(300, 0))
+	arc.Set("k1", []byte("odd"))
+	check("overwrites")
+	for i := 4; i < 10; i++ {
+		arc.Set(fmt.Sprint("k", i), halves(200, i))
+	}
+	check("evictions")
+	arc.Remove("k9")
+	check("a remove")
+	arc.SetCodec(nil, 0)
+	if stats := arc.Stats(); stats.RawBytes != 0 || stats.StoredBytes != 0 {
+		t.Errorf("expected no bytes to be counted without a codec, got %+v", *stats)
+	}
+}
+
+// Set and Get of 10KB JSON documents, with and without compression
+func BenchmarkARCCodec(b *testing.B) {
+	value := jsonValue(10_000)
+	keys := benchKeys("k", benchSize)
+	for _, compress := range []bool{false, true} {
+		name := "none"
+		if compress {
+			name = "flate"
+		}
+		b.Run(name, func(b *testing.B) {
+			arc := NewARC(benchSize)
+			if compress {
+				arc.SetCodec(NewFlateCodec(flate.BestSpeed), 256)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				key := keys[i%len(keys)]
+				arc.Set(key, value)
+				arc.Get(key)
+			}
+			b.ReportMetric(arc.Stats().CompressionRatio(), "ratio")
+		})
+	}
+}
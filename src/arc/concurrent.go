@@ -0,0 +1,106 @@
+// Concurrent ARC
+//
+// Dependencies: arc.go, batch.go
+//
+// Description:
+// ARC itself is not safe for concurrent use, every operation (a Get too)
+// reorders its lists. ConcurrentARC guards an ARC with a single mutex. The
+// batch operations take the lock once for the whole batch, so a request that
+// fans out into hundreds of lookups does not contend for the lock hundreds of
+// times, and no other goroutine's operations are interleaved with the batch.
+
+package arc
+
+import "sync"
+
+// ConcurrentARC is an ARC that is safe for concurrent use
+type ConcurrentARC struct {
+	mu  sync.Mutex
+	arc *ARC
+}
+
+// NewConcurrentARC creates a concurrent ARC of the given size
+func NewConcurrentARC(size int) *ConcurrentARC {
+	return &ConcurrentARC{arc: NewARC(size)}
+}
+
+// Get returns the value associated with the given key, if it exists.
+func (c *ConcurrentARC) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.arc.Get(key)
+}
+
+// Set puts a key-value pair into cache. Returns true if the binding was
+// added successfully, else false.
+func (c *ConcurrentARC) Set(key string, value []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.arc.Set(key, value)
+}
+
+// Remove removes and returns the value associated with the given key, if it exists.
+func (c *ConcurrentARC) Remove(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.arc.Remove(key)
+}
+
+// GetMany looks up every key in one critical section, see ARC.GetMany
+func (c *ConcurrentARC) GetMany(keys []string) ([][]byte, []bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.arc.GetMany(keys)
+}
+
+// SetMany stores every pair in one critical section, see ARC.SetMany
+func (c *ConcurrentARC) SetMany(pairs []KeyValue) []bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.arc.SetMany(pairs)
+}
+
+// RemoveMany removes every key in one critical section, see ARC.RemoveMany
+func (c *ConcurrentARC) RemoveMany(keys []string) ([][]byte, []bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.arc.RemoveMany(keys)
+}
+
+// Do runs fn with the lock held, so that any sequence of operations on the
+// underlying ARC is applied atomically. fn must not keep the ARC around.
+func (c *ConcurrentARC) Do(fn func(arc *ARC)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fn(c.arc)
+}
+
+// Len returns the number of entries in the cache
+func (c *ConcurrentARC) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.arc.Len()
+}
+
+// MaxSize returns the number of entries supported by the cache
+func (c *ConcurrentARC) MaxSize() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.arc.MaxSize()
+}
+
+// Stats returns a snapshot of the hits and misses so far. Unlike the other
+// caches the result is a copy, it does not change with later requests.
+func (c *ConcurrentARC) Stats() *Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := *c.arc.Stats()
+	return &stats
+}
+
+// report hits/misses from Get calls to stdout
+func (c *ConcurrentARC) ReportStats() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.arc.ReportStats()
+}
@@ -0,0 +1,74 @@
+// FIFO Queue Helpers
+//
+// Description:
+// A small doubly linked FIFO queue shared by the FIFO-based policies
+// (S3-FIFO and SIEVE). Unlike LRU, these policies never reorder nodes on a
+// hit; they only flip a per-node counter, so the queue only needs to support
+// pushing new nodes, looking at the oldest node and unlinking arbitrary nodes.
+
+package arc
+
+// fifoNode is a doubly linked node that carries an access counter. S3-FIFO
+// uses freq as a small saturating frequency, SIEVE uses it as a visited bit.
+type fifoNode struct {
+	prev  *fifoNode
+	next  *fifoNode
+	key   string
+	value []byte
+	freq  int
+	main  bool // S3-FIFO only, true if the node lives in the main queue
+}
+
+// fifoQueue is a sentinel based queue, sentinel.next = oldest node,
+// sentinel.prev = newest node (same orientation as LRU)
+type fifoQueue struct {
+	sentinel fifoNode
+	length   int
+}
+
+// newFifoQueue returns an empty queue
+func newFifoQueue() *fifoQueue {
+	q := &fifoQueue{}
+	q.sentinel.prev = &q.sentinel
+	q.sentinel.next = &q.sentinel
+	return q
+}
+
+// Len returns the number of nodes in the queue
+func (q *fifoQueue) Len() int {
+	return q.length
+}
+
+// pushBack links node in as the newest node of the queue
+func (q *fifoQueue) pushBack(node *fifoNode) {
+	node.prev = q.sentinel.prev
+	node.next = &q.sentinel
+	q.sentinel.prev.next = node
+	q.sentinel.prev = node
+	q.length++
+}
+
+// oldest returns the node at the head of the queue, or nil if it is empty
+func (q *fifoQueue) oldest() *fifoNode {
+	if q.sentinel.next == &q.sentinel {
+		return nil
+	}
+	return q.sentinel.next
+}
+
+// after returns the node that is newer than node, or nil at the end of the queue
+func (q *fifoQueue) after(node *fifoNode) *fifoNode {
+	if node.next == &q.sentinel {
+		return nil
+	}
+	return node.next
+}
+
+// unlink removes node from the queue, leaving its key and value intact
+func (q *fifoQueue) unlink(node *fifoNode) {
+	node.prev.next = node.next
+	node.next.prev = node.prev
+	node.prev = nil
+	node.next = nil
+	q.length--
+}
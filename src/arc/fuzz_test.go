@@ -0,0 +1,124 @@
+package arc
+
+import (
+	"fmt"
+	"testing"
+)
+
+// fuzzOp is one operation decoded from fuzz input
+type fuzzOp struct {
+	kind byte // 0 Set, 1 Get, 2 Remove, 3 Resize
+	key  string
+	size int
+}
+
+func (op fuzzOp) String() string {
+	switch op.kind {
+	case 0:
+		return "Set(" + op.key + ")"
+	case 1:
+		return "Get(" + op.key + ")"
+	case 2:
+		return "Remove(" + op.key + ")"
+	}
+	return fmt.Sprint("Resize(", op.size, ")")
+}
+
+// decodeFuzzOps turns fuzz input into a cache size and a sequence of
+// operations. The first byte is the initial size, then every pair of bytes is
+// an operation: the low bits of the first byte pick the kind, the second byte
+// picks one of 32 keys (or the new size, for Resize).
+func decodeFuzzOps(data []byte) (int, []fuzzOp) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	size := int(data[0] % 16)
+
+	var ops []fuzzOp
+	for i := 1; i+1 < len(data); i += 2 {
+		op := fuzzOp{kind: data[i] % 4}
+		if op.kind == 3 {
+			op.size = int(data[i+1] % 16)
+		} else {
+			op.key = fmt.Sprint("k", data[i+1]%32)
+		}
+		ops = append(ops, op)
+	}
+	return size, ops
+}
+
+// seed corpus shared by both targets: empty caches, zero sizes, resizes
+func addFuzzSeeds(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{0, 2, 1})                         // Remove on an empty, zero-size cache
+	f.Add([]byte{0, 0, 1, 1, 1})                   // Set then Get on a zero-size cache
+	f.Add([]byte{4, 0, 1, 0, 2, 1, 1, 0, 3, 0, 4}) // fill, promote, grow past size
+	f.Add([]byte{8, 0, 1, 0, 2, 0, 3, 3, 1, 0, 4, 3, 0, 0, 5})
+}
+
+// FuzzARC checks every ARC invariant after each operation. Run it with
+// `go test -fuzz=FuzzARC`; failing inputs are saved under testdata/fuzz and
+// replayed by every plain `go test` run from then on.
+func FuzzARC(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		size, ops := decodeFuzzOps(data)
+		arc := NewARC(size)
+
+		for i, op := range ops {
+			switch op.kind {
+			case 0:
+				arc.Set(op.key, []byte(op.key))
+			case 1:
+				if value, ok := arc.Get(op.key); ok && string(value) != op.key {
+					t.Fatalf("op %d %v returned %q", i, op, value)
+				}
+			case 2:
+				if value, ok := arc.Remove(op.key); ok && string(value) != op.key {
+					t.Fatalf("op %d %v returned %q", i, op, value)
+				}
+			case 3:
+				arc.Resize(op.size)
+			}
+
+			if err := arc.checkInvariants(); err != nil {
+				t.Fatalf("size %d, after op %d %v: %v\nops: %v", size, i, op, err, ops[:i+1])
+			}
+		}
+	})
+}
+
+// FuzzLRU checks that the LRU never holds more than MaxSize entries and that
+// its index agrees with its linked list. Run it with `go test -fuzz=FuzzLRU`.
+func FuzzLRU(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		size, ops := decodeFuzzOps(data)
+		lru := NewLru(size)
+
+		for i, op := range ops {
+			switch op.kind {
+			case 0:
+				lru.Set(op.key, []byte(op.key))
+			case 1:
+				if value, ok := lru.Get(op.key); ok && string(value) != op.key {
+					t.Fatalf("op %d %v returned %q", i, op, value)
+				}
+			case 2:
+				if value, ok := lru.Remove(op.key); ok && string(value) != op.key {
+					t.Fatalf("op %d %v returned %q", i, op, value)
+				}
+			case 3:
+				lru.Resize(op.size)
+			}
+
+			if keys := lru.orderedKeys(); len(keys) != lru.Len() {
+				t.Fatalf("after op %d %v: %d nodes linked but %d indexed", i, op, len(keys), lru.Len())
+			}
+			if lru.Len() > lru.MaxSize() {
+				t.Fatalf("size %d, after op %d %v: %d entries exceed MaxSize %d\nops: %v",
+					size, i, op, lru.Len(), lru.MaxSize(), ops[:i+1])
+			}
+		}
+	})
+}
@@ -0,0 +1,106 @@
+// Key Invalidation for ARC
+//
+// Dependencies: arc.go, arclist.go
+//
+// Description:
+// Besides removing single keys, ARC can invalidate groups of keys at once:
+// every key with a given prefix (e.g. all keys of one tenant), every key a
+// predicate matches, or every key that was stored with a given tag.
+// Invalidated keys are dropped from the ghost lists as well, and so are
+// their tombstones. Their ghosts describe data that no longer exists, so
+// when such a key is filled again it must not count as a ghost hit and pull
+// p towards T1 or T2.
+
+package arc
+
+import "strings"
+
+// RemovePrefix invalidates every key that starts with prefix, and returns
+// the number of keys that were removed from the cache (T1 and T2)
+func (arc *ARC) RemovePrefix(prefix string) int {
+	return arc.RemoveMatching(func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	})
+}
+
+// RemoveMatching invalidates every key for which match returns true, and
+// returns the number of keys that were removed from the cache (T1 and T2).
+// match is called once for every key ARC tracks, ghosts included.
+func (arc *ARC) RemoveMatching(match func(key string) bool) int {
+	removed := 0
+	for _, id := range []listID{listT1, listT2, listB1, listB2, listNeg} {
+		list := arc.list(id)
+		for e := list.sentinel.next; e != &list.sentinel; {
+			next := e.next // drop unlinks e
+			if match(e.key) {
+				if e.resident() {
+					removed++
+				}
+				arc.drop(e)
+			}
+			e = next
+		}
+	}
+	return removed
+}
+
+// SetWithTags puts a key-value pair into cache like Set, and tags the key so
+// it can be invalidated together with other keys by InvalidateTag. The tags
+// replace the ones the key had before. A key keeps its tags while ARC tracks
+// it, in the ghost lists too, and a plain Set leaves them alone.
+func (arc *ARC) SetWithTags(key string, value []byte, tags ...string) bool {
+	if !arc.Set(key, value) {
+		return false
+	}
+
+	e := arc.index[key]
+	arc.untag(e)
+	for _, tag := range tags {
+		arc.tag(e, tag)
+	}
+	return true
+}
+
+// InvalidateTag invalidates every key tagged with tag, and returns the number
+// of keys that were removed from the cache (T1 and T2)
+func (arc *ARC) InvalidateTag(tag string) int {
+	removed := 0
+	for key := range arc.tags[tag] {
+		e := arc.index[key]
+		if e.resident() {
+			removed++
+		}
+		arc.drop(e) // also deletes key from arc.tags[tag]
+	}
+	return removed
+}
+
+// tag records that e is tagged with tag
+func (arc *ARC) tag(e *entry, tag string) {
+	if arc.tags == nil {
+		arc.tags = make(map[string]map[string]struct{})
+	}
+	keys, ok := arc.tags[tag]
+	if !ok {
+		keys = make(map[string]struct{})
+		arc.tags[tag] = keys
+	}
+	if _, ok := keys[e.key]; ok {
+		return // tag given twice
+	}
+
+	keys[e.key] = struct{}{}
+	e.tags = append(e.tags, tag)
+}
+
+// untag removes all tags of e
+func (arc *ARC) untag(e *entry) {
+	for _, tag := range e.tags {
+		keys := arc.tags[tag]
+		delete(keys, e.key)
+		if len(keys) == 0 {
+			delete(arc.tags, tag)
+		}
+	}
+	e.tags = e.tags[:0]
+}
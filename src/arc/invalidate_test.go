@@ -0,0 +1,188 @@
+package arc
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// newTenantARC fills an ARC with keys of two tenants, some of which end up
+// in T2 and some in the ghost lists
+func newTenantARC() *ARC {
+	arc := NewARC(10)
+	for _, tenant := range []string{"tenant1:", "tenant2:"} {
+		warmT2(arc, seqKeys(tenant+"hot", 0, 3))
+	}
+	for i := 0; i < 8; i++ {
+		for _, tenant := range []string{"tenant1:", "tenant2:"} {
+			arc.Set(fmt.Sprint(tenant, "page", i), []byte{})
+		}
+	}
+	return arc
+}
+
+// countTracked returns how many resident and ghost keys start with prefix
+func countTracked(arc *ARC, prefix string) (resident, ghosts int) {
+	for key, e := range arc.index {
+		if strings.HasPrefix(key, prefix) {
+			if e.resident() {
+				resident++
+			} else {
+				ghosts++
+			}
+		}
+	}
+	return resident, ghosts
+}
+
+// RemovePrefix drops the keys of one tenant from all four lists, and leaves
+// the other tenant alone
+func TestARCRemovePrefix(t *testing.T) {
+	arc := newTenantARC()
+	resident, ghosts := countTracked(arc, "tenant1:")
+	otherResident, otherGhosts := countTracked(arc, "tenant2:")
+	if resident == 0 || ghosts == 0 {
+		t.Fatalf("expected tenant1 to have resident and ghost keys, got %d and %d", resident, ghosts)
+	}
+
+	if removed := arc.RemovePrefix("tenant1:"); removed != resident {
+		t.Errorf("expected RemovePrefix to remove %d keys, got %d", resident, removed)
+	}
+	if resident, ghosts := countTracked(arc, "tenant1:"); resident != 0 || ghosts != 0 {
+		t.Errorf("expected tenant1 to be gone, %d resident and %d ghost keys left", resident, ghosts)
+	}
+	if resident, ghosts := countTracked(arc, "tenant2:"); resident != otherResident || ghosts != otherGhosts {
+		t.Errorf("expected tenant2 to keep %d resident and %d ghost keys, got %d and %d",
+			otherResident, otherGhosts, resident, ghosts)
+	}
+	if err := arc.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+
+	// filling tenant1 again is a plain miss, not a ghost hit that moves p
+	p := arc.p
+	for i := 0; i < 8; i++ {
+		key := fmt.Sprint("tenant1:page", i)
+		arc.Set(key, []byte{})
+		if !inList(arc, listT1, key) {
+			t.Errorf("expected refilled key %s to go to T1", key)
+		}
+	}
+	if arc.p != p {
+		t.Errorf("expected the refill to leave p at %d, got %d", p, arc.p)
+	}
+}
+
+func TestARCRemoveMatching(t *testing.T) {
+	arc := newTenantARC()
+	tracked := len(arc.index)
+	hot := 0
+	for key, e := range arc.index {
+		if strings.Contains(key, "hot") && e.resident() {
+			hot++
+		}
+	}
+
+	seen := 0
+	removed := arc.RemoveMatching(func(key string) bool {
+		seen++
+		return strings.Contains(key, "hot")
+	})
+
+	if seen != tracked {
+		t.Errorf("expected match to be called for all %d keys, got %d calls", tracked, seen)
+	}
+	if removed != hot {
+		t.Errorf("expected the %d resident hot keys to be removed, got %d", hot, removed)
+	}
+	for key := range arc.index {
+		if strings.Contains(key, "hot") {
+			t.Errorf("expected %s to be removed", key)
+		}
+	}
+	if arc.RemoveMatching(func(string) bool { return false }) != 0 {
+		t.Errorf("expected a predicate that matches nothing to remove nothing")
+	}
+	if err := arc.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+// InvalidateTag drops every key with the tag, whatever other tags it has
+func TestARCInvalidateTag(t *testing.T) {
+	arc := NewARC(10)
+	arc.SetWithTags("user:1", []byte("a"), "users", "tenant1")
+	arc.SetWithTags("user:2", []byte("b"), "users", "tenant2")
+	arc.SetWithTags("cart:1", []byte("c"), "tenant1", "tenant1")
+	arc.Set("home", []byte("d"))
+
+	if removed := arc.InvalidateTag("tenant1"); removed != 2 {
+		t.Errorf("expected 2 keys to be invalidated, got %d", removed)
+	}
+	for key, expected := range map[string]bool{"user:1": false, "cart:1": false, "user:2": true, "home": true} {
+		if _, ok := arc.Get(key); ok != expected {
+			t.Errorf("Get(%s) = %v after invalidating tenant1, expected %v", key, ok, expected)
+		}
+	}
+	if removed := arc.InvalidateTag("tenant1"); removed != 0 {
+		t.Errorf("expected a second invalidation to remove nothing, got %d", removed)
+	}
+
+	// new tags replace the old ones, and a plain Set keeps them
+	arc.SetWithTags("user:2", []byte("b"), "tenant3")
+	arc.Set("user:2", []byte("e"))
+	if removed := arc.InvalidateTag("users"); removed != 0 {
+		t.Errorf("expected user:2 to have lost the users tag, %d keys invalidated", removed)
+	}
+	if removed := arc.InvalidateTag("tenant3"); removed != 1 {
+		t.Errorf("expected user:2 to be invalidated by its new tag, got %d", removed)
+	}
+	if err := arc.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+// tagged ghosts are invalidated too, and keys that ARC forgets take their
+// tags with them
+func TestARCTagsFollowEntries(t *testing.T) {
+	arc := NewARC(4)
+	for i := 0; i < 8; i++ {
+		key := fmt.Sprint("k", i)
+		arc.SetWithTags(key, []byte{}, "all", "tag"+key)
+		arc.Get(key)
+	}
+	if arc.b2.Len() == 0 {
+		t.Fatalf("expected tagged ghosts in B2")
+	}
+	if err := arc.checkInvariants(); err != nil {
+		t.Fatal(err)
+	}
+
+	// shrinking drops the oldest ghosts, and their tags
+	arc.Resize(2)
+	if len(arc.index) >= 8 {
+		t.Errorf("expected Resize to drop some of the 8 keys, %d left", len(arc.index))
+	}
+	for i := 0; i < 8; i++ {
+		key := fmt.Sprint("k", i)
+		if _, tracked := arc.index[key]; tracked != (arc.tags["tag"+key] != nil) {
+			t.Errorf("expected the tag of %s to be kept exactly while the key is tracked", key)
+		}
+	}
+	if err := arc.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+
+	arc.Set("untagged", []byte{})
+	resident := arc.Len() - 1
+	if removed := arc.InvalidateTag("all"); removed != resident {
+		t.Errorf("expected %d resident keys to be invalidated, got %d", resident, removed)
+	}
+	if arc.b1.Len() != 0 || arc.b2.Len() != 0 || arc.Len() != 1 || len(arc.tags) != 0 {
+		t.Errorf("expected only the untagged key to be left, got |T1|+|T2| = %d, |B1|+|B2| = %d and tags %v",
+			arc.Len(), arc.b1.Len()+arc.b2.Len(), arc.tags)
+	}
+	if err := arc.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,109 @@
+// Second-Level Cache for ARC
+//
+// Dependencies: arc.go, arclist.go
+//
+// Description:
+// Like the L2ARC of ZFS, ARC can spill the values it evicts to a larger and
+// slower second level, typically diskstore.Store on a local disk. Every value
+// that T1 or T2 evicts into B1 or B2 is written to the second level, so the
+// second level holds the values of the ghosts. A Get that finds its key in a
+// ghost list reads the value back from there: it counts as a disk hit, and
+// the key comes back into T2 exactly as if it had been Set, adapting p.
+//
+// The second level never holds anything but ghosts. A key that leaves the
+// ghost lists, because it was trimmed, invalidated, set again or turned into
+// a tombstone, is deleted from the second level as well, so a stale value is
+// never read back. Errors of the second level are not fatal, a value that
+// cannot be written or read is simply lost like any other evicted value, but
+// they are counted in Stats.DiskErrors so a failing disk does not go unnoticed.
+//
+// A second level outlives the ARC in front of it, e.g. a diskstore.Store is
+// recovered from its log after a restart. The keys it holds then become B1
+// ghosts again when it is set, so their values can be read back instead of
+// sitting on disk until they are evicted.
+
+package arc
+
+// SecondLevel is a store for the values of ghost keys, see diskstore.Store
+type SecondLevel interface {
+	Put(key string, value []byte) error
+	Get(key string) ([]byte, bool, error)
+	Delete(key string) error
+	Keys() []string // oldest first
+}
+
+// SetSecondLevel makes ARC spill evicted values to l2, and serve ghost hits
+// from it. The keys l2 holds already become B1 ghosts, newest first, as long
+// as T1 and B1 have room for them. Keys that do not fit, or that ARC caches
+// or has a tombstone for, are deleted from l2. A nil l2 turns the second
+// level off, values that were spilled to the old one are left there.
+func (arc *ARC) SetSecondLevel(l2 SecondLevel) {
+	arc.l2 = l2
+	if l2 == nil {
+		return
+	}
+
+	keys := l2.Keys()
+	for i := len(keys) - 1; i >= 0; i-- {
+		key := keys[i]
+		e, ok := arc.index[key]
+		if ok && (e.list == listB1 || e.list == listB2) {
+			continue // the value spilled by this ghost
+		}
+		lenL1 := arc.t1.Len() + arc.b1.Len()
+		lenAll := lenL1 + arc.t2.Len() + arc.b2.Len()
+		if ok || lenL1 >= arc.size || lenAll >= 2*arc.size {
+			if l2.Delete(key) != nil {
+				arc.stats.DiskErrors++
+			}
+			continue
+		}
+
+		e = arc.newEntry(key, nil)
+		e.list = listB1
+		arc.b1.pushLRU(e) // older than the keys before it
+	}
+}
+
+// spill writes the value of e, which is being evicted to a ghost list, to
+// the second level
+func (arc *ARC) spill(e *entry) {
+	if arc.l2 == nil {
+		return
+	}
+	value, ok := arc.peekValue(e)
+	if ok && arc.l2.Put(e.key, value) != nil {
+		arc.stats.DiskErrors++
+	}
+}
+
+// unspill deletes the value of e from the second level if e is a ghost, since
+// e is leaving the ghost lists
+func (arc *ARC) unspill(e *entry) {
+	if arc.l2 != nil && (e.list == listB1 || e.list == listB2) && arc.l2.Delete(e.key) != nil {
+		arc.stats.DiskErrors++
+	}
+}
+
+// getSpilled reads the value of the ghost e from the second level. If it is
+// there, the key is set again with it and ok is true. If the Set fails, the
+// key is dropped and ok is false, since the value is not cached after all.
+func (arc *ARC) getSpilled(e *entry) (value []byte, ok bool) {
+	if arc.l2 == nil {
+		return nil, false
+	}
+	value, ok, err := arc.l2.Get(e.key)
+	if err != nil {
+		arc.stats.DiskErrors++
+	}
+	if err != nil || !ok {
+		return nil, false
+	}
+
+	// a ghost hit, also deletes the spilled value
+	if !arc.Set(e.key, value) {
+		return nil, false
+	}
+	arc.stats.DiskHits++
+	return value, true
+}
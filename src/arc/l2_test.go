@@ -0,0 +1,234 @@
+package arc
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"cos316.princeton.edu/final_proj/diskstore"
+)
+
+// newTwoTierARC returns an ARC that spills to a disk store in a temporary
+// directory. Half of the cache is in T2, and B1 holds ghosts of the keys
+// that went through T1.
+func newTwoTierARC(t *testing.T, size int) (*ARC, *diskstore.Store) {
+	t.Helper()
+	store, err := diskstore.Open(filepath.Join(t.TempDir(), "l2.log"), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	arc := NewARC(size)
+	arc.SetSecondLevel(store)
+	warmT2(arc, seqKeys("hot", 0, size/2))
+	for _, key := range seqKeys("k", 0, 2*size) {
+		arc.Set(key, []byte("value of "+key))
+	}
+	if arc.b1.Len() == 0 {
+		t.Fatalf("expected ghosts in B1")
+	}
+	*arc.stats = Stats{}
+	return arc, store
+}
+
+// checkSpilled fails the test unless the disk store holds exactly the ghosts
+func checkSpilled(t *testing.T, arc *ARC, store *diskstore.Store) {
+	t.Helper()
+	for _, key := range append(arc.b1.keys(), arc.b2.keys()...) {
+		if _, ok, err := store.Get(key); !ok || err != nil {
+			t.Errorf("expected the value of ghost %s on disk, got %v, %v", key, ok, err)
+		}
+	}
+	if ghosts := arc.b1.Len() + arc.b2.Len(); store.Len() != ghosts {
+		t.Errorf("expected %d values on disk, one per ghost, got %d", ghosts, store.Len())
+	}
+	if err := arc.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+// a ghost hit is served from disk and brings the key back into T2, adapting p
+func TestARCSecondLevelGhostHit(t *testing.T) {
+	arc, store := newTwoTierARC(t, 8)
+	checkSpilled(t, arc, store)
+	ghost := arc.b1.keys()[0]
+	p := arc.p
+
+	value, ok := arc.Get(ghost)
+	if !ok || string(value) != "value of "+ghost {
+		t.Fatalf("expected the spilled value of %s, got %q, %v", ghost, value, ok)
+	}
+	if !inList(arc, listT2, ghost) || arc.p <= p {
+		t.Errorf("expected %s to move to T2 and p to grow from %d, got p = %d", ghost, p, arc.p)
+	}
+	expected := Stats{DiskHits: 1} // neither a memory hit nor a miss
+	if !arc.Stats().Equals(&expected) {
+		t.Errorf("expected stats %+v, got %+v", expected, *arc.Stats())
+	}
+	checkSpilled(t, arc, store)
+
+	// the key is in memory again, the next Get is a memory hit
+	if _, ok := arc.Get(ghost); !ok || arc.Stats().Hits != 1 || arc.Stats().DiskHits != 1 {
+		t.Errorf("expected a memory hit, got %+v", *arc.Stats())
+	}
+}
+
+// a key that leaves the ghost lists never reads an old value back from disk
+func TestARCSecondLevelNoStaleValues(t *testing.T) {
+	arc, store := newTwoTierARC(t, 8)
+	ghosts := arc.b1.keys()
+
+	arc.Set(ghosts[0], []byte("new"))
+	arc.Remove(ghosts[0])
+	arc.Remove(ghosts[1]) // a ghost, only its value on disk goes
+	arc.RemovePrefix(ghosts[2])
+	for _, key := range ghosts[:3] {
+		if value, ok := arc.Get(key); ok {
+			t.Errorf("expected %s to miss, got %q", key, value)
+		}
+	}
+	if !inList(arc, listB1, ghosts[1]) {
+		t.Errorf("expected Remove to keep the ghost of %s", ghosts[1])
+	}
+	if arc.Stats().DiskHits != 0 {
+		t.Errorf("expected no disk hits, got %d", arc.Stats().DiskHits)
+	}
+
+	// trimming the ghost lists deletes from disk too, once the ghost of
+	// ghosts[1] is gone every ghost has its value on disk again
+	replayKeys(seqKeys("other", 0, 20), arc)
+	if _, ok := arc.index[ghosts[1]]; ok {
+		t.Fatalf("expected the ghost of %s to be trimmed", ghosts[1])
+	}
+	checkSpilled(t, arc, store)
+
+	arc.Purge()
+	if store.Len() != 0 {
+		t.Errorf("expected Purge to delete the values on disk, %d left", store.Len())
+	}
+	if err := arc.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+// a value on disk that was damaged is a plain miss, and every failure of the
+// second level is counted
+func TestARCSecondLevelCorruptValue(t *testing.T) {
+	arc, store := newTwoTierARC(t, 8)
+	ghost := arc.b1.keys()[0]
+	arc.SetSecondLevel(failingLevel{store})
+
+	if _, ok := arc.Get(ghost); ok {
+		t.Errorf("expected a miss when the second level fails")
+	}
+	expected := Stats{Misses: 1, DiskErrors: 1}
+	if !arc.Stats().Equals(&expected) {
+		t.Errorf("expected stats %+v, got %+v", expected, *arc.Stats())
+	}
+	if !inList(arc, listB1, ghost) {
+		t.Errorf("expected %s to stay a ghost", ghost)
+	}
+
+	// setting the ghost deletes its value and spills the value it evicts
+	arc.Set(ghost, []byte("v"))
+	if arc.Stats().DiskErrors != 3 {
+		t.Errorf("expected the failed delete and write to be counted, got %+v", *arc.Stats())
+	}
+}
+
+// after a restart the values recovered by the second level are ghosts again,
+// in B1 since their frequency is lost, and are served from disk. Only as
+// many as fit into B1 are kept.
+func TestARCSecondLevelRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "l2.log")
+	store, err := diskstore.Open(path, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	arc := NewARC(8)
+	arc.SetSecondLevel(store)
+	warmT2(arc, seqKeys("hot", 0, 4))
+	for _, key := range seqKeys("k", 0, 16) {
+		arc.Set(key, []byte("value of "+key))
+	}
+	ghosts := store.Keys() // B1 and B2, oldest first like arcList.keys
+	store.Close()
+
+	reopen := func(size int) (*ARC, *diskstore.Store) {
+		store, err := diskstore.Open(path, 1<<20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { store.Close() })
+		arc := NewARC(size)
+		arc.SetSecondLevel(store)
+		checkSpilled(t, arc, store)
+		return arc, store
+	}
+
+	arc, store = reopen(8)
+	if !reflect.DeepEqual(arc.b1.keys(), ghosts) {
+		t.Fatalf("expected the ghosts %v back in B1, got %v", ghosts, arc.b1.keys())
+	}
+	newest := ghosts[len(ghosts)-1]
+	value, ok := arc.Get(newest)
+	if !ok || string(value) != "value of "+newest {
+		t.Errorf("expected the recovered value of %s, got %q, %v", newest, value, ok)
+	}
+	expected := Stats{DiskHits: 1}
+	if !arc.Stats().Equals(&expected) || arc.Stats().HitRate() != 100 {
+		t.Errorf("expected stats %+v and a hit rate of 100%%, got %+v", expected, *arc.Stats())
+	}
+	ghosts = store.Keys()
+	store.Close()
+
+	arc, _ = reopen(2)
+	if newest := ghosts[len(ghosts)-2:]; !reflect.DeepEqual(arc.b1.keys(), newest) {
+		t.Errorf("expected the newest ghosts %v in B1, got %v", newest, arc.b1.keys())
+	}
+}
+
+// a ghost whose value comes back from disk but cannot be cached is a miss,
+// and is dropped along with its value on disk
+func TestARCSecondLevelFailedFill(t *testing.T) {
+	store, err := diskstore.Open(filepath.Join(t.TempDir(), "l2.log"), 4<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.Put("big", make([]byte, 2<<20)) // more than an off-heap value can hold
+
+	arc := NewOffHeapARC(4)
+	arc.SetSecondLevel(store)
+	if !inList(arc, listB1, "big") {
+		t.Fatalf("expected big to be a ghost")
+	}
+	if _, ok := arc.Get("big"); ok {
+		t.Errorf("expected a miss when the value cannot be cached")
+	}
+	expected := Stats{Misses: 1}
+	if !arc.Stats().Equals(&expected) {
+		t.Errorf("expected stats %+v, got %+v", expected, *arc.Stats())
+	}
+	if _, ok := arc.index["big"]; ok || store.Len() != 0 {
+		t.Errorf("expected big to be dropped, %d values left on disk", store.Len())
+	}
+}
+
+// failingLevel is a second level that fails as if the disk was damaged
+type failingLevel struct {
+	*diskstore.Store
+}
+
+func (failingLevel) Put(key string, value []byte) error {
+	return diskstore.ErrClosed
+}
+
+func (failingLevel) Get(key string) ([]byte, bool, error) {
+	return nil, false, diskstore.ErrCorrupt
+}
+
+func (failingLevel) Delete(key string) error {
+	return diskstore.ErrClosed
+}
@@ -0,0 +1,232 @@
+// Loader-Backed ARC
+//
+// Dependencies: arc.go, arclist.go, version.go
+//
+// Description:
+// LoadingARC is a concurrent ARC in front of a backend. A miss loads the
+// value through the registered Loader, and every value has two ages:
+//   - after the soft TTL the value is stale. Get still returns it right away,
+//     and starts one asynchronous refresh through the loader.
+//   - after the hard TTL the value is too old to be served, and Get is a miss
+//     that waits for the loader.
+//
+// With refresh-ahead enabled, a hit on a hot key (one in T2) that is about to
+// become stale starts the refresh early, so hot keys are normally refreshed
+// before anyone sees them stale.
+//
+// A refresh is not an access: its result replaces the value in place and
+// leaves the key where it is in T1 or T2. If the key was written, removed or
+// evicted while the loader ran, the result is dropped.
+
+package arc
+
+import (
+	"sync"
+	"time"
+)
+
+// Loader loads the value of key from the backend
+type Loader func(key string) ([]byte, error)
+
+// LoadingARC is an ARC that loads missing and stale values through a Loader.
+// It is safe for concurrent use.
+type LoadingARC struct {
+	mu  sync.Mutex
+	arc *ARC
+
+	load    Loader
+	softTTL time.Duration // age after which a value is refreshed in the background
+	hardTTL time.Duration // age after which a value is not served anymore
+
+	// refreshAhead is how long before the soft TTL a hit on a T2 key starts
+	// a refresh, 0 if refresh-ahead is disabled
+	refreshAhead time.Duration
+
+	refreshing map[string]bool      // keys with a refresh in flight
+	inFlight   sync.WaitGroup       // refreshes in flight
+	loads      map[string]*loadCall // loads of missing keys in flight
+}
+
+// loadCall is a load of a missing key, shared by every Get that misses on the
+// key while it runs
+type loadCall struct {
+	done  chan struct{}
+	value []byte
+	err   error
+}
+
+// NewLoadingARC creates a loading ARC of the given size. Values are refreshed
+// in the background once they are older than softTTL, and loaded again
+// before they are served once they are older than hardTTL.
+func NewLoadingARC(size int, load Loader, softTTL, hardTTL time.Duration) *LoadingARC {
+	return &LoadingARC{
+		arc:        NewARC(size),
+		load:       load,
+		softTTL:    softTTL,
+		hardTTL:    hardTTL,
+		refreshing: make(map[string]bool),
+		loads:      make(map[string]*loadCall),
+	}
+}
+
+// SetRefreshAhead makes a hit on a key in T2 start a refresh once the value
+// is within window of its soft TTL. A window <= 0 disables refresh-ahead.
+func (c *LoadingARC) SetRefreshAhead(window time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refreshAhead = window
+}
+
+// Get returns the value of key, from the cache if it is younger than the hard
+// TTL and from the loader otherwise. A stale value is returned as is while a
+// refresh runs in the background. Errors of the loader are returned on a
+// miss, a value that fails to load is not cached. Concurrent misses on the
+// same key share a single load.
+func (c *LoadingARC) Get(key string) ([]byte, error) {
+	c.mu.Lock()
+	if value, ok := c.cached(key); ok {
+		c.mu.Unlock()
+		return value, nil
+	}
+	if call, ok := c.loads[key]; ok {
+		c.mu.Unlock()
+		<-call.done
+		return call.value, call.err
+	}
+	call := &loadCall{done: make(chan struct{})}
+	c.loads[key] = call
+	c.mu.Unlock()
+
+	call.value, call.err = c.load(key)
+
+	c.mu.Lock()
+	delete(c.loads, key)
+	if call.err == nil {
+		c.store(key, call.value)
+	}
+	c.mu.Unlock()
+	close(call.done)
+	return call.value, call.err
+}
+
+// cached returns the value of key if it is resident and younger than the hard
+// TTL, and starts a refresh if it is stale. Otherwise it counts a miss. Must
+// be called with c.mu held.
+func (c *LoadingARC) cached(key string) ([]byte, bool) {
+	e, ok := c.arc.index[key]
+	if !ok || !e.resident() {
+		// not arc.Get, which would serve a ghost's value from the second level
+		// without telling how old it is
+		c.arc.stats.Misses++
+		return nil, false
+	}
+	age := time.Duration(c.arc.now().UnixNano() - e.loaded)
+	if age >= c.hardTTL {
+		c.arc.stats.Misses++ // too old to be served
+		return nil, false
+	}
+
+	hot := e.list == listT2 // before the Get promotes a key from T1
+	value, ok := c.arc.Get(key)
+	if !ok {
+		return nil, false // the value could not be decoded, Get dropped the key
+	}
+	if age >= c.softTTL || (hot && c.refreshAhead > 0 && age >= c.softTTL-c.refreshAhead) {
+		if e, ok := c.arc.index[key]; ok && e.resident() {
+			c.refresh(e)
+		}
+	}
+	return value, true
+}
+
+// refresh starts a background load of e's key, unless one is running already.
+// Must be called with c.mu held.
+func (c *LoadingARC) refresh(e *entry) {
+	key, version := e.key, e.version
+	if c.refreshing[key] {
+		return
+	}
+	c.refreshing[key] = true
+	c.inFlight.Add(1)
+
+	go func() {
+		defer c.inFlight.Done()
+		value, err := c.load(key)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.refreshing, key)
+
+		// only replace the value the refresh was started for, in place
+		e, ok := c.arc.index[key]
+		if err != nil || !ok || !e.resident() || e.version != version {
+			return
+		}
+		if !c.arc.setValue(e, value) {
+			c.arc.drop(e) // no memory for the new value, the old one is gone
+			return
+		}
+		e.version = c.arc.nextVersion()
+		e.loaded = c.arc.now().UnixNano()
+	}()
+}
+
+// store puts a freshly loaded value into the cache. Must be called with c.mu held.
+func (c *LoadingARC) store(key string, value []byte) bool {
+	if !c.arc.Set(key, value) {
+		return false
+	}
+	c.arc.index[key].loaded = c.arc.now().UnixNano()
+	return true
+}
+
+// Set puts a key-value pair into cache, as if it was just loaded. Returns true
+// if the binding was added successfully, else false.
+func (c *LoadingARC) Set(key string, value []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.store(key, value)
+}
+
+// Remove removes and returns the value associated with the given key, if it exists.
+// A refresh of the key that is in flight is dropped.
+func (c *LoadingARC) Remove(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.arc.Remove(key)
+}
+
+// Wait blocks until all refreshes in flight are done
+func (c *LoadingARC) Wait() {
+	c.inFlight.Wait()
+}
+
+// Len returns the number of entries in the cache
+func (c *LoadingARC) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.arc.Len()
+}
+
+// MaxSize returns the number of entries supported by the cache
+func (c *LoadingARC) MaxSize() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.arc.MaxSize()
+}
+
+// Stats returns a snapshot of the hits and misses so far. Stale values that
+// are served count as hits, values older than the hard TTL as misses.
+func (c *LoadingARC) Stats() *Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := *c.arc.Stats()
+	return &stats
+}
+
+// report hits/misses from Get calls to stdout
+func (c *LoadingARC) ReportStats() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.arc.ReportStats()
+}
@@ -0,0 +1,288 @@
+package arc
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"cos316.princeton.edu/final_proj/diskstore"
+)
+
+// testBackend is a Loader that counts its loads and returns a new value every
+// time. While gated, loads block until the gate is opened.
+type testBackend struct {
+	mu    sync.Mutex
+	loads map[string]int
+	fail  map[string]bool
+	gate  chan struct{}
+}
+
+func newTestBackend() *testBackend {
+	return &testBackend{loads: make(map[string]int), fail: make(map[string]bool)}
+}
+
+func (b *testBackend) Load(key string) ([]byte, error) {
+	b.mu.Lock()
+	gate := b.gate
+	b.loads[key]++
+	n, fail := b.loads[key], b.fail[key]
+	b.mu.Unlock()
+
+	if gate != nil {
+		<-gate
+	}
+	if fail {
+		return nil, errors.New("backend unavailable")
+	}
+	return []byte(fmt.Sprint(key, "@", n)), nil
+}
+
+func (b *testBackend) count(key string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.loads[key]
+}
+
+// newTestLoadingARC returns a loading ARC with a soft TTL of a minute, a hard
+// TTL of an hour and a fake clock
+func newTestLoadingARC(size int) (*LoadingARC, *testBackend, *fakeClock) {
+	backend := newTestBackend()
+	clock := &fakeClock{now: time.Unix(316, 0)}
+	cache := NewLoadingARC(size, backend.Load, time.Minute, time.Hour)
+	cache.arc.now = clock.Now
+	return cache, backend, clock
+}
+
+// checkGet fails the test unless Get returns the expected value
+func checkGet(t *testing.T, cache *LoadingARC, key, expected string) {
+	t.Helper()
+	if value, err := cache.Get(key); err != nil || string(value) != expected {
+		t.Errorf("Get(%s) = %q, %v, expected %q", key, value, err, expected)
+	}
+}
+
+// a miss goes to the loader, a fresh hit does not
+func TestLoadingARCMiss(t *testing.T) {
+	cache, backend, _ := newTestLoadingARC(4)
+
+	checkGet(t, cache, "a", "a@1")
+	checkGet(t, cache, "a", "a@1")
+	if backend.count("a") != 1 {
+		t.Errorf("expected one load of a, got %d", backend.count("a"))
+	}
+
+	backend.fail["b"] = true
+	if _, err := cache.Get("b"); err == nil {
+		t.Errorf("expected the loader error to be returned")
+	}
+	if _, err := cache.Get("b"); err == nil || backend.count("b") != 2 {
+		t.Errorf("expected a failed load not to be cached, got %d loads", backend.count("b"))
+	}
+	expected := Stats{Hits: 1, Misses: 3}
+	if stats := cache.Stats(); !stats.Equals(&expected) {
+		t.Errorf("expected stats %+v, got %+v", expected, *stats)
+	}
+}
+
+// a ghost whose value is on the second level is a plain miss, it is loaded
+// again and comes back into T2
+func TestLoadingARCSecondLevelGhost(t *testing.T) {
+	cache, backend, _ := newTestLoadingARC(4)
+	store, err := diskstore.Open(filepath.Join(t.TempDir(), "l2.log"), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	cache.arc.SetSecondLevel(store)
+
+	for _, key := range []string{"x", "x", "a", "b", "c", "d"} {
+		checkGet(t, cache, key, key+"@1")
+	}
+	if !inList(cache.arc, listB1, "a") {
+		t.Fatalf("expected a to be a ghost")
+	}
+	checkGet(t, cache, "a", "a@2")
+	if !inList(cache.arc, listT2, "a") || backend.count("a") != 2 {
+		t.Errorf("expected a to be loaded again into T2, got %d loads", backend.count("a"))
+	}
+	expected := Stats{Hits: 1, Misses: 6}
+	if stats := cache.Stats(); !stats.Equals(&expected) {
+		t.Errorf("expected stats %+v, got %+v", expected, *stats)
+	}
+}
+
+// after the soft TTL the stale value is served while exactly one refresh
+// runs, and the refreshed value does not move the key
+func TestLoadingARCStaleWhileRevalidate(t *testing.T) {
+	cache, backend, clock := newTestLoadingARC(4)
+	for _, key := range []string{"a", "b", "c"} {
+		checkGet(t, cache, key, key+"@1")
+	}
+	checkGet(t, cache, "b", "b@1") // b goes to T2
+
+	clock.Advance(2 * time.Minute)
+	backend.gate = make(chan struct{})
+	for i := 0; i < 3; i++ {
+		checkGet(t, cache, "a", "a@1") // stale, returned right away
+	}
+	checkGet(t, cache, "c", "c@1") // stale as well, a second refresh
+	t1, t2 := cache.arc.t1.keys(), cache.arc.t2.keys()
+
+	close(backend.gate)
+	cache.Wait()
+	if backend.count("a") != 2 || backend.count("c") != 2 {
+		t.Errorf("expected exactly one refresh per key, got %d and %d loads", backend.count("a"), backend.count("c"))
+	}
+	if !reflect.DeepEqual(cache.arc.t1.keys(), t1) || !reflect.DeepEqual(cache.arc.t2.keys(), t2) {
+		t.Errorf("expected the refreshes to keep T1 %v and T2 %v, got %v and %v",
+			t1, t2, cache.arc.t1.keys(), cache.arc.t2.keys())
+	}
+	checkGet(t, cache, "a", "a@2")
+	if backend.count("a") != 2 {
+		t.Errorf("expected the refreshed value to be fresh, got %d loads", backend.count("a"))
+	}
+	if err := cache.arc.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+// after the hard TTL a value is not served, Get waits for the loader
+func TestLoadingARCHardTTL(t *testing.T) {
+	cache, backend, clock := newTestLoadingARC(4)
+	checkGet(t, cache, "a", "a@1")
+
+	clock.Advance(time.Hour)
+	checkGet(t, cache, "a", "a@2")
+	if backend.count("a") != 2 {
+		t.Errorf("expected a synchronous load, got %d loads", backend.count("a"))
+	}
+	expected := Stats{Hits: 0, Misses: 2}
+	if stats := cache.Stats(); !stats.Equals(&expected) {
+		t.Errorf("expected an expired value to count as a miss, got %+v", *stats)
+	}
+
+	// a failed refresh keeps serving the stale value until the hard TTL
+	clock.Advance(2 * time.Minute)
+	backend.fail["a"] = true
+	checkGet(t, cache, "a", "a@2")
+	cache.Wait()
+	checkGet(t, cache, "a", "a@2")
+	cache.Wait()
+	clock.Advance(time.Hour)
+	if _, err := cache.Get("a"); err == nil {
+		t.Errorf("expected the loader error once the value is too old")
+	}
+}
+
+// hot keys are refreshed before they go stale, other keys are not
+func TestLoadingARCRefreshAhead(t *testing.T) {
+	cache, backend, clock := newTestLoadingARC(4)
+	cache.SetRefreshAhead(10 * time.Second)
+	checkGet(t, cache, "hot", "hot@1")
+	checkGet(t, cache, "hot", "hot@1") // in T2
+	checkGet(t, cache, "cold", "cold@1")
+
+	clock.Advance(45 * time.Second)
+	checkGet(t, cache, "hot", "hot@1")
+	cache.Wait()
+	if backend.count("hot") != 1 {
+		t.Errorf("expected no refresh outside of the window, got %d loads", backend.count("hot"))
+	}
+
+	clock.Advance(10 * time.Second)
+	checkGet(t, cache, "hot", "hot@1")
+	cache.Wait()
+	if backend.count("hot") != 2 {
+		t.Errorf("expected a refresh ahead of the soft TTL, got %d loads", backend.count("hot"))
+	}
+	checkGet(t, cache, "hot", "hot@2")
+
+	// cold is in T1 when it is read, so it waits for the soft TTL
+	checkGet(t, cache, "cold", "cold@1")
+	cache.Wait()
+	if backend.count("cold") != 1 {
+		t.Errorf("expected no refresh ahead for a key in T1, got %d loads", backend.count("cold"))
+	}
+}
+
+// a refresh never overwrites a write that happened while it was loading
+func TestLoadingARCRefreshLosesToWrites(t *testing.T) {
+	cache, backend, clock := newTestLoadingARC(4)
+	checkGet(t, cache, "a", "a@1")
+	checkGet(t, cache, "b", "b@1")
+
+	clock.Advance(2 * time.Minute)
+	backend.gate = make(chan struct{})
+	checkGet(t, cache, "a", "a@1")
+	checkGet(t, cache, "b", "b@1")
+	cache.Set("a", []byte("written"))
+	cache.Remove("b")
+	close(backend.gate)
+	cache.Wait()
+
+	checkGet(t, cache, "a", "written")
+	if _, ok := cache.arc.index["b"]; ok {
+		t.Errorf("expected the refresh of a removed key not to bring it back")
+	}
+	if err := cache.arc.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+// misses on a key that is already loading wait for that load instead of
+// starting their own
+func TestLoadingARCSharedLoad(t *testing.T) {
+	cache, backend, _ := newTestLoadingARC(4)
+	backend.gate = make(chan struct{})
+
+	const getters = 8
+	var wg sync.WaitGroup
+	for i := 0; i < getters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checkGet(t, cache, "a", "a@1")
+		}()
+	}
+	for cache.Stats().Misses != getters {
+		time.Sleep(time.Millisecond) // every Get missed and waits on the gate
+	}
+	close(backend.gate)
+	wg.Wait()
+
+	if backend.count("a") != 1 {
+		t.Errorf("expected %d concurrent misses to share one load, got %d loads", getters, backend.count("a"))
+	}
+}
+
+// a stale value that fails to decode is loaded again, and is not refreshed
+// through an entry that was dropped
+func TestLoadingARCUndecodableStaleValue(t *testing.T) {
+	loads := 0
+	load := func(key string) ([]byte, error) {
+		loads++
+		return halves(100, loads), nil
+	}
+	clock := &fakeClock{now: time.Unix(316, 0)}
+	cache := NewLoadingARC(4, load, time.Minute, time.Hour)
+	cache.arc.now = clock.Now
+	codec := &halfCodec{}
+	cache.arc.SetCodec(codec, 0)
+
+	checkGet(t, cache, "a", string(halves(100, 1)))
+	clock.Advance(2 * time.Minute)
+	codec.broken = true
+	checkGet(t, cache, "a", string(halves(100, 2)))
+	cache.Wait()
+
+	if loads != 2 {
+		t.Errorf("expected the undecodable value to be loaded once more, got %d loads", loads)
+	}
+	if err := cache.arc.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+}
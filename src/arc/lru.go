@@ -17,6 +17,7 @@ type LRU struct {
 	sentinel *Node // a sentinel node, sentinel.next = first node, sentinel.prev = last node
 	mapNode  map[string]*Node // maps key to node holding the value
 	stats    *Stats // maintains stats associated with hits/misses
+	onEvict  EvictCallback // called for every evicted key-value pair, nil if unset
 }
 
 // helper node class, doubly linked
@@ -33,7 +34,8 @@ func NewLru(size int) *LRU {
 		size,
 		&Node{},
 		make(map[string]*Node),
-		&Stats{0, 0},
+		&Stats{},
+		nil,
 	}
 	lru.sentinel.prev = lru.sentinel
 	lru.sentinel.next = lru.sentinel
@@ -45,6 +47,16 @@ func (lru *LRU) MaxSize() int {
 	return lru.size
 }
 
+// Resize changes the number of entries the LRU can store, evicting the least
+// recently used entries if it shrinks below its current length
+func (lru *LRU) Resize(size int) {
+	size = max(size, 0)
+	for lru.Len() > size {
+		lru.deleteHead()
+	}
+	lru.size = size
+}
+
 // if a key is accessed, update it in the linked list as the most recently used key
 func (lru *LRU) updateMRU(node *Node) {
 		lru.detachNode(node)
@@ -92,7 +104,12 @@ func (lru *LRU) Remove(key string) (value []byte, ok bool) {
 
 // Set associates the given value with the given key, possibly evicting values
 // to make room. Returns true if the binding was added successfully, else false.
+// A zero-size LRU never holds a binding.
 func (lru *LRU) Set(key string, value []byte) bool {
+	if lru.size <= 0 {
+		return false
+	}
+
 	existingNode, contains := lru.mapNode[key]
 	
 	needToRemove := lru.Len() == lru.size
@@ -138,6 +155,16 @@ func (lru *LRU) ReturnKeys() []string{
 	return ans
 }
 
+// orderedKeys returns the keys by walking the linked list, from the least to
+// the most recently used. Used for debugging and checking invariants.
+func (lru *LRU) orderedKeys() []string {
+	ans := make([]string, 0, lru.Len())
+	for node := lru.sentinel.next; node != lru.sentinel; node = node.next {
+		ans = append(ans, node.key)
+	}
+	return ans
+}
+
 // Exposes the RemoveLRU function to client so they can delete the least-recently 
 // used key even if size has not been exceeded
 func (lru *LRU) RemoveLRU() (key string, ok bool) {
@@ -151,6 +178,31 @@ func (lru *LRU) RemoveLRU() (key string, ok bool) {
 }
 
 
+// SetOnEvict sets the callback that is called for every key-value pair the
+// LRU evicts, including the ones dropped by Purge and Reset. A nil callback
+// turns notifications off.
+func (lru *LRU) SetOnEvict(onEvict EvictCallback) {
+	lru.onEvict = onEvict
+}
+
+// Purge evicts every entry, from the least recently used. The LRU keeps its
+// size and stats, and can be used again right away.
+func (lru *LRU) Purge() {
+	for lru.Len() > 0 {
+		lru.deleteHead()
+	}
+}
+
+// Reset purges the LRU, and zeroes its stats if clearStats is set. An LRU
+// keeps no history besides its entries, so this only differs from Purge in
+// the stats.
+func (lru *LRU) Reset(clearStats bool) {
+	lru.Purge()
+	if clearStats {
+		*lru.stats = Stats{} // in place, callers may hold on to the pointer
+	}
+}
+
 // Stats returns statistics about how many search hits and misses have occurred.
 func (lru *LRU) Stats() *Stats {
 	return lru.stats
@@ -197,9 +249,17 @@ func (lru *LRU) removeNode(node *Node) {
 	delete(lru.mapNode, node.key)
 }
 
-// helper function to deleting head of LRU, updating linked list and relevant fields of struct
+// helper function to deleting head of LRU, updating linked list and relevant fields of struct.
+// The evicted key-value pair is passed to the eviction callback.
 func (lru *LRU) deleteHead() {
-	lru.removeNode(lru.sentinel.next)
+	node := lru.sentinel.next
+	if node == lru.sentinel {
+		return
+	}
+	lru.removeNode(node)
+	if lru.onEvict != nil {
+		lru.onEvict(node.key, node.value)
+	}
 }
 
 /**********************************************************************************/ 
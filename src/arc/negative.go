@@ -0,0 +1,88 @@
+// Negative Caching for ARC
+//
+// Dependencies: arc.go, arclist.go
+//
+// Description:
+// A lookup for a key that does not exist in the backend misses the cache
+// every time, and every miss goes to the backend again. With negative
+// caching enabled, the caller can record a tombstone for such a key with
+// SetNegative, and Lookup then tells a key that is known to be missing apart
+// from a key the cache knows nothing about.
+//
+// Tombstones are entries of the index like any other key, but they live in a
+// list of their own with a separate budget. They do not take slots from T1
+// or T2, so a burst of missing keys can never push real values out of the
+// cache, and when the budget is used up the least recently used tombstone is
+// dropped. A tombstone expires after its ttl, and a Set of the key replaces it.
+
+package arc
+
+import "time"
+
+// Presence is the answer of Lookup
+type Presence int
+
+const (
+	Unknown     Presence = iota // the key is not in the cache
+	Present                     // the key is cached with a value
+	KnownAbsent                 // the key is cached as missing, by a tombstone
+)
+
+// EnableNegativeCaching lets ARC keep up to budget tombstones, on top of the
+// size keys it caches. A budget <= 0 disables negative caching again. If the
+// budget shrinks, the least recently used tombstones are dropped.
+func (arc *ARC) EnableNegativeCaching(budget int) {
+	arc.negBudget = max(budget, 0)
+	for arc.neg.Len() > arc.negBudget {
+		arc.dropLRU(listNeg)
+	}
+}
+
+// SetNegative records that key is missing for the next ttl, replacing its
+// cached value if there is one. Any history ARC has of the key in the ghost
+// lists is dropped, it describes a value that no longer exists. Returns false
+// if negative caching is disabled.
+func (arc *ARC) SetNegative(key string, ttl time.Duration) bool {
+	if arc.negBudget <= 0 {
+		return false
+	}
+
+	e, ok := arc.index[key]
+	if (!ok || e.list != listNeg) && arc.neg.Len() >= arc.negBudget {
+		arc.dropLRU(listNeg) // a new tombstone
+	}
+	if ok {
+		// turn the entry into a tombstone
+		arc.unspill(e)
+		arc.list(e.list).unlink(e)
+		arc.untag(e)
+		arc.setValue(e, nil)
+		e.version = 0
+	} else {
+		e = arc.newEntry(key, nil)
+	}
+
+	e.expires = arc.now().Add(ttl).UnixNano()
+	e.list = listNeg
+	arc.neg.pushMRU(e)
+	return true
+}
+
+// Lookup is a Get that also reports keys that are known to be missing. A
+// value is Present, a live tombstone is KnownAbsent (counted as a negative
+// hit, neither a hit nor a miss) and anything else is Unknown.
+func (arc *ARC) Lookup(key string) ([]byte, Presence) {
+	if e, ok := arc.index[key]; ok && e.list == listNeg {
+		if arc.now().UnixNano() < e.expires {
+			arc.move(e, listNeg)
+			arc.stats.NegativeHits++
+			return nil, KnownAbsent
+		}
+		arc.drop(e) // expired
+	}
+
+	if value, ok := arc.Get(key); ok {
+		return value, Present
+	}
+	return nil, Unknown
+}
@@ -0,0 +1,189 @@
+package arc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that only moves when the test says so
+type fakeClock struct {
+	now time.Time
+}
+
+func (clock *fakeClock) Now() time.Time {
+	return clock.now
+}
+
+func (clock *fakeClock) Advance(d time.Duration) {
+	clock.now = clock.now.Add(d)
+}
+
+// newNegativeARC returns an ARC with negative caching and a fake clock
+func newNegativeARC(size, budget int) (*ARC, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(316, 0)}
+	arc := NewARC(size)
+	arc.now = clock.Now
+	arc.EnableNegativeCaching(budget)
+	return arc, clock
+}
+
+// Lookup tells cached values, tombstones and unknown keys apart, until the
+// tombstone expires
+func TestARCLookup(t *testing.T) {
+	arc, clock := newNegativeARC(4, 4)
+	arc.Set("cached", []byte("v"))
+	if !arc.SetNegative("missing", time.Minute) {
+		t.Fatalf("expected SetNegative to succeed")
+	}
+
+	lookups := []struct {
+		key      string
+		value    string
+		presence Presence
+	}{{"cached", "v", Present}, {"missing", "", KnownAbsent}, {"unknown", "", Unknown}}
+	for _, lookup := range lookups {
+		if value, presence := arc.Lookup(lookup.key); presence != lookup.presence || string(value) != lookup.value {
+			t.Errorf("Lookup(%s) = %q, %v, expected %q, %v", lookup.key, value, presence, lookup.value, lookup.presence)
+		}
+	}
+	if _, ok := arc.Get("missing"); ok {
+		t.Errorf("expected Get of a tombstone to miss")
+	}
+	expected := Stats{Hits: 1, Misses: 2, NegativeHits: 1}
+	if !arc.Stats().Equals(&expected) {
+		t.Errorf("expected stats %+v, got %+v", expected, *arc.Stats())
+	}
+
+	clock.Advance(time.Minute)
+	if _, presence := arc.Lookup("missing"); presence != Unknown {
+		t.Errorf("expected the tombstone to expire after its ttl, got %v", presence)
+	}
+	if arc.neg.Len() != 0 {
+		t.Errorf("expected the expired tombstone to be dropped, %d left", arc.neg.Len())
+	}
+	if err := arc.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+// a value replaces a tombstone and the other way around
+func TestARCSetNegativeReplaces(t *testing.T) {
+	arc, _ := newNegativeARC(4, 4)
+
+	arc.SetNegative("a", time.Minute)
+	arc.Set("a", []byte("v"))
+	if value, presence := arc.Lookup("a"); presence != Present || string(value) != "v" {
+		t.Errorf("expected Set to replace the tombstone, got %q, %v", value, presence)
+	}
+
+	arc.SetNegative("a", time.Minute)
+	if _, presence := arc.Lookup("a"); presence != KnownAbsent || arc.Len() != 0 {
+		t.Errorf("expected SetNegative to replace the value, got %v and %d cached keys", presence, arc.Len())
+	}
+
+	if _, ok := arc.Remove("a"); ok {
+		t.Errorf("expected Remove of a tombstone to report no value")
+	}
+	if _, presence := arc.Lookup("a"); presence != Unknown {
+		t.Errorf("expected Remove to forget the tombstone, got %v", presence)
+	}
+	if err := arc.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+// tombstones only compete with each other for their budget, they never
+// push values out of T1 or T2
+func TestARCNegativeBudget(t *testing.T) {
+	arc, _ := newNegativeARC(10, 5)
+	warmT2(arc, seqKeys("hot", 0, 5))
+	replayKeys(seqKeys("recent", 0, 5), arc)
+
+	for i := 0; i < 100; i++ {
+		arc.SetNegative(fmt.Sprint("missing", i), time.Minute)
+		if i == 97 {
+			arc.Lookup("missing93") // the oldest of 93..97
+		}
+	}
+
+	if arc.Len() != 10 {
+		t.Errorf("expected the tombstones to leave all 10 values cached, got %d", arc.Len())
+	}
+	if arc.neg.Len() != 5 {
+		t.Errorf("expected the budget of 5 tombstones to be used up, got %d", arc.neg.Len())
+	}
+	for _, key := range []string{"missing93", "missing99"} {
+		if _, presence := arc.Lookup(key); presence != KnownAbsent {
+			t.Errorf("expected the recently used tombstone of %s to be kept, got %v", key, presence)
+		}
+	}
+	if _, presence := arc.Lookup("missing94"); presence != Unknown {
+		t.Errorf("expected the least recently used tombstone to be dropped, got %v", presence)
+	}
+
+	arc.EnableNegativeCaching(2)
+	if arc.neg.Len() != 2 {
+		t.Errorf("expected a smaller budget to drop tombstones, %d left", arc.neg.Len())
+	}
+	arc.EnableNegativeCaching(0)
+	if arc.neg.Len() != 0 || arc.SetNegative("x", time.Minute) {
+		t.Errorf("expected disabling negative caching to drop all tombstones and refuse new ones")
+	}
+	if err := arc.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+// turning a cached key into a tombstone counts against the budget as well,
+// re-recording a tombstone does not
+func TestARCSetNegativeCachedKeyBudget(t *testing.T) {
+	arc, _ := newNegativeARC(4, 1)
+	arc.SetNegative("a", time.Minute)
+	arc.Set("b", []byte("v"))
+	arc.SetNegative("b", time.Minute)
+	if err := arc.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+	if _, presence := arc.Lookup("a"); presence != Unknown {
+		t.Errorf("expected the older tombstone to be dropped, got %v", presence)
+	}
+
+	arc.SetNegative("b", time.Minute)
+	if _, presence := arc.Lookup("b"); presence != KnownAbsent || arc.neg.Len() != 1 {
+		t.Errorf("expected the tombstone of b to be kept, got %v and %d tombstones", presence, arc.neg.Len())
+	}
+}
+
+// a tombstone drops the key's ghost, so setting it later is not a ghost hit
+func TestARCSetNegativeDropsGhost(t *testing.T) {
+	arc, _ := newNegativeARC(4, 4)
+	warmT2(arc, []string{"hot"})
+	replayKeys(seqKeys("k", 0, 8), arc)
+	if arc.b1.Len() == 0 {
+		t.Fatalf("expected a ghost in B1")
+	}
+	ghost := arc.b1.keys()[0]
+	p := arc.p
+
+	arc.SetNegative(ghost, time.Minute)
+	arc.Set(ghost, nil)
+	if !inList(arc, listT1, ghost) || arc.p != p {
+		t.Errorf("expected %s to come back as a new key with p at %d, got p = %d", ghost, p, arc.p)
+	}
+
+	// Purge and invalidation drop tombstones as well
+	arc.SetNegative("tenant1:a", time.Minute)
+	arc.SetNegative("tenant2:a", time.Minute)
+	arc.RemovePrefix("tenant1:")
+	if _, presence := arc.Lookup("tenant1:a"); presence != Unknown {
+		t.Errorf("expected RemovePrefix to drop the tombstone, got %v", presence)
+	}
+	arc.Purge()
+	if arc.neg.Len() != 0 {
+		t.Errorf("expected Purge to drop all tombstones, %d left", arc.neg.Len())
+	}
+	if err := arc.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+}
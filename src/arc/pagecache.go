@@ -0,0 +1,450 @@
+// Page Cache for Block Devices
+//
+// Dependencies: arc.go, arclist.go, version.go
+//
+// Description:
+// ARC was designed for the read cache of storage controllers, which cache the
+// blocks of a disk by their logical block address (LBA). PageCache does the
+// same for anything that is an io.ReaderAt, a large local file for example:
+// the device is cut into blocks of a fixed size, ARC caches them keyed by
+// their block number, and PageCache itself is the io.ReaderAt that reads
+// through the cache. A read may span any number of blocks.
+//
+// Readahead: a miss that continues a sequential read also reads the next
+// blocks, up to the readahead window, in the same device read (see
+// SetReadahead), and Prefetch reads a range the caller is about to need.
+// Reading a block in small pieces accesses it once, not once per piece.
+// Blocks read ahead go into T1 like a new key, and the first real read of
+// one is its first access, not its second, so a sequential pass over the
+// device does not flood T2.
+//
+// Writes go to the cache (PageCache is an io.WriterAt as well, if the device
+// is one): the blocks become dirty, and are written back to the device when
+// ARC evicts them from T1 or T2, or on Flush. A block whose write back fails
+// is kept until a Flush gets it to the device, and reads are served from it
+// meanwhile. The device has a fixed size, writes beyond it fail.
+//
+// A PageCache is safe for concurrent use, but it serves one request at a
+// time: device reads and writes are made with its lock held.
+
+package arc
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+var (
+	// ErrReadOnly is returned by PageCache.WriteAt if the device is not an
+	// io.WriterAt
+	ErrReadOnly = errors.New("arc: device is read-only")
+
+	// ErrBeyondDevice is returned by PageCache.WriteAt for a write past the
+	// end of the device
+	ErrBeyondDevice = errors.New("arc: write beyond the end of the device")
+
+	errNegativeOffset = errors.New("arc: negative offset")
+)
+
+// PageCacheStats counts the device activity of a PageCache
+type PageCacheStats struct {
+	DeviceReads     int // reads from the device
+	ReadaheadBlocks int // blocks read ahead of a miss or prefetched
+	ReadaheadHits   int // blocks read ahead that were read later
+	WriteBacks      int // dirty blocks written to the device
+	WriteErrors     int // dirty blocks that failed to be written
+}
+
+// PageCache caches the blocks of a device in an ARC
+type PageCache struct {
+	mu        sync.Mutex
+	arc       *ARC
+	dev       io.ReaderAt
+	size      int64 // bytes of the device
+	blockSize int
+
+	readahead  int            // blocks read ahead of a sequential miss, 0 for none
+	lastBlock  int64          // block accessed last, -1 if none
+	prefetched map[int64]bool // blocks read ahead and not accessed yet
+
+	dirty   map[int64]bool   // cached blocks the device does not have
+	pending map[int64][]byte // evicted dirty blocks that failed to be written
+	stats   PageCacheStats
+}
+
+// NewPageCache creates a page cache for the first size bytes of dev, in
+// blocks of blockSize bytes, that holds up to blocks of them. It panics if
+// blockSize is not positive.
+func NewPageCache(dev io.ReaderAt, size int64, blockSize, blocks int) *PageCache {
+	if blockSize <= 0 {
+		panic("arc: NewPageCache needs a positive block size")
+	}
+	c := &PageCache{
+		arc:        NewARC(blocks),
+		dev:        dev,
+		size:       size,
+		blockSize:  blockSize,
+		lastBlock:  -1,
+		prefetched: make(map[int64]bool),
+		dirty:      make(map[int64]bool),
+		pending:    make(map[int64][]byte),
+	}
+	c.arc.SetOnEvict(c.evicted)
+	return c
+}
+
+// SetReadahead sets how many blocks a miss reads ahead if it continues a
+// sequential read, or is at the start of the device. 0 turns readahead off.
+func (c *PageCache) SetReadahead(blocks int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readahead = max(blocks, 0)
+}
+
+// Size returns the size of the device
+func (c *PageCache) Size() int64 {
+	return c.size
+}
+
+// ReadAt reads len(p) bytes at off through the cache, as io.ReaderAt
+func (c *PageCache) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errNegativeOffset
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for n < len(p) && off+int64(n) < c.size {
+		pos := off + int64(n)
+		b := pos / int64(c.blockSize)
+		data, err := c.block(b)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], data[pos-b*int64(c.blockSize):])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteAt writes p at off into the cache, as io.WriterAt. The blocks are
+// written to the device later, see Flush.
+func (c *PageCache) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errNegativeOffset
+	}
+	if _, ok := c.dev.(io.WriterAt); !ok {
+		return 0, ErrReadOnly
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for n < len(p) && off+int64(n) < c.size {
+		pos := off + int64(n)
+		b := pos / int64(c.blockSize)
+		start := b * int64(c.blockSize)
+
+		// a block that is only written in part is read first, the write is
+		// its access
+		data := make([]byte, c.blockLen(b))
+		inner := int(pos - start)
+		if inner > 0 || len(p)-n < len(data) {
+			old, err := c.peek(b)
+			if err != nil {
+				return n, err
+			}
+			copy(data, old)
+		}
+		written := copy(data[inner:], p[n:])
+		if err := c.write(b, data); err != nil {
+			return n, err
+		}
+		n += written
+	}
+	if n < len(p) {
+		return n, ErrBeyondDevice
+	}
+	return n, nil
+}
+
+// Prefetch reads the blocks of the length bytes at off that are not cached,
+// as a hint that they are about to be read. Like blocks read ahead, they are
+// not accessed until they are read.
+func (c *PageCache) Prefetch(off, length int64) error {
+	if off < 0 {
+		return errNegativeOffset
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	end := min64(off+length, c.size)
+	for b := off / int64(c.blockSize); b*int64(c.blockSize) < end; b++ {
+		if c.cached(b) {
+			continue
+		}
+		last := (end - 1) / int64(c.blockSize)
+		if _, err := c.load(b, int(last-b), true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes every dirty block to the device, in block order, and returns
+// the first error. Blocks that fail to be written stay dirty.
+func (c *PageCache) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	blocks := make([]int64, 0, len(c.dirty)+len(c.pending))
+	for b := range c.dirty {
+		blocks = append(blocks, b)
+	}
+	for b := range c.pending {
+		blocks = append(blocks, b)
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i] < blocks[j] })
+
+	var first error
+	for _, b := range blocks {
+		data, pending := c.pending[b]
+		if !pending {
+			data = c.data(c.arc.index[blockKey(b)])
+		}
+		if err := c.writeBack(b, data); err != nil {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		delete(c.pending, b)
+		delete(c.dirty, b)
+	}
+	return first
+}
+
+// Dirty returns the number of blocks the device does not have yet
+func (c *PageCache) Dirty() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.dirty) + len(c.pending)
+}
+
+// Stats returns a snapshot of the hits and misses so far, counted per block
+func (c *PageCache) Stats() *Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := *c.arc.Stats()
+	return &stats
+}
+
+// PageCacheStats returns the device activity so far
+func (c *PageCache) PageCacheStats() PageCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// blockKey is the ARC key of block b
+func blockKey(b int64) string {
+	return strconv.FormatInt(b, 10)
+}
+
+// blockLen returns the length of block b, which is short if it is the last
+func (c *PageCache) blockLen(b int64) int {
+	return int(min64(int64(c.blockSize), c.size-b*int64(c.blockSize)))
+}
+
+// cached reports whether block b is in the cache, without accessing it
+func (c *PageCache) cached(b int64) bool {
+	if _, ok := c.pending[b]; ok {
+		return true
+	}
+	e, ok := c.arc.index[blockKey(b)]
+	return ok && e.resident()
+}
+
+// block returns the data of block b, from the cache or the device, and
+// counts the access. The data must not be modified.
+func (c *PageCache) block(b int64) ([]byte, error) {
+	key := blockKey(b)
+	last := c.lastBlock
+	c.lastBlock = b
+	if e, ok := c.arc.index[key]; ok && e.resident() {
+		if b == last {
+			// a block read in small pieces is accessed once
+			c.arc.stats.Hits++
+			return c.data(e), nil
+		}
+		if c.prefetched[b] {
+			c.stats.ReadaheadHits++
+		}
+		if c.firstAccess(b, e) {
+			c.arc.stats.Hits++
+			return c.data(e), nil
+		}
+		value, _ := c.arc.Get(key)
+		return value, nil
+	}
+	if data, ok := c.pending[b]; ok {
+		c.arc.stats.Hits++
+		return data, nil
+	}
+
+	c.arc.stats.Misses++
+	ahead := 0
+	if b == last+1 {
+		ahead = c.readahead
+	}
+	return c.load(b, ahead, false)
+}
+
+// data returns the data of the cached block e. The ARC of a page cache has
+// no codec, so the data can always be read.
+func (c *PageCache) data(e *entry) []byte {
+	data, _ := c.arc.valueOf(e)
+	return data
+}
+
+// peek returns the data of block b without accessing it. A block that is not
+// cached is read from the device, and not cached.
+func (c *PageCache) peek(b int64) ([]byte, error) {
+	if e, ok := c.arc.index[blockKey(b)]; ok && e.resident() {
+		return c.data(e), nil
+	}
+	if data, ok := c.pending[b]; ok {
+		return data, nil
+	}
+	return c.read(b, b)
+}
+
+// load reads block b and up to ahead blocks after it that are not cached, in
+// one device read, caches them and returns the data of b. The blocks after b
+// are cached as read ahead, and so is b if prefetch is set.
+func (c *PageCache) load(b int64, ahead int, prefetch bool) ([]byte, error) {
+	last := b
+	for last-b < int64(ahead) && (last+1)*int64(c.blockSize) < c.size && !c.cached(last+1) {
+		last++
+	}
+
+	buf, err := c.read(b, last)
+	if err != nil {
+		return nil, err
+	}
+	for i := b; i <= last; i++ {
+		offset := (i - b) * int64(c.blockSize)
+		end := offset + int64(c.blockLen(i))
+		if i == b && !prefetch {
+			c.arc.Set(blockKey(i), buf[offset:end:end]) // the block that missed
+			continue
+		}
+		if c.cacheAhead(i, buf[offset:end:end]) {
+			c.prefetched[i] = true
+			c.stats.ReadaheadBlocks++
+		}
+	}
+	return buf[:c.blockLen(b):c.blockLen(b)], nil
+}
+
+// cacheAhead caches block b, which was read ahead and is not cached, without
+// accessing it: it goes into T1 like a new key. If b is a ghost, its history
+// is dropped rather than counted as a hit, which would adapt p and put the
+// block into T2 before anyone asked for it.
+func (c *PageCache) cacheAhead(b int64, data []byte) bool {
+	key := blockKey(b)
+	if e, ok := c.arc.index[key]; ok {
+		c.arc.drop(e)
+	}
+	return c.arc.insert(key, data)
+}
+
+// firstAccess reports whether e, the entry of block b, was read ahead and is
+// accessed for the first time, by a read or a write. That access does not
+// promote e to T2: it only moves to the MRU end of T1. A block that is not in
+// T1 anymore is accessed as usual.
+func (c *PageCache) firstAccess(b int64, e *entry) bool {
+	if !c.prefetched[b] {
+		return false
+	}
+	delete(c.prefetched, b)
+	if e.list != listT1 {
+		return false
+	}
+	c.arc.move(e, listT1)
+	return true
+}
+
+// read reads the blocks from first to last from the device
+func (c *PageCache) read(first, last int64) ([]byte, error) {
+	start := first * int64(c.blockSize)
+	buf := make([]byte, min64((last+1)*int64(c.blockSize), c.size)-start)
+	c.stats.DeviceReads++
+	if n, err := c.dev.ReadAt(buf, start); n < len(buf) {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF // the device is smaller than its size
+		}
+		return nil, err
+	}
+	return buf, nil
+}
+
+// write stores data as the new content of block b, which becomes dirty
+func (c *PageCache) write(b int64, data []byte) error {
+	key := blockKey(b)
+	delete(c.pending, b) // superseded
+
+	if e, ok := c.arc.index[key]; ok && e.resident() && c.firstAccess(b, e) {
+		if c.arc.setValue(e, data) {
+			e.version = c.arc.nextVersion()
+			c.dirty[b] = true
+			return nil
+		}
+		c.arc.drop(e)
+	} else if c.arc.Set(key, data) {
+		c.dirty[b] = true
+		return nil
+	}
+
+	// the cache cannot hold the block, so it goes to the device right away
+	delete(c.dirty, b)
+	return c.writeBack(b, data)
+}
+
+// writeBack writes block b to the device
+func (c *PageCache) writeBack(b int64, data []byte) error {
+	if _, err := c.dev.(io.WriterAt).WriteAt(data, b*int64(c.blockSize)); err != nil {
+		c.stats.WriteErrors++
+		return err
+	}
+	c.stats.WriteBacks++
+	return nil
+}
+
+// evicted is the eviction callback of the ARC. A dirty block is written back
+// as it is evicted, or kept until Flush if that fails.
+func (c *PageCache) evicted(key string, value []byte) {
+	b, _ := strconv.ParseInt(key, 10, 64)
+	delete(c.prefetched, b)
+	if !c.dirty[b] {
+		return
+	}
+	delete(c.dirty, b)
+	if err := c.writeBack(b, value); err != nil {
+		c.pending[b] = value
+	}
+}
+
+// min64 returns the smaller of two int64
+func min64(x, y int64) int64 {
+	if x < y {
+		return x
+	}
+	return y
+}
@@ -0,0 +1,208 @@
+package arc
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+// memDevice is a device in memory that counts its reads. While failing,
+// writes fail.
+type memDevice struct {
+	data    []byte
+	reads   int
+	failing bool
+}
+
+func (d *memDevice) ReadAt(p []byte, off int64) (int, error) {
+	d.reads++
+	if off >= int64(len(d.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, d.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (d *memDevice) WriteAt(p []byte, off int64) (int, error) {
+	if d.failing {
+		return 0, errors.New("device failing")
+	}
+	return copy(d.data[off:], p), nil
+}
+
+// newTestDevice returns a device of size random bytes
+func newTestDevice(t *testing.T, size int) *memDevice {
+	d := &memDevice{data: make([]byte, size)}
+	newTestRand(t).Read(d.data)
+	return d
+}
+
+// reads of any offset and length return the bytes of the device, through a
+// cache that is much smaller than it
+func TestPageCacheReadAt(t *testing.T) {
+	dev := newTestDevice(t, 100*512+100)
+	cache := NewPageCache(dev, int64(len(dev.data)), 512, 8)
+	if err := iotest.TestReader(io.NewSectionReader(cache, 0, cache.Size()), dev.data); err != nil {
+		t.Error(err)
+	}
+
+	r := newTestRand(t)
+	for i := 0; i < 1000; i++ {
+		off := r.Intn(len(dev.data))
+		p := make([]byte, r.Intn(3*512))
+		n, err := cache.ReadAt(p, int64(off))
+		expected := min(len(p), len(dev.data)-off)
+		if n != expected || !bytes.Equal(p[:n], dev.data[off:off+n]) || (n < len(p)) != (err == io.EOF) {
+			t.Fatalf("ReadAt(%d bytes, %d) = %d, %v, expected %d bytes", len(p), off, n, err, expected)
+		}
+	}
+	if _, err := cache.WriteAt([]byte("x"), 0); err != nil {
+		t.Errorf("expected the device to be writable, got %v", err)
+	}
+	if _, err := NewPageCache(bytes.NewReader(dev.data), 10, 4, 4).WriteAt([]byte("x"), 0); err != ErrReadOnly {
+		t.Errorf("expected a read-only device to refuse writes, got %v", err)
+	}
+}
+
+// a sequential read reads ahead, and leaves T2 alone
+func TestPageCacheReadahead(t *testing.T) {
+	dev := newTestDevice(t, 64*512)
+	cache := NewPageCache(dev, int64(len(dev.data)), 512, 128)
+	cache.SetReadahead(15)
+
+	p := make([]byte, 100)
+	for off := 0; off < len(dev.data); off += len(p) {
+		cache.ReadAt(p, int64(off))
+	}
+	// every miss reads 16 blocks
+	if dev.reads != 4 {
+		t.Errorf("expected 4 device reads, got %d", dev.reads)
+	}
+	stats := cache.PageCacheStats()
+	if stats.ReadaheadBlocks != 60 || stats.ReadaheadHits != 60 {
+		t.Errorf("expected 60 blocks read ahead and hit, got %+v", stats)
+	}
+	if cache.arc.t1.Len() != 64 || cache.arc.t2.Len() != 0 {
+		t.Errorf("expected a single pass to leave every block in T1, got %d in T1 and %d in T2",
+			cache.arc.t1.Len(), cache.arc.t2.Len())
+	}
+
+	// a prefetched range is read without going to the device
+	cache = NewPageCache(dev, int64(len(dev.data)), 512, 128)
+	reads := dev.reads
+	cache.Prefetch(1000, 10*512)
+	cache.ReadAt(make([]byte, 10*512), 1000)
+	if dev.reads != reads+1 || cache.Stats().Misses != 0 {
+		t.Errorf("expected the prefetch to take one device read and the read none, got %d and %d misses",
+			dev.reads-reads, cache.Stats().Misses)
+	}
+
+	// overwriting a block read ahead is not a readahead hit
+	cache = NewPageCache(dev, int64(len(dev.data)), 512, 128)
+	cache.Prefetch(0, 2*512)
+	cache.WriteAt(make([]byte, 512), 0)
+	cache.ReadAt(p, 512)
+	if stats := cache.PageCacheStats(); stats.ReadaheadBlocks != 2 || stats.ReadaheadHits != 1 {
+		t.Errorf("expected only the read to count as a readahead hit, got %+v", stats)
+	}
+}
+
+// a block size that is not positive is refused
+func TestPageCacheBlockSize(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected NewPageCache to panic")
+		}
+	}()
+	NewPageCache(bytes.NewReader(nil), 10, 0, 4)
+}
+
+// a block read ahead that is a ghost is not a ghost hit: p stays, and the
+// block goes into T1 and stays there on its first read
+func TestPageCachePrefetchGhost(t *testing.T) {
+	dev := newTestDevice(t, 16*512)
+	cache := NewPageCache(dev, int64(len(dev.data)), 512, 8)
+	p := make([]byte, 512)
+	for _, b := range []int64{0, 1, 0, 2, 3, 4, 5, 6, 7, 8} {
+		cache.ReadAt(p, b*512)
+	}
+	if !inList(cache.arc, listB1, "1") {
+		t.Fatalf("expected block 1 to be a ghost in B1")
+	}
+
+	target := cache.arc.p
+	cache.Prefetch(512, 512)
+	if cache.arc.p != target || !inList(cache.arc, listT1, "1") {
+		t.Errorf("expected block 1 in T1 and p = %d, got p = %d", target, cache.arc.p)
+	}
+	if _, err := cache.ReadAt(p, 512); err != nil || !bytes.Equal(p, dev.data[512:1024]) {
+		t.Fatalf("expected block 1 to be read, got %v", err)
+	}
+	if cache.arc.p != target || !inList(cache.arc, listT1, "1") || cache.PageCacheStats().ReadaheadHits != 1 {
+		t.Errorf("expected the first read to keep block 1 in T1, got p = %d and %+v", cache.arc.p, cache.PageCacheStats())
+	}
+	if err := cache.arc.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+// writes stay in the cache until they are evicted or flushed, and are never
+// lost when the device fails
+func TestPageCacheWriteBack(t *testing.T) {
+	dev := newTestDevice(t, 32*512)
+	expected := append([]byte(nil), dev.data...)
+	cache := NewPageCache(dev, int64(len(dev.data)), 512, 4)
+	write := func(p []byte, off int) {
+		t.Helper()
+		copy(expected[off:], p)
+		if n, err := cache.WriteAt(p, int64(off)); n != len(p) || err != nil {
+			t.Fatalf("WriteAt(%d bytes, %d) = %d, %v", len(p), off, n, err)
+		}
+	}
+
+	write(bytes.Repeat([]byte("a"), 700), 300)
+	if cache.Dirty() != 2 || bytes.Equal(dev.data, expected) {
+		t.Errorf("expected 2 dirty blocks and the device unchanged, got %d dirty", cache.Dirty())
+	}
+	got := make([]byte, len(dev.data))
+	if cache.ReadAt(got, 0); !bytes.Equal(got, expected) {
+		t.Errorf("expected reads to see the writes")
+	}
+	if err := cache.Flush(); err != nil || cache.Dirty() != 0 || !bytes.Equal(dev.data, expected) {
+		t.Errorf("expected the flush to write the blocks, got %v, %d dirty", err, cache.Dirty())
+	}
+
+	// blocks written once stay in T1, so the oldest ones are evicted and
+	// written back
+	cache = NewPageCache(dev, int64(len(dev.data)), 512, 4)
+	for b := 0; b < 8; b++ {
+		write(bytes.Repeat([]byte{byte(b)}, 512), b*512)
+	}
+	if cache.Dirty() != 4 || !bytes.Equal(dev.data[:4*512], expected[:4*512]) {
+		t.Errorf("expected the 4 evicted blocks to be written back, got %d dirty", cache.Dirty())
+	}
+
+	dev.failing = true
+	for off := 0; off < len(expected); off += 1024 {
+		write([]byte("bb"), off+511)
+	}
+	if cache.ReadAt(got, 0); !bytes.Equal(got, expected) {
+		t.Errorf("expected reads to see the writes that failed to be written back")
+	}
+	if err := cache.Flush(); err == nil || cache.Dirty() != 32 {
+		t.Errorf("expected the flush to fail and keep 32 dirty blocks, got %v, %d", err, cache.Dirty())
+	}
+
+	dev.failing = false
+	if err := cache.Flush(); err != nil || cache.Dirty() != 0 || !bytes.Equal(dev.data, expected) {
+		t.Errorf("expected the flush to write every block, got %v, %d dirty", err, cache.Dirty())
+	}
+	if n, err := cache.WriteAt([]byte("xyz"), int64(len(expected)-1)); n != 1 || err != ErrBeyondDevice {
+		t.Errorf("expected a write past the end to stop there, got %d, %v", n, err)
+	}
+}
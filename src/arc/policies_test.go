@@ -0,0 +1,226 @@
+package arc
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// every policy has to be usable wherever a Cache is expected
+var (
+	_ Cache = (*LRU)(nil)
+	_ Cache = (*ARC)(nil)
+	_ Cache = (*S3FIFO)(nil)
+	_ Cache = (*SIEVE)(nil)
+	_ Cache = (*ClockPro)(nil)
+	_ Cache = (*ConcurrentARC)(nil)
+)
+
+// checkBasicCache runs the same Set/Get/Remove sequence against any policy
+func checkBasicCache(t *testing.T, name string, cache Cache) {
+	if cache.MaxSize() != 10 {
+		t.Errorf("%s: expected MaxSize 10, got %d", name, cache.MaxSize())
+	}
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint("k", i)
+		if !cache.Set(key, []byte(key)) {
+			t.Fatalf("%s: Set(%s) failed", name, key)
+		}
+		if value, ok := cache.Get(key); !ok || string(value) != key {
+			t.Fatalf("%s: Get(%s) right after Set returned %q, %v", name, key, value, ok)
+		}
+		if cache.Len() > cache.MaxSize() {
+			t.Fatalf("%s: Len %d exceeds MaxSize %d", name, cache.Len(), cache.MaxSize())
+		}
+	}
+
+	if value, ok := cache.Remove("k99"); !ok || string(value) != "k99" {
+		t.Errorf("%s: Remove(k99) returned %q, %v", name, value, ok)
+	}
+	if _, ok := cache.Get("k99"); ok {
+		t.Errorf("%s: k99 still present after Remove", name)
+	}
+	if _, ok := cache.Remove("k99"); ok {
+		t.Errorf("%s: second Remove(k99) succeeded", name)
+	}
+	if cache.Stats().Hits != 100 || cache.Stats().Misses != 1 {
+		t.Errorf("%s: expected 100 hits and 1 miss, got %+v", name, *cache.Stats())
+	}
+}
+
+func TestS3FIFOBasic(t *testing.T) {
+	checkBasicCache(t, "S3-FIFO", NewS3FIFO(10))
+}
+
+func TestSIEVEBasic(t *testing.T) {
+	checkBasicCache(t, "SIEVE", NewSIEVE(10))
+}
+
+// a key evicted from S without being reused is remembered in G, and
+// goes straight to M the next time it is inserted
+func TestS3FIFOGhostGoesToMain(t *testing.T) {
+	s := NewS3FIFO(10) // S targets 1 entry, M holds 9
+	s.Set("a", nil)
+	for i := 0; i < 9; i++ {
+		s.Set(fmt.Sprint("k", i), nil) // fills the cache, M is still empty
+	}
+	s.Set("b", nil) // evicts "a" from S into G
+
+	if s.ghost.Contains("a") == false {
+		t.Fatalf("expected a in ghost queue")
+	}
+	s.Set("a", nil)
+	if node := s.mapNode["a"]; node == nil || !node.main {
+		t.Errorf("expected a to be inserted into M after a ghost hit")
+	}
+	if s.ghost.Contains("a") {
+		t.Errorf("expected a to leave the ghost queue")
+	}
+}
+
+// visited entries survive a pass of the hand, unvisited ones are evicted
+func TestSIEVELazyPromotion(t *testing.T) {
+	s := NewSIEVE(3)
+	s.Set("a", nil)
+	s.Set("b", nil)
+	s.Set("c", nil)
+	s.Get("a")
+
+	s.Set("d", nil) // hand skips "a" and evicts "b"
+	if _, ok := s.Get("b"); ok {
+		t.Errorf("expected b to be evicted")
+	}
+	if _, ok := s.Get("a"); !ok {
+		t.Errorf("expected visited a to survive")
+	}
+
+	// the hand stopped after "b", so "c" is the next victim
+	s.Set("e", nil)
+	if _, ok := s.Get("c"); ok {
+		t.Errorf("expected c to be evicted")
+	}
+}
+
+// buildHotSetTrace returns a trace where a small hot set is requested twice
+// between bursts of one-shot keys. Once hot+burst exceeds the cache size, an
+// LRU loses the whole hot set every round and only hits on the second pass.
+func buildHotSetTrace(rounds, hot, burst int) string {
+	var b strings.Builder
+	line, unique := 0, 0
+	for r := 0; r < rounds; r++ {
+		for i := 0; i < 2*hot; i++ {
+			fmt.Fprintf(&b, "%d hot%d 1\n", line, i%hot)
+			line++
+		}
+		for i := 0; i < burst; i++ {
+			fmt.Fprintf(&b, "%d once%d 1\n", line, unique)
+			line++
+			unique++
+		}
+	}
+	return b.String()
+}
+
+func TestReplayTraceFIFOPolicies(t *testing.T) {
+	trace := buildHotSetTrace(200, 10, 15)
+	lru, s3, sieve := NewLru(20), NewS3FIFO(20), NewSIEVE(20)
+
+	if err := ReplayTrace(strings.NewReader(trace), 0, 0, lru, s3, sieve); err != nil {
+		t.Fatal(err)
+	}
+
+	total := 200 * 35
+	for _, cache := range []Cache{lru, s3, sieve} {
+		stats := cache.Stats()
+		if stats.Hits+stats.Misses != total {
+			t.Errorf("expected %d requests, got %+v", total, *stats)
+		}
+	}
+
+	fmt.Printf("%v,%v,%v\n", lru.Stats().HitRate(), s3.Stats().HitRate(), sieve.Stats().HitRate())
+	if s3.Stats().Hits <= lru.Stats().Hits {
+		t.Errorf("expected S3-FIFO to beat LRU on a hot set with scans, got %d vs %d hits",
+			s3.Stats().Hits, lru.Stats().Hits)
+	}
+	if sieve.Stats().Hits <= lru.Stats().Hits {
+		t.Errorf("expected SIEVE to beat LRU on a hot set with scans, got %d vs %d hits",
+			sieve.Stats().Hits, lru.Stats().Hits)
+	}
+}
+
+func TestReplayTraceRange(t *testing.T) {
+	trace := "0 a 1\n1 b 1\n2 a 1\n\n3 c 1\n4 a 1\n"
+	lru := NewLru(10)
+
+	// lines [1, 4) are "b", "a" and the blank line
+	if err := ReplayTrace(strings.NewReader(trace), 1, 4, lru); err != nil {
+		t.Fatal(err)
+	}
+	if lru.Len() != 2 || !lru.Contains("a") || !lru.Contains("b") {
+		t.Errorf("expected only a and b to be replayed, got %v", lru.ReturnKeys())
+	}
+}
+
+func TestClockProBasic(t *testing.T) {
+	checkBasicCache(t, "CLOCK-Pro", NewClockPro(10))
+}
+
+// buildLoopTrace returns a trace that requests keys 0..n-1 in order, over
+// and over. Any cache smaller than n that evicts in recency order (LRU)
+// misses on every request.
+func buildLoopTrace(loops, n int) string {
+	var b strings.Builder
+	line := 0
+	for l := 0; l < loops; l++ {
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&b, "%d loop%d 1\n", line, i)
+			line++
+		}
+	}
+	return b.String()
+}
+
+func TestClockProLoop(t *testing.T) {
+	trace := buildLoopTrace(100, 120)
+	lru, clockPro := NewLru(100), NewClockPro(100)
+
+	if err := ReplayTrace(strings.NewReader(trace), 0, 0, lru, clockPro); err != nil {
+		t.Fatal(err)
+	}
+	if !clockPro.invariant() {
+		t.Errorf("INVARIANT VIOLATED")
+	}
+
+	fmt.Printf("%v,%v\n", lru.Stats().HitRate(), clockPro.Stats().HitRate())
+	if lru.Stats().Hits != 0 {
+		t.Errorf("expected LRU to never hit on a loop larger than the cache, got %d hits", lru.Stats().Hits)
+	}
+	if clockPro.Stats().Hits == 0 {
+		t.Errorf("expected CLOCK-Pro to keep part of the loop resident")
+	}
+}
+
+// random mix of operations, checking the page counters after every step
+func TestClockProInvariant(t *testing.T) {
+	clockPro := NewClockPro(20)
+	r := rand.New(rand.NewSource(316))
+
+	for i := 0; i < 20000; i++ {
+		key := fmt.Sprint("k", r.Intn(60))
+		switch r.Intn(4) {
+		case 0, 1:
+			clockPro.Get(key)
+		case 2:
+			clockPro.Set(key, []byte(key))
+		case 3:
+			if r.Intn(10) == 0 {
+				clockPro.Remove(key)
+			}
+		}
+		if !clockPro.invariant() {
+			t.Fatalf("INVARIANT VIOLATED after %d operations", i+1)
+		}
+	}
+}
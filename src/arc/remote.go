@@ -0,0 +1,120 @@
+// Remote Writes for ARC
+//
+// Dependencies: arc.go, arclist.go, version.go
+//
+// Description:
+// When several processes cache the same data, each in its own ARC, a write
+// in one of them must reach the others, or they keep serving stale copies.
+// SetVersioned and RemoveVersioned are Set and Remove that also return the
+// version of the write, so it can be broadcast (see cluster.Replicator), and
+// ApplySet and ApplyRemove apply a write that was broadcast by another ARC.
+//
+// The versions of all the ARCs are kept comparable with a hybrid logical
+// clock: a versioned write gets a version past both every version the ARC
+// has seen and the wall-clock time in nanoseconds, and applying a write
+// moves the version counter up to the version of the write. So every local
+// write is newer than the writes it has seen, and an ARC that just started,
+// or that missed events, still writes versions newer than the writes made
+// before it elsewhere (up to the skew between the clocks), instead of having
+// its writes dropped as stale. A remote write is applied only if it is newer
+// than what the key holds, which makes applying it idempotent and lets
+// writes arrive out of order. Two writes of
+// the same version (made concurrently in two ARCs) are decided by their
+// values: a removal wins, otherwise the larger value does, so every ARC
+// makes the same choice.
+//
+// Remote writes are not accesses. A remote Set only updates a key that is
+// cached, in place, without moving it in T1 or T2; it does not bring in keys
+// the local users never asked for. A remote Remove drops the key outright,
+// instead of evicting it into B1 or B2, and both drop the key if it is only
+// a ghost or a tombstone: its history describes data that has changed, so
+// it must not adapt p when the key is filled again (as in invalidate.go).
+
+package arc
+
+import (
+	"bytes"
+	"time"
+)
+
+// SetVersioned puts a key-value pair into cache like Set, and returns the
+// version the value was stored with
+func (arc *ARC) SetVersioned(key string, value []byte) (uint64, bool) {
+	arc.observeVersion(arc.clockVersion())
+	if !arc.Set(key, value) {
+		return 0, false
+	}
+	return arc.index[key].version, true
+}
+
+// RemoveVersioned removes key like Remove, and returns the value it had along
+// with a version for the removal, newer than every write so far. The version
+// is returned even if the key was not cached.
+func (arc *ARC) RemoveVersioned(key string) ([]byte, uint64, bool) {
+	arc.observeVersion(arc.clockVersion())
+	value, ok := arc.Remove(key)
+	return value, arc.nextVersion(), ok
+}
+
+// ApplySet applies a Set that another ARC made with the given version.
+// Returns true if the key was cached and now holds value.
+func (arc *ARC) ApplySet(key string, value []byte, version uint64) bool {
+	arc.observeVersion(version)
+	e, ok := arc.index[key]
+	if !ok {
+		return false
+	}
+	if !e.resident() {
+		arc.drop(e)
+		return false
+	}
+
+	current, ok := arc.valueOf(e)
+	if !ok {
+		arc.drop(e) // cannot be decoded, the key is not cached after all
+		return false
+	}
+	if version < e.version || (version == e.version && bytes.Compare(value, current) <= 0) {
+		return false // stale, or applied already
+	}
+	if !arc.setValue(e, value) {
+		arc.drop(e)
+		return false
+	}
+	e.version = version
+	return true
+}
+
+// ApplyRemove applies a Remove that another ARC made with the given version.
+// Returns true if the key was cached and is now removed.
+func (arc *ARC) ApplyRemove(key string, version uint64) bool {
+	arc.observeVersion(version)
+	e, ok := arc.index[key]
+	if !ok {
+		return false
+	}
+	if e.resident() && version < e.version {
+		return false // the key was written again since
+	}
+
+	resident := e.resident()
+	arc.drop(e)
+	return resident
+}
+
+// clockVersion returns the wall-clock time as a version, the physical part of
+// the hybrid logical clock
+func (arc *ARC) clockVersion() uint64 {
+	now := arc.now()
+	if now.Before(time.Unix(0, 0)) {
+		return 0
+	}
+	return uint64(now.UnixNano())
+}
+
+// observeVersion moves the version counter up to a version of another ARC
+func (arc *ARC) observeVersion(version uint64) {
+	if version > arc.version {
+		arc.version = version
+	}
+}
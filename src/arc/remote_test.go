@@ -0,0 +1,121 @@
+package arc
+
+import (
+	"testing"
+	"time"
+)
+
+// a remote Set updates a cached key in place, once, and only if it is newer
+func TestARCApplySet(t *testing.T) {
+	arc := NewARC(4)
+	version, _ := arc.SetVersioned("a", []byte("old"))
+	arc.Set("b", []byte("b"))
+
+	if !arc.ApplySet("a", []byte("new"), version+10) {
+		t.Fatalf("expected a newer remote Set to be applied")
+	}
+	if arc.ApplySet("a", []byte("new"), version+10) || arc.ApplySet("a", []byte("older"), version+5) {
+		t.Errorf("expected a repeated or older remote Set to be ignored")
+	}
+	if !inList(arc, listT1, "a") || arc.t1.lru().key != "a" {
+		t.Errorf("expected the remote Set not to touch the recency of a")
+	}
+	if value, got, _ := arc.GetWithVersion("a"); string(value) != "new" || got != version+10 {
+		t.Errorf("expected a = new at version %d, got %q at %d", version+10, value, got)
+	}
+
+	// local writes are newer than every remote write seen
+	if next, _ := arc.SetVersioned("b", []byte("b")); next <= version+10 {
+		t.Errorf("expected a local write after version %d, got %d", version+10, next)
+	}
+
+	// keys that are not cached are not brought in, ghosts are dropped
+	arc.ghost(arc.index["b"])
+	stats := *arc.Stats()
+	if arc.ApplySet("b", []byte("new"), 100) || arc.ApplySet("c", []byte("new"), 100) {
+		t.Errorf("expected a remote Set of keys not cached to be ignored")
+	}
+	if _, ok := arc.index["b"]; ok {
+		t.Errorf("expected the ghost of b to be dropped")
+	}
+	if _, ok := arc.index["c"]; ok || *arc.Stats() != stats {
+		t.Errorf("expected the remote Set to leave no trace of c and the stats alone")
+	}
+	if err := arc.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+// versioned writes follow the wall clock, so an ARC that starts late is not
+// behind the ARCs that wrote before it
+func TestARCVersionClock(t *testing.T) {
+	early, late := NewARC(4), NewARC(4)
+	clock := &fakeClock{now: time.Unix(316, 0)}
+	early.now, late.now = clock.Now, clock.Now
+
+	var version uint64
+	for i := 0; i < 3; i++ {
+		version, _ = early.SetVersioned("k", []byte("early"))
+	}
+	if version != uint64(clock.now.UnixNano())+3 {
+		t.Errorf("expected writes within a tick to count up from the clock, got %d", version)
+	}
+
+	clock.Advance(time.Millisecond)
+	lateVersion, _ := late.SetVersioned("k", []byte("late"))
+	if lateVersion <= version || !early.ApplySet("k", []byte("late"), lateVersion) {
+		t.Errorf("expected the write of the late ARC (%d) to be newer than %d", lateVersion, version)
+	}
+}
+
+// a remote Remove drops the key instead of evicting it into B1 or B2
+func TestARCApplyRemove(t *testing.T) {
+	arc := NewARC(4)
+	first, _ := arc.SetVersioned("a", []byte("a"))
+	_, second, _ := arc.RemoveVersioned("x")
+	arc.Set("a", []byte("again"))
+
+	if arc.ApplyRemove("a", second) {
+		t.Errorf("expected a remote Remove older than the last write to be ignored")
+	}
+	if !arc.ApplyRemove("a", arc.index["a"].version) {
+		t.Errorf("expected a remote Remove of the current version to be applied")
+	}
+	if _, ok := arc.index["a"]; ok || arc.b1.Len()+arc.b2.Len() != 0 {
+		t.Errorf("expected a to leave no ghost")
+	}
+	if arc.ApplyRemove("a", first) {
+		t.Errorf("expected a repeated remote Remove to change nothing")
+	}
+	if err := arc.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+// two ARCs that write the same key concurrently end up with the same value,
+// whichever order the writes arrive in
+func TestARCConcurrentWrites(t *testing.T) {
+	a, b := NewARC(4), NewARC(4)
+	clock := &fakeClock{now: time.Unix(316, 0)}
+	a.now, b.now = clock.Now, clock.Now
+	versionA, _ := a.SetVersioned("k", []byte("from a"))
+	versionB, _ := b.SetVersioned("k", []byte("from b"))
+	if versionA != versionB {
+		t.Fatalf("expected both writes to get the same version")
+	}
+	a.ApplySet("k", []byte("from b"), versionB)
+	b.ApplySet("k", []byte("from a"), versionA)
+
+	valueA, _ := a.Get("k")
+	valueB, _ := b.Get("k")
+	if string(valueA) != "from b" || string(valueB) != "from b" {
+		t.Errorf("expected both to keep the larger value, got %q and %q", valueA, valueB)
+	}
+
+	// a removal of the same version wins over a Set
+	_, removed, _ := a.RemoveVersioned("k")
+	version, _ := b.SetVersioned("k", []byte("set"))
+	if version != removed || !b.ApplyRemove("k", removed) || a.ApplySet("k", []byte("set"), version) {
+		t.Errorf("expected the removal to win")
+	}
+}
@@ -0,0 +1,187 @@
+// S3-FIFO Cache Implementation
+//
+// Dependencies: fifo.go, lru.go, utility.go
+//
+// Description:
+// S3-FIFO (Yang et al., SOSP '23) is a fixed-size cache built only out of
+// FIFO queues. New keys enter a small queue S (10% of the cache), keys that
+// are accessed again while in S are promoted to the main queue M, and keys
+// that are evicted from S without being touched are remembered in a ghost
+// queue G. A key that misses but is found in G goes straight into M. Hits
+// only bump a small counter on the node, so unlike LRU there is no list
+// reordering on the read path.
+
+package arc
+
+import "fmt"
+
+// maximum value of the per-node frequency counter
+const s3fifoMaxFreq = 3
+
+type S3FIFO struct {
+	size      int // size is the fixed number of key-value pairs the cache stores
+	smallSize int // target number of entries in the small queue S
+
+	small   *fifoQueue           // S, probationary queue for newly inserted keys
+	main    *fifoQueue           // M, queue for keys that proved to be reused
+	ghost   *LRU                 // G, keys evicted from S (values are not kept)
+	mapNode map[string]*fifoNode // maps key to the node holding the value
+	stats   *Stats               // maintains stats associated with hits/misses
+}
+
+// NewS3FIFO creates an S3-FIFO cache of the given size
+func NewS3FIFO(size int) *S3FIFO {
+	smallSize := size / 10
+	if smallSize < 1 {
+		smallSize = 1
+	}
+
+	return &S3FIFO{
+		size:      size,
+		smallSize: smallSize,
+		small:     newFifoQueue(),
+		main:      newFifoQueue(),
+		ghost:     NewLru(max(size-smallSize, 1)), // G remembers as many keys as M holds
+		mapNode:   make(map[string]*fifoNode),
+		stats:     &Stats{},
+	}
+}
+
+// Get returns the value associated with the given key, if it exists.
+// A hit only increments the node's frequency counter.
+func (s *S3FIFO) Get(key string) ([]byte, bool) {
+	node, ok := s.mapNode[key]
+	if !ok {
+		s.stats.Misses++
+		return nil, false
+	}
+
+	node.freq = min(node.freq+1, s3fifoMaxFreq)
+	s.stats.Hits++
+	return node.value, true
+}
+
+// Set puts a key-value pair into cache. Returns true if the binding was
+// added successfully, else false.
+func (s *S3FIFO) Set(key string, value []byte) bool {
+	if s.size <= 0 {
+		return false
+	}
+
+	// an update counts as an access, same as a Get hit
+	if node, ok := s.mapNode[key]; ok {
+		node.value = value
+		node.freq = min(node.freq+1, s3fifoMaxFreq)
+		return true
+	}
+
+	for s.Len() >= s.size {
+		s.evict()
+	}
+
+	node := &fifoNode{key: key, value: value}
+	s.mapNode[key] = node
+
+	// keys that were recently evicted from S skip probation
+	if s.ghost.Contains(key) {
+		s.ghost.Remove(key)
+		s.main.pushBack(node)
+		node.main = true
+	} else {
+		s.small.pushBack(node)
+	}
+	return true
+}
+
+// evict frees exactly one slot, either from S or from M
+func (s *S3FIFO) evict() {
+	if s.small.Len() >= s.smallSize || s.main.Len() == 0 {
+		s.evictSmall()
+	} else {
+		s.evictMain()
+	}
+}
+
+// evictSmall evicts the oldest untouched key in S into G, promoting
+// any reused keys it passes over into M
+func (s *S3FIFO) evictSmall() {
+	for node := s.small.oldest(); node != nil; node = s.small.oldest() {
+		s.small.unlink(node)
+
+		if node.freq > 0 {
+			// reused while on probation, so move into M
+			node.freq = 0
+			if s.main.Len() >= s.size-s.smallSize {
+				s.evictMain()
+			}
+			s.main.pushBack(node)
+			node.main = true
+			continue
+		}
+
+		delete(s.mapNode, node.key)
+		s.ghost.Set(node.key, nil)
+		return
+	}
+
+	// everything in S was promoted, so the free slot has to come from M
+	if s.Len() >= s.size {
+		s.evictMain()
+	}
+}
+
+// evictMain runs FIFO-reinsertion (CLOCK) over M until one key is evicted
+func (s *S3FIFO) evictMain() {
+	for node := s.main.oldest(); node != nil; node = s.main.oldest() {
+		s.main.unlink(node)
+
+		if node.freq > 0 {
+			node.freq--
+			s.main.pushBack(node)
+			continue
+		}
+
+		delete(s.mapNode, node.key)
+		return
+	}
+}
+
+// Remove removes and returns the value associated with the given key, if it exists.
+// If key not in the cache, returns nil,false
+func (s *S3FIFO) Remove(key string) ([]byte, bool) {
+	node, ok := s.mapNode[key]
+	if !ok {
+		return nil, false
+	}
+
+	if node.main {
+		s.main.unlink(node)
+	} else {
+		s.small.unlink(node)
+	}
+	delete(s.mapNode, key)
+	return node.value, true
+}
+
+// Len returns the number of entries in the cache
+func (s *S3FIFO) Len() int {
+	return s.small.Len() + s.main.Len()
+}
+
+// MaxSize returns the number of entries supported by the cache
+func (s *S3FIFO) MaxSize() int {
+	return s.size
+}
+
+// Stats returns statistics about how many search hits and misses have occurred.
+func (s *S3FIFO) Stats() *Stats {
+	return s.stats
+}
+
+// report hits/misses from Get calls to stdout
+func (s *S3FIFO) ReportStats() {
+	fmt.Println("S3-FIFO Hits/Misses")
+	fmt.Println("Number of Hits:", s.stats.Hits)
+	fmt.Println("Number of Misses:", s.stats.Misses)
+	fmt.Println("Percentage of Hits:", s.stats.HitRate())
+}
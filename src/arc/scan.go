@@ -0,0 +1,80 @@
+// Scan Detection for ARC
+//
+// Dependencies: arc.go
+//
+// Description:
+// ARC is already scan resistant for T2: a one-shot scan only ever touches
+// each key once, so it churns through T1 and leaves the frequently used keys
+// in T2 alone. It still flushes everything in T1 though, including keys that
+// were about to be reused. With scan detection enabled, ARC watches for runs
+// of brand new keys that only differ by an increasing numeric suffix
+// (k100, k101, k102, ...). Once a run is long enough, new keys of the run are
+// inserted at the LRU end of T1, so they are the next keys to be evicted and
+// the scan keeps recycling a single slot instead of pushing out all of T1.
+
+package arc
+
+import "strconv"
+
+// scanDetector tracks the current run of sequential keys
+type scanDetector struct {
+	minRun int // number of sequential keys before a run is treated as a scan
+
+	prefix string // non-numeric prefix of the last key seen
+	number int    // numeric suffix of the last key seen
+	run    int    // length of the current sequential run
+}
+
+// EnableScanDetection makes ARC treat runs of at least minRun new keys with
+// consecutive numeric suffixes as a sequential scan. A minRun <= 0 disables
+// scan detection again.
+func (arc *ARC) EnableScanDetection(minRun int) {
+	if minRun <= 0 {
+		arc.scan = nil
+		return
+	}
+	arc.scan = &scanDetector{minRun: minRun}
+}
+
+// observe records that a brand new key is being inserted, and returns
+// true if it belongs to a sequential scan. A nil detector never reports
+// a scan.
+func (scan *scanDetector) observe(key string) bool {
+	if scan == nil {
+		return false
+	}
+
+	prefix, number, ok := splitNumericSuffix(key)
+	if !ok {
+		scan.run = 0
+		return false
+	}
+
+	if scan.run > 0 && prefix == scan.prefix && number == scan.number+1 {
+		scan.run++
+	} else {
+		scan.run = 1
+	}
+	scan.prefix = prefix
+	scan.number = number
+
+	return scan.run >= scan.minRun
+}
+
+// splitNumericSuffix splits "page1234" into "page" and 1234. ok is false if
+// the key does not end in a decimal number.
+func splitNumericSuffix(key string) (prefix string, number int, ok bool) {
+	i := len(key)
+	for i > 0 && key[i-1] >= '0' && key[i-1] <= '9' {
+		i--
+	}
+	if i == len(key) {
+		return key, 0, false
+	}
+
+	number, err := strconv.Atoi(key[i:])
+	if err != nil {
+		return key, 0, false // suffix too long to be an int
+	}
+	return key[:i], number, true
+}
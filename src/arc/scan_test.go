@@ -0,0 +1,202 @@
+package arc
+
+import (
+	"fmt"
+	"testing"
+
+	"cos316.princeton.edu/final_proj/workload"
+)
+
+/******************************************************************************/
+/*                                 Helpers                                    */
+/******************************************************************************/
+
+// seqKeys returns n keys with consecutive numeric suffixes, a one-shot scan
+func seqKeys(prefix string, start, n int) []string {
+	return workload.Keys(workload.NewScan(prefix, start), n)
+}
+
+// replayKeys requests every key from every cache, filling it on a miss
+func replayKeys(keys []string, caches ...Cache) {
+	for _, key := range keys {
+		for _, cache := range caches {
+			if _, hit := cache.Get(key); !hit {
+				cache.Set(key, []byte{})
+			}
+		}
+	}
+}
+
+// inList reports whether key is in the given list of the ARC
+func inList(arc *ARC, list listID, key string) bool {
+	e, ok := arc.index[key]
+	return ok && e.list == list
+}
+
+/******************************************************************************/
+/*                                  Tests                                     */
+/******************************************************************************/
+
+// warmT2 puts every key of the working set into T2 by accessing it twice
+func warmT2(arc *ARC, keys []string) {
+	for _, key := range keys {
+		arc.Set(key, []byte{})
+		arc.Get(key)
+	}
+}
+
+// a one-shot scan only churns T1, the working set in T2 must survive it
+func TestARCScanKeepsT2(t *testing.T) {
+	arc := NewARC(40)
+	lru := NewLru(40)
+	working := seqKeys("hot", 0, 10)
+
+	warmT2(arc, working)
+	replayKeys(working, lru)
+	replayKeys(seqKeys("scan", 0, 1000), arc, lru)
+
+	for _, key := range working {
+		if !inList(arc, listT2, key) {
+			t.Errorf("expected %s to stay in T2 during the scan", key)
+		}
+		if lru.Contains(key) {
+			t.Errorf("expected the scan to flush %s out of LRU", key)
+		}
+	}
+	if !arc.invariant() {
+		t.Errorf("INVARIANT VIOLATED")
+	}
+}
+
+// with scan detection on, a scan also leaves the keys already in T1 alone,
+// except for the minRun keys it evicts until the run is long enough to be detected.
+// REPLACE only takes from T1 while |T1| > p (c/2 here), so T1 is filled past
+// p: with fewer recent keys than hot ones the scan would evict from T2 instead.
+func TestARCScanDetectionKeepsT1(t *testing.T) {
+	const minRun = 4
+	recent := []string{"user", "cart", "home", "search", "about", "faq",
+		"login", "blog", "news", "help", "docs", "terms"}
+
+	run := func(detect bool) int {
+		arc := NewARC(20)
+		if detect {
+			arc.EnableScanDetection(minRun)
+		}
+		warmT2(arc, seqKeys("hot", 0, 8))
+		replayKeys(recent, arc)
+		replayKeys(seqKeys("scan", 0, 500), arc)
+
+		if !arc.invariant() {
+			t.Errorf("INVARIANT VIOLATED")
+		}
+		for _, key := range seqKeys("hot", 0, 8) {
+			if !inList(arc, listT2, key) {
+				t.Errorf("expected %s to stay in T2 during the scan", key)
+			}
+		}
+
+		kept := 0
+		for _, key := range recent {
+			if inList(arc, listT1, key) {
+				kept++
+			}
+		}
+		return kept
+	}
+
+	if kept := run(false); kept != 0 {
+		t.Errorf("expected a scan to flush T1 without detection, %d keys kept", kept)
+	}
+	if kept := run(true); kept != len(recent)-minRun {
+		t.Errorf("expected %d of %d T1 keys to survive the scan, got %d",
+			len(recent)-minRun, len(recent), kept)
+	}
+}
+
+// keys without a numeric suffix, or out of order, never count as a scan
+func TestScanDetectorRuns(t *testing.T) {
+	scan := &scanDetector{minRun: 3}
+	steps := []struct {
+		key  string
+		scan bool
+	}{
+		{"a1", false}, {"a2", false}, {"a3", true}, {"a4", true},
+		{"b5", false}, {"b6", false}, {"b8", false}, {"b9", false},
+		{"x", false}, {"b10", false}, {"b11", false}, {"b12", true},
+	}
+
+	for _, step := range steps {
+		if got := scan.observe(step.key); got != step.scan {
+			t.Errorf("observe(%s) = %v, expected %v", step.key, got, step.scan)
+		}
+	}
+
+	var disabled *scanDetector
+	if disabled.observe("a1") || disabled.observe("a2") {
+		t.Errorf("a disabled detector reported a scan")
+	}
+}
+
+// a loop over more keys than the cache is a worst case for LRU, and for ARC
+// as well since |T1|+|B1| <= c: no key is seen twice while it is still in T1
+// or B1 (see the replacement notes in arc.go). With scan detection the loop
+// recycles one slot of T1, so at least the first passes over the loop hit.
+func TestARCLoop(t *testing.T) {
+	keys := workload.Keys(workload.NewLoop("loop", 60), 60*50)
+	arc := NewARC(50)
+	lru := NewLru(50)
+	detect := NewARC(50)
+	detect.EnableScanDetection(8)
+	replayKeys(keys, arc, lru, detect)
+
+	fmt.Printf("%v,%v,%v,%v\n", "Loop 60 over 50", LRUHitRate(lru), ARCHitRate(arc), ARCHitRate(detect))
+	if lru.Stats().Hits != 0 || arc.Stats().Hits != 0 {
+		t.Errorf("expected LRU and ARC to miss on every request of the loop, got %d and %d hits",
+			lru.Stats().Hits, arc.Stats().Hits)
+	}
+	if detect.Stats().Hits <= arc.Stats().Hits {
+		t.Errorf("expected ARC with scan detection to hit on the loop, got %d hits", detect.Stats().Hits)
+	}
+	if !detect.invariant() {
+		t.Errorf("INVARIANT VIOLATED")
+	}
+}
+
+// a skewed workload polluted by scans, ARC should not do worse than LRU
+func TestARCZipfWithScans(t *testing.T) {
+	arc := NewARC(100)
+	lru := NewLru(100)
+
+	mix := workload.NewMix(28,
+		workload.Weighted{Gen: workload.NewZipf(28, "z", 1000, 1.1), Weight: 4},
+		workload.Weighted{Gen: workload.NewScan("scan", 0), Weight: 1},
+		workload.Weighted{Gen: workload.NewScan("scan", 100000), Weight: 1})
+	replayKeys(workload.Keys(mix, 30000), arc, lru)
+
+	fmt.Printf("%v,%v,%v\n", "Zipf with scans", LRUHitRate(lru), ARCHitRate(arc))
+	if arc.Stats().Hits < lru.Stats().Hits {
+		t.Errorf("expected ARC to match LRU on zipf with scans, got %d vs %d hits",
+			arc.Stats().Hits, lru.Stats().Hits)
+	}
+	if !arc.invariant() {
+		t.Errorf("INVARIANT VIOLATED")
+	}
+}
+
+// when the hot set moves, ARC has to drop the old one and pick up the new one
+func TestARCShiftingHotSet(t *testing.T) {
+	arc := NewARC(40)
+
+	const perPhase = 2000
+	keys := workload.Keys(workload.NewShiftingHotSet(28, "h", 30, perPhase), 4*perPhase)
+	replayKeys(keys[:len(keys)-perPhase], arc)
+
+	before := *arc.Stats()
+	replayKeys(keys[len(keys)-perPhase:], arc)
+	hits := arc.Stats().Hits - before.Hits
+
+	fmt.Printf("%v,%v\n", "Last hot set phase", 100*float64(hits)/perPhase)
+	if hits < perPhase*3/4 {
+		t.Errorf("expected ARC to adapt to the last hot set, only %d of %d requests hit", hits, perPhase)
+	}
+}
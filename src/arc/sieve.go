@@ -0,0 +1,133 @@
+// SIEVE Cache Implementation
+//
+// Dependencies: fifo.go, utility.go
+//
+// Description:
+// SIEVE (Zhang et al., NSDI '24) is a fixed-size cache that keeps all of its
+// entries in a single FIFO queue with a visited bit per entry. A hit only sets
+// the visited bit. On eviction a hand walks from the oldest entry towards the
+// newest, clearing visited bits, and evicts the first entry that was not
+// visited. The hand remembers where it stopped, so entries that survived stay
+// in place (lazy promotion) instead of being moved to the tail.
+
+package arc
+
+import "fmt"
+
+type SIEVE struct {
+	size    int                  // size is the fixed number of key-value pairs the cache stores
+	queue   *fifoQueue           // all entries, oldest first
+	hand    *fifoNode            // next eviction candidate, nil means start at the oldest entry
+	mapNode map[string]*fifoNode // maps key to the node holding the value
+	stats   *Stats               // maintains stats associated with hits/misses
+}
+
+// NewSIEVE creates a SIEVE cache of the given size
+func NewSIEVE(size int) *SIEVE {
+	return &SIEVE{
+		size:    size,
+		queue:   newFifoQueue(),
+		mapNode: make(map[string]*fifoNode),
+		stats:   &Stats{},
+	}
+}
+
+// Get returns the value associated with the given key, if it exists.
+// A hit only marks the entry as visited.
+func (s *SIEVE) Get(key string) ([]byte, bool) {
+	node, ok := s.mapNode[key]
+	if !ok {
+		s.stats.Misses++
+		return nil, false
+	}
+
+	node.freq = 1
+	s.stats.Hits++
+	return node.value, true
+}
+
+// Set puts a key-value pair into cache. Returns true if the binding was
+// added successfully, else false.
+func (s *SIEVE) Set(key string, value []byte) bool {
+	if s.size <= 0 {
+		return false
+	}
+
+	if node, ok := s.mapNode[key]; ok {
+		node.value = value
+		node.freq = 1
+		return true
+	}
+
+	if s.Len() >= s.size {
+		s.evict()
+	}
+
+	node := &fifoNode{key: key, value: value}
+	s.queue.pushBack(node)
+	s.mapNode[key] = node
+	return true
+}
+
+// evict moves the hand forward until it finds an entry that was not
+// visited since the hand last passed it, and evicts that entry
+func (s *SIEVE) evict() {
+	node := s.hand
+	if node == nil {
+		node = s.queue.oldest()
+	}
+
+	for node != nil && node.freq > 0 {
+		node.freq = 0
+		node = s.queue.after(node)
+		if node == nil {
+			node = s.queue.oldest() // wrap around
+		}
+	}
+
+	if node == nil {
+		return
+	}
+	s.hand = s.queue.after(node)
+	s.queue.unlink(node)
+	delete(s.mapNode, node.key)
+}
+
+// Remove removes and returns the value associated with the given key, if it exists.
+// If key not in the cache, returns nil,false
+func (s *SIEVE) Remove(key string) ([]byte, bool) {
+	node, ok := s.mapNode[key]
+	if !ok {
+		return nil, false
+	}
+
+	if s.hand == node {
+		s.hand = s.queue.after(node)
+	}
+	s.queue.unlink(node)
+	delete(s.mapNode, key)
+	return node.value, true
+}
+
+// Len returns the number of entries in the cache
+func (s *SIEVE) Len() int {
+	return s.queue.Len()
+}
+
+// MaxSize returns the number of entries supported by the cache
+func (s *SIEVE) MaxSize() int {
+	return s.size
+}
+
+// Stats returns statistics about how many search hits and misses have occurred.
+func (s *SIEVE) Stats() *Stats {
+	return s.stats
+}
+
+// report hits/misses from Get calls to stdout
+func (s *SIEVE) ReportStats() {
+	fmt.Println("SIEVE Hits/Misses")
+	fmt.Println("Number of Hits:", s.stats.Hits)
+	fmt.Println("Number of Misses:", s.stats.Misses)
+	fmt.Println("Percentage of Hits:", s.stats.HitRate())
+}
@@ -0,0 +1,263 @@
+// Slab Allocator for Off-Heap Values
+//
+// Dependencies: arena.go, arena_mmap.go or arena_heap.go, arclist.go
+//
+// Description:
+// The values of an off-heap ARC are stored by a slab allocator in the style
+// of memcached. Memory is mapped in pages of 1 MiB, and every page belongs to
+// one size class, which cuts it into chunks of the same size. The chunk sizes
+// grow by a factor of 1.25 from 16 bytes up to a whole page, so a value never
+// wastes more than about a fifth of its chunk, and values of very different
+// sizes never fragment each other's memory. A freed chunk goes back to the
+// free list of its class and is reused for the next value of that class.
+// Values larger than a page cannot be stored.
+//
+// A class takes a new page whenever its chunks are used up. With a memory
+// limit, the pages run out eventually, and a class that needs a chunk has to
+// free one of its own: by default the value is not stored, but ARC can be
+// told to evict a key of the same class instead, choosing it the way its
+// REPLACE step would (see EnableSlabClassEviction). A page that cannot be
+// mapped, e.g. because the system is out of memory, is treated the same way.
+
+package arc
+
+import (
+	"runtime"
+	"sort"
+)
+
+const (
+	slabPageSize  = 1 << 20 // bytes per page
+	slabMinChunk  = 16      // chunk size of the smallest class
+	slabGrowth    = 1.25    // factor between the chunk sizes of two classes
+	slabAlignment = 8       // chunk sizes are multiples of this
+)
+
+// slabChunkSizes are the chunk sizes of the classes, smallest first
+var slabChunkSizes = func() []int {
+	var sizes []int
+	for size := slabMinChunk; size <= slabPageSize/2; {
+		sizes = append(sizes, size)
+		next := int(float64(size) * slabGrowth)
+		size = (next + slabAlignment - 1) / slabAlignment * slabAlignment
+	}
+	return append(sizes, slabPageSize)
+}()
+
+// SlabClassStats describes one size class of an off-heap ARC
+type SlabClassStats struct {
+	ChunkSize   int   // bytes per chunk
+	Pages       int   // pages the class has taken
+	UsedChunks  int   // chunks holding a value
+	FreeChunks  int   // chunks that are free, including the ones not cut yet
+	Requested   int64 // bytes of the values in the used chunks
+	Evictions   int   // keys evicted because the class was out of memory
+	OutOfMemory int   // values not stored because the class was out of memory
+}
+
+// slabClass is a size class and the chunks it owns
+type slabClass struct {
+	size   int         // chunk size
+	pages  int         // number of pages of the class
+	carved int         // bytes cut into chunks on the last page of the class
+	last   uint32      // index of the last page of the class
+	free   []valueSlot // freed chunks
+
+	used        int
+	requested   int64
+	evictions   int
+	outOfMemory int
+}
+
+// slabAllocator stores values in pages of memory outside of the Go heap
+type slabAllocator struct {
+	pages     [][]byte // mapped pages
+	pageClass []uint8  // class of every page
+	classes   []slabClass
+	mapPage   func(size int) ([]byte, error) // maps a page, replaced in tests
+
+	maxPages      int  // max number of pages to map, 0 for no limit
+	evictPerClass bool // whether ARC evicts a key of a class that is out of memory
+}
+
+// newSlabAllocator returns an allocator with no memory mapped yet. Its pages
+// are unmapped once it is garbage collected.
+func newSlabAllocator() *slabAllocator {
+	s := &slabAllocator{classes: make([]slabClass, len(slabChunkSizes)), mapPage: mapPage}
+	for i, size := range slabChunkSizes {
+		s.classes[i].size = size
+	}
+	runtime.SetFinalizer(s, (*slabAllocator).unmap)
+	return s
+}
+
+// classFor returns the smallest class whose chunks fit n bytes, or -1 if n
+// is larger than a page
+func classFor(n int) int {
+	class := sort.SearchInts(slabChunkSizes, n)
+	if class == len(slabChunkSizes) {
+		return -1
+	}
+	return class
+}
+
+// alloc copies value into a free chunk of its class and returns its slot.
+// It returns false if the value is too large, or its class has no free chunk
+// and no page can be mapped for it.
+func (s *slabAllocator) alloc(value []byte) (valueSlot, bool) {
+	if len(value) == 0 {
+		return valueSlot{}, true
+	}
+	class := classFor(len(value))
+	if class < 0 {
+		return valueSlot{}, false
+	}
+
+	c := &s.classes[class]
+	var slot valueSlot
+	if len(c.free) > 0 {
+		slot = c.free[len(c.free)-1]
+		c.free = c.free[:len(c.free)-1]
+	} else {
+		if c.pages == 0 || c.carved+c.size > slabPageSize {
+			if s.maxPages > 0 && len(s.pages) >= s.maxPages {
+				return valueSlot{}, false
+			}
+			page, ok := s.newPage(class)
+			if !ok {
+				return valueSlot{}, false
+			}
+			c.last = page
+			c.pages++
+			c.carved = 0
+		}
+		slot = valueSlot{page: c.last, offset: uint32(c.carved)}
+		c.carved += c.size
+	}
+
+	slot.length = uint32(len(value))
+	copy(s.bytes(slot), value)
+	c.used++
+	c.requested += int64(len(value))
+	return slot, true
+}
+
+// newPage maps a page for class and returns its index. It returns false if
+// the page cannot be mapped.
+func (s *slabAllocator) newPage(class int) (uint32, bool) {
+	page, err := s.mapPage(slabPageSize)
+	if err != nil {
+		return 0, false
+	}
+	s.pages = append(s.pages, page)
+	s.pageClass = append(s.pageClass, uint8(class))
+	return uint32(len(s.pages) - 1), true
+}
+
+// classOf returns the class of the chunk of a non-empty slot
+func (s *slabAllocator) classOf(slot valueSlot) int {
+	return int(s.pageClass[slot.page])
+}
+
+// bytes returns the memory of the value in slot, without copying it
+func (s *slabAllocator) bytes(slot valueSlot) []byte {
+	start, end := slot.offset, slot.offset+slot.length
+	return s.pages[slot.page][start:end:end]
+}
+
+// release puts the chunk of slot back on the free list of its class
+func (s *slabAllocator) release(slot valueSlot) {
+	if slot.length == 0 {
+		return
+	}
+	c := &s.classes[s.classOf(slot)]
+	c.free = append(c.free, valueSlot{page: slot.page, offset: slot.offset})
+	c.used--
+	c.requested -= int64(slot.length)
+}
+
+// unmap returns all pages to the operating system. The allocator must not be
+// used afterwards.
+func (s *slabAllocator) unmap() {
+	for _, page := range s.pages {
+		unmapPage(page)
+	}
+	s.pages = nil
+}
+
+// stats returns the stats of every class that has taken a page or refused a
+// value
+func (s *slabAllocator) stats() []SlabClassStats {
+	var stats []SlabClassStats
+	for _, c := range s.classes {
+		if c.pages == 0 && c.outOfMemory == 0 {
+			continue
+		}
+		stats = append(stats, SlabClassStats{
+			ChunkSize:   c.size,
+			Pages:       c.pages,
+			UsedChunks:  c.used,
+			FreeChunks:  c.pages*(slabPageSize/c.size) - c.used,
+			Requested:   c.requested,
+			Evictions:   c.evictions,
+			OutOfMemory: c.outOfMemory,
+		})
+	}
+	return stats
+}
+
+// SetSlabMemoryLimit limits the memory an off-heap ARC maps for its values
+// to limit bytes, rounded down to whole pages of 1 MiB (but at least one). A
+// limit <= 0 removes the limit. Pages that are mapped already stay mapped.
+func (arc *ARC) SetSlabMemoryLimit(limit int64) {
+	if arc.values == nil {
+		return
+	}
+	if limit <= 0 {
+		arc.values.maxPages = 0
+		return
+	}
+	arc.values.maxPages = max(int(limit/slabPageSize), 1)
+}
+
+// EnableSlabClassEviction decides what an off-heap ARC does when a value
+// needs a chunk of a class that has none free and can get no more pages.
+// When enabled, ARC evicts a key whose value is in the same class, otherwise
+// the value is not stored (Set returns false and the key is removed).
+func (arc *ARC) EnableSlabClassEviction(enabled bool) {
+	if arc.values != nil {
+		arc.values.evictPerClass = enabled
+	}
+}
+
+// SlabStats returns the stats of every size class of an off-heap ARC that
+// has memory or was refused some, smallest chunks first, or nil if the ARC
+// is on the heap
+func (arc *ARC) SlabStats() []SlabClassStats {
+	if arc.values == nil {
+		return nil
+	}
+	return arc.values.stats()
+}
+
+// evictFromClass evicts the key ARC's REPLACE would pick if it only looked at
+// keys whose value is in class: the least recently used one of them in T1
+// if T1 is above its target p, in T2 otherwise. Returns false if no key has
+// a value in class.
+func (arc *ARC) evictFromClass(class int) bool {
+	lists := []*arcList{&arc.t2, &arc.t1}
+	if lenT1 := arc.t1.Len(); lenT1 > 0 && (lenT1 > arc.p || arc.t2.Len() == 0) {
+		lists[0], lists[1] = lists[1], lists[0]
+	}
+
+	for _, list := range lists {
+		for e := list.sentinel.next; e != &list.sentinel; e = e.next {
+			if e.slot.length > 0 && arc.values.classOf(e.slot) == class {
+				arc.ghost(e)
+				arc.values.classes[class].evictions++
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+package arc
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// statsOfClass returns the stats of the class that holds values of n bytes
+func statsOfClass(arc *ARC, n int) SlabClassStats {
+	size := slabChunkSizes[classFor(n)]
+	for _, stats := range arc.SlabStats() {
+		if stats.ChunkSize == size {
+			return stats
+		}
+	}
+	return SlabClassStats{ChunkSize: size}
+}
+
+// the chunk sizes grow by about 1.25 up to half a page, the last class holds
+// a whole page, and a value goes to the smallest chunk it fits
+func TestSlabClasses(t *testing.T) {
+	last := len(slabChunkSizes) - 1
+	for i, size := range slabChunkSizes[:last] {
+		if size%slabAlignment != 0 {
+			t.Errorf("chunk size %d is not aligned", size)
+		}
+		if i > 0 && (size <= slabChunkSizes[i-1] || float64(size) > float64(slabChunkSizes[i-1])*slabGrowth+slabAlignment) {
+			t.Errorf("chunk size %d does not follow %d", size, slabChunkSizes[i-1])
+		}
+	}
+	if slabChunkSizes[last] != slabPageSize {
+		t.Errorf("expected the largest chunk to be a page, got %d", slabChunkSizes[last])
+	}
+
+	for _, n := range []int{1, 16, 17, 1000, slabPageSize} {
+		class := classFor(n)
+		if slabChunkSizes[class] < n || (class > 0 && slabChunkSizes[class-1] >= n) {
+			t.Errorf("expected %d bytes to go to the smallest chunk that fits, got %d", n, slabChunkSizes[class])
+		}
+	}
+	if classFor(slabPageSize+1) != -1 {
+		t.Errorf("expected no class for a value larger than a page")
+	}
+}
+
+// values are accounted in their classes, and move when they change size
+func TestSlabStats(t *testing.T) {
+	arc := NewOffHeapARC(100)
+	for i := 0; i < 10; i++ {
+		arc.Set(fmt.Sprint("small", i), make([]byte, 10))
+		arc.Set(fmt.Sprint("large", i), make([]byte, 1000))
+	}
+	arc.Remove("small0")
+	arc.Set("large0", make([]byte, 10))
+
+	small, large := statsOfClass(arc, 10), statsOfClass(arc, 1000)
+	if small.UsedChunks != 10 || small.Requested != 100 || small.Pages != 1 {
+		t.Errorf("expected 10 small values in one page, got %+v", small)
+	}
+	if large.UsedChunks != 9 || large.Requested != 9000 || large.FreeChunks != slabPageSize/large.ChunkSize-9 {
+		t.Errorf("expected 9 large values and the rest of the page free, got %+v", large)
+	}
+	if len(arc.SlabStats()) != 2 {
+		t.Errorf("expected only the classes with memory, got %+v", arc.SlabStats())
+	}
+	if NewARC(1).SlabStats() != nil {
+		t.Errorf("expected no slab stats for an ARC on the heap")
+	}
+
+	// a value larger than a page is not stored, and does not leave the old
+	// one behind
+	if arc.Set("large1", make([]byte, slabPageSize+1)) {
+		t.Errorf("expected a value larger than a page not to be stored")
+	}
+	if _, ok := arc.Get("large1"); ok {
+		t.Errorf("expected the failed Set to remove the key")
+	}
+	if err := arc.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+// without class eviction, a class that is out of memory stores nothing more,
+// while the cached values stay
+func TestSlabOutOfMemory(t *testing.T) {
+	arc := NewOffHeapARC(10_000)
+	arc.SetSlabMemoryLimit(slabPageSize)
+	perPage := slabPageSize / slabChunkSizes[classFor(1000)]
+
+	stored := 0
+	for i := 0; i < 2*perPage; i++ {
+		if arc.Set(fmt.Sprint("k", i), make([]byte, 1000)) {
+			stored++
+		}
+	}
+	if stored != perPage || arc.Len() != perPage {
+		t.Errorf("expected the %d values of one page to be stored, got %d", perPage, stored)
+	}
+	if stats := statsOfClass(arc, 1000); stats.OutOfMemory != perPage || stats.Evictions != 0 {
+		t.Errorf("expected %d values to be refused, got %+v", perPage, stats)
+	}
+
+	// the page is taken, the other classes get nothing either
+	if arc.Set("small", []byte("v")) {
+		t.Errorf("expected no memory for another class")
+	}
+	// but an update within the class reuses its own chunk
+	if !arc.Set("k0", make([]byte, 999)) {
+		t.Errorf("expected an update to reuse its chunk")
+	}
+	if err := arc.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+// a page that cannot be mapped fails the Set like a memory limit does,
+// instead of crashing the process
+func TestSlabMapFailure(t *testing.T) {
+	arc := NewOffHeapARC(10)
+	arc.values.mapPage = func(size int) ([]byte, error) {
+		return nil, errors.New("cannot allocate memory")
+	}
+	if arc.Set("k", make([]byte, 1000)) {
+		t.Errorf("expected the Set to fail without a page")
+	}
+	if stats := statsOfClass(arc, 1000); stats.OutOfMemory != 1 || stats.Pages != 0 {
+		t.Errorf("expected one value to be refused, got %+v", stats)
+	}
+	if _, ok := arc.Get("k"); ok || arc.Len() != 0 {
+		t.Errorf("expected k not to be cached, got %d keys", arc.Len())
+	}
+
+	arc.values.mapPage = mapPage
+	if !arc.Set("k", make([]byte, 1000)) {
+		t.Errorf("expected the Set to succeed once pages can be mapped")
+	}
+	if err := arc.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+// a value larger than a page is never stored, even without a memory limit
+func TestSlabValueTooLarge(t *testing.T) {
+	arc := NewOffHeapARC(10)
+	arc.EnableSlabClassEviction(true)
+	if !arc.Set("page", make([]byte, slabPageSize)) {
+		t.Errorf("expected a value of a whole page to be stored")
+	}
+	if arc.Set("large", make([]byte, slabPageSize+1)) {
+		t.Errorf("expected a value larger than a page to be refused")
+	}
+	if _, ok := arc.Get("large"); ok || arc.Len() != 1 {
+		t.Errorf("expected only the value of a page to be cached, got %d keys", arc.Len())
+	}
+	if err := arc.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+// with class eviction, a class that is out of memory evicts its own keys and
+// leaves the other classes alone
+func TestSlabClassEviction(t *testing.T) {
+	arc := NewOffHeapARC(10_000)
+	arc.SetSlabMemoryLimit(2 * slabPageSize)
+	arc.EnableSlabClassEviction(true)
+	for i := 0; i < 100; i++ {
+		arc.Set(fmt.Sprint("small", i), make([]byte, 10))
+	}
+	perPage := slabPageSize / slabChunkSizes[classFor(1000)]
+	for i := 0; i < 2*perPage; i++ {
+		if !arc.Set(fmt.Sprint("large", i), make([]byte, 1000)) {
+			t.Fatalf("expected large%d to evict another large value", i)
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		if !inList(arc, listT1, fmt.Sprint("small", i)) {
+			t.Errorf("expected small%d to stay cached", i)
+		}
+	}
+	// REPLACE takes from T1 first, so the oldest large values are the ghosts
+	if !inList(arc, listB1, "large0") || !inList(arc, listT1, fmt.Sprint("large", 2*perPage-1)) {
+		t.Errorf("expected the oldest large values to be evicted")
+	}
+	stats := statsOfClass(arc, 1000)
+	if stats.Evictions != perPage || stats.OutOfMemory != 0 || stats.UsedChunks != perPage {
+		t.Errorf("expected %d evictions within the class, got %+v", perPage, stats)
+	}
+	if err := arc.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,54 @@
+// Trace Replay
+//
+// Dependencies: utility.go
+//
+// Description:
+// Replays a request trace against any number of caches at once so that the
+// replacement policies in this package (LRU, ARC, S3-FIFO, SIEVE, ...) can be
+// compared on exactly the same sequence of requests. A trace is a text file
+// with one request per line and space separated columns, where the second
+// column is the requested key (the format of the Wikipedia 2019 CDN trace,
+// "timestamp key size"). An optional fourth column marks the request as a
+// read ("r") or a write ("w"), as written by the workload package.
+
+package arc
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// ReplayTrace reads requests from r and replays lines [start, end) against
+// every cache. A read is a Get, and a miss is followed by a Set of the key,
+// the way a look-aside cache is filled. A write is a plain Set. An end <= 0
+// replays the trace up to EOF.
+func ReplayTrace(r io.Reader, start, end int, caches ...Cache) error {
+	scanner := bufio.NewScanner(r)
+
+	for line := 0; scanner.Scan(); line++ {
+		if end > 0 && line >= end {
+			break
+		}
+		if line < start {
+			continue
+		}
+
+		columns := strings.Fields(scanner.Text())
+		if len(columns) < 2 {
+			continue // blank or malformed line
+		}
+		key := columns[1]
+		write := len(columns) > 3 && columns[3] == "w"
+
+		for _, cache := range caches {
+			if write {
+				cache.Set(key, []byte{})
+			} else if _, hit := cache.Get(key); !hit {
+				cache.Set(key, []byte{})
+			}
+		}
+	}
+
+	return scanner.Err()
+}
@@ -2,10 +2,48 @@ package arc
 
 // necessary utility functions used in ARC
 
+// Cache is the common surface of every replacement policy in this package,
+// so policies can be swapped in the trace replay and in comparisons
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte) bool
+	Remove(key string) ([]byte, bool)
+	Len() int
+	MaxSize() int
+	Stats() *Stats
+	ReportStats()
+}
+
+// EvictCallback is called with every key-value pair that a cache drops to
+// make room, or because it was purged. It is not called for Remove, which
+// hands the value back to the caller already. The callback must not modify
+// the cache.
+type EvictCallback func(key string, value []byte)
+
 // use stats to keep track of hits and misses (same from Assignment 3)
 type Stats struct {
 	Hits   int
 	Misses int
+
+	NegativeHits int // lookups answered by a tombstone, see ARC.Lookup
+	DiskHits     int // Get calls answered by the second level, not in Hits, see l2.go
+	DiskErrors   int // failed writes, deletes and reads of the second level
+
+	// bytes of the values ARC holds that went through the codec, and the
+	// bytes it stores for them, see codec.go
+	RawBytes    int64
+	StoredBytes int64
+}
+
+// HitRate returns the percentage of Get calls that were hits, in memory or on
+// the second level
+func (stats *Stats) HitRate() float64 {
+	hits := stats.Hits + stats.DiskHits
+	total := hits + stats.Misses
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(hits) / float64(total)
 }
 
 func (stats *Stats) Equals(other *Stats) bool {
@@ -15,7 +53,19 @@ func (stats *Stats) Equals(other *Stats) bool {
 	if stats == nil || other == nil {
 		return false
 	}
-	return stats.Hits == other.Hits && stats.Misses == other.Misses
+	return stats.Hits == other.Hits && stats.Misses == other.Misses &&
+		stats.NegativeHits == other.NegativeHits && stats.DiskHits == other.DiskHits &&
+		stats.DiskErrors == other.DiskErrors &&
+		stats.RawBytes == other.RawBytes && stats.StoredBytes == other.StoredBytes
+}
+
+// CompressionRatio returns how many times smaller the values that were large
+// enough to be compressed were stored, 1 if none were
+func (stats *Stats) CompressionRatio() float64 {
+	if stats.StoredBytes == 0 {
+		return 1
+	}
+	return float64(stats.RawBytes) / float64(stats.StoredBytes)
 }
 
 // gets max of two integers
@@ -35,12 +85,3 @@ func min(x, y int) int {
 		return x
 	}
 }
-
-// remove a random key from a set
-func removeRandKey(b map[string]bool) {
-	// map iteration is random, so first key in iterable is deleted
-	for k, _ := range b {
-		delete(b, k)
-		return
-	}
-}
@@ -0,0 +1,92 @@
+// Versioned Entries for ARC
+//
+// Dependencies: arc.go, arclist.go
+//
+// Description:
+// Every write to ARC gives the entry a new version, taken from a counter that
+// only ever increases (it survives Remove, Purge and Reset too), so a version
+// is never handed out twice. A worker that reads a value with
+// GetWithVersion can write back its update with CompareAndSet, which fails if
+// anyone else wrote the key in the meantime instead of losing their update.
+// Version 0 stands for "not in the cache".
+//
+// SetIfAbsent and Replace are conditional writes. They only touch ARC's
+// adaptation when they actually store something: a Replace of a key that is
+// only a ghost fails without counting as a ghost hit, and so does a
+// SetIfAbsent of a key that is already cached.
+
+package arc
+
+import "errors"
+
+var (
+	// ErrNotFound is returned by CompareAndSet if the key is not in the cache
+	ErrNotFound = errors.New("arc: key not found")
+
+	// ErrVersionMismatch is returned by CompareAndSet if the key was written
+	// since the expected version was read, or exists when it was expected
+	// to be absent
+	ErrVersionMismatch = errors.New("arc: version mismatch")
+
+	// ErrNotStored is returned by CompareAndSet if the cache could not store
+	// the value at all, because its size is zero
+	ErrNotStored = errors.New("arc: value not stored")
+)
+
+// nextVersion returns the version for a new write
+func (arc *ARC) nextVersion() uint64 {
+	arc.version++
+	return arc.version
+}
+
+// GetWithVersion returns the value associated with the given key and its
+// version, if it exists. It counts as a Get in every other way.
+func (arc *ARC) GetWithVersion(key string) ([]byte, uint64, bool) {
+	value, ok := arc.Get(key)
+	if !ok {
+		return nil, 0, false
+	}
+	return value, arc.index[key].version, true
+}
+
+// CompareAndSet sets key to value only if its current version is expected,
+// and returns the new version. An expected version of 0 means the key must
+// not be in the cache. On a conflict nothing changes, and CompareAndSet
+// returns ErrNotFound, or ErrVersionMismatch along with the current version.
+func (arc *ARC) CompareAndSet(key string, expected uint64, value []byte) (uint64, error) {
+	var current uint64
+	if e, ok := arc.index[key]; ok {
+		current = e.version // 0 for ghosts
+	}
+
+	if current != expected {
+		if current == 0 {
+			return 0, ErrNotFound
+		}
+		return current, ErrVersionMismatch
+	}
+	if !arc.Set(key, value) {
+		return 0, ErrNotStored
+	}
+	return arc.index[key].version, nil
+}
+
+// SetIfAbsent puts a key-value pair into cache only if key is not cached yet.
+// Returns true if the binding was added. A key that is found in a ghost list
+// is inserted, so it adapts p like any Set.
+func (arc *ARC) SetIfAbsent(key string, value []byte) bool {
+	if e, ok := arc.index[key]; ok && e.resident() {
+		return false
+	}
+	return arc.Set(key, value)
+}
+
+// Replace sets the value of key only if it is cached already, returning true
+// if it was. Keys that are only in a ghost list are left alone, and p does
+// not move.
+func (arc *ARC) Replace(key string, value []byte) bool {
+	if e, ok := arc.index[key]; !ok || !e.resident() {
+		return false
+	}
+	return arc.Set(key, value)
+}
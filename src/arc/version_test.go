@@ -0,0 +1,133 @@
+package arc
+
+import (
+	"fmt"
+	"testing"
+)
+
+// every write gets a new, higher version, reads leave it alone
+func TestARCVersions(t *testing.T) {
+	arc := NewARC(4)
+	var last uint64
+
+	for i := 0; i < 3; i++ {
+		arc.Set("a", []byte(fmt.Sprint(i)))
+		value, version, ok := arc.GetWithVersion("a")
+		if !ok || string(value) != fmt.Sprint(i) || version <= last {
+			t.Errorf("GetWithVersion(a) = %q, %d, %v after write %d, expected a version above %d",
+				value, version, ok, i, last)
+		}
+		if _, again, _ := arc.GetWithVersion("a"); again != version {
+			t.Errorf("expected a read to keep version %d, got %d", version, again)
+		}
+		last = version
+	}
+	if _, version, ok := arc.GetWithVersion("missing"); ok || version != 0 {
+		t.Errorf("expected version 0 for a missing key, got %d, %v", version, ok)
+	}
+
+	// a key that is removed and set again never gets an old version back
+	arc.Remove("a")
+	arc.Reset(true)
+	arc.Set("a", nil)
+	if _, version, _ := arc.GetWithVersion("a"); version <= last {
+		t.Errorf("expected a version above %d after Reset, got %d", last, version)
+	}
+}
+
+// two workers read the same value, only the first write back goes through
+func TestARCCompareAndSet(t *testing.T) {
+	arc := NewARC(4)
+	arc.Set("counter", []byte("0"))
+
+	_, first, _ := arc.GetWithVersion("counter")
+	_, second, _ := arc.GetWithVersion("counter")
+
+	updated, err := arc.CompareAndSet("counter", first, []byte("1"))
+	if err != nil || updated <= first {
+		t.Fatalf("expected the first CompareAndSet to succeed, got %d, %v", updated, err)
+	}
+	current, err := arc.CompareAndSet("counter", second, []byte("1"))
+	if err != ErrVersionMismatch || current != updated {
+		t.Errorf("expected a stale CompareAndSet to fail with version %d, got %d, %v", updated, current, err)
+	}
+	if value, version, _ := arc.GetWithVersion("counter"); string(value) != "1" || version != updated {
+		t.Errorf("expected the failed CompareAndSet to change nothing, got %q version %d", value, version)
+	}
+
+	// the second worker retries with the new version
+	if _, err := arc.CompareAndSet("counter", updated, []byte("2")); err != nil {
+		t.Errorf("expected the retry to succeed, got %v", err)
+	}
+}
+
+// version 0 means absent, ghosts count as absent
+func TestARCCompareAndSetAbsent(t *testing.T) {
+	arc := NewARC(2)
+
+	if _, err := arc.CompareAndSet("a", 1, []byte("a")); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound for a missing key, got %v", err)
+	}
+	if _, err := arc.CompareAndSet("a", 0, []byte("a")); err != nil {
+		t.Errorf("expected an insert with version 0 to succeed, got %v", err)
+	}
+	if _, err := arc.CompareAndSet("a", 0, []byte("b")); err != ErrVersionMismatch {
+		t.Errorf("expected an insert of a cached key to fail, got %v", err)
+	}
+
+	arc.Set("a", nil) // a goes to T2
+	arc.Set("b", nil)
+	arc.Set("c", nil) // evicts a into B2
+	if !inList(arc, listB2, "a") {
+		t.Fatalf("expected a to be a ghost in B2")
+	}
+	if _, err := arc.CompareAndSet("a", 0, []byte("a")); err != nil || !inList(arc, listT2, "a") {
+		t.Errorf("expected an insert of a ghost to bring it back to T2, got %v", err)
+	}
+
+	if _, err := NewARC(0).CompareAndSet("a", 0, nil); err != ErrNotStored {
+		t.Errorf("expected a zero-size ARC to fail with ErrNotStored, got %v", err)
+	}
+	if err := arc.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+// conditional writes only adapt p if they actually store the value
+func TestARCConditionalWrites(t *testing.T) {
+	arc := NewARC(4)
+	warmT2(arc, []string{"hot"})
+	replayKeys(seqKeys("k", 0, 8), arc)
+	if arc.b1.Len() == 0 {
+		t.Fatalf("expected a ghost in B1")
+	}
+	ghost := arc.b1.keys()[0]
+	cached := arc.t1.keys()[0]
+	p := arc.p
+
+	if arc.Replace(ghost, []byte("v")) || !inList(arc, listB1, ghost) || arc.p != p {
+		t.Errorf("expected Replace of a ghost to fail and leave p at %d, got %d", p, arc.p)
+	}
+	if arc.Replace("missing", nil) || arc.Len() != 4 {
+		t.Errorf("expected Replace of a missing key to fail")
+	}
+	if arc.SetIfAbsent(cached, []byte("v")) {
+		t.Errorf("expected SetIfAbsent of a cached key to fail")
+	}
+	if !inList(arc, listT1, cached) {
+		t.Errorf("expected the failed SetIfAbsent to leave %s in T1", cached)
+	}
+
+	if !arc.Replace(cached, []byte("v")) {
+		t.Errorf("expected Replace of a cached key to succeed")
+	}
+	if value, _ := arc.Get(cached); string(value) != "v" {
+		t.Errorf("expected Replace to store the value, got %q", value)
+	}
+	if !arc.SetIfAbsent(ghost, []byte("v")) || !inList(arc, listT2, ghost) || arc.p != p+1 {
+		t.Errorf("expected SetIfAbsent of a ghost to insert it and move p to %d, got %d", p+1, arc.p)
+	}
+	if err := arc.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+}
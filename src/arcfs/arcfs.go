@@ -0,0 +1,309 @@
+// Caching File System
+//
+// Description:
+// FS wraps an fs.FS, a slow one such as a network file system, and caches
+// the Stat results and the contents of its regular files in an ARC. Opening
+// a cached file reads it from memory, and directories are passed through.
+//
+// A cached file is only served as long as it has not changed. Before it is
+// served, its Stat result is checked against the file system: a file whose
+// size or modification time differs is dropped from the cache and read again.
+// The check can be limited to once every interval (see SetRevalidateInterval),
+// in which case a file that changed may be served as it was until the
+// interval is over.
+//
+// ARC counts keys, not bytes, so a few large files could fill a cache meant
+// for many small ones. With a byte budget (see SetByteBudget) every key gets
+// an equal share of it, and files larger than a share are not cached, which
+// keeps the cached contents within the budget.
+//
+// Cache layout: the Stat result of name is stored under "stat:"+name, and its
+// contents under "data:"+name, preceded by the size and modification time of
+// the file they were read from.
+
+package arcfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"sync/atomic"
+	"time"
+
+	"cos316.princeton.edu/final_proj/arc"
+)
+
+// Stats counts what happened to the requests of an FS
+type Stats struct {
+	Hits          int64 // files served from the cache
+	Misses        int64 // files read from the file system
+	Invalidations int64 // cached files dropped because they changed
+	TooLarge      int64 // files not cached because they exceed the share of a key
+}
+
+// FS is a file system that caches the files of another one. It is safe for
+// concurrent use.
+type FS struct {
+	fsys  fs.FS
+	cache arc.Cache
+	now   func() time.Time // time.Now outside of tests
+
+	budget     int64         // bytes of file contents to cache, 0 for no limit
+	revalidate time.Duration // how long a Stat result is trusted
+	stats      Stats         // updated atomically
+}
+
+// New returns a file system that serves the files of fsys through cache,
+// which must be safe for concurrent use (an arc.ConcurrentARC for example)
+func New(fsys fs.FS, cache arc.Cache) *FS {
+	return &FS{fsys: fsys, cache: cache, now: time.Now}
+}
+
+// SetByteBudget limits the file contents the cache holds to about budget
+// bytes, by caching only files of at most budget/MaxSize bytes. A budget <= 0
+// removes the limit. Files cached already stay cached. It must be called
+// before the FS is used.
+func (f *FS) SetByteBudget(budget int64) {
+	f.budget = budget
+}
+
+// SetRevalidateInterval makes a Stat result cached for less than interval
+// be trusted without checking the file system. 0, the default, checks on
+// every access. It must be called before the FS is used.
+func (f *FS) SetRevalidateInterval(interval time.Duration) {
+	f.revalidate = interval
+}
+
+// Stats returns a snapshot of the counters
+func (f *FS) Stats() Stats {
+	return Stats{
+		Hits:          atomic.LoadInt64(&f.stats.Hits),
+		Misses:        atomic.LoadInt64(&f.stats.Misses),
+		Invalidations: atomic.LoadInt64(&f.stats.Invalidations),
+		TooLarge:      atomic.LoadInt64(&f.stats.TooLarge),
+	}
+}
+
+// Open opens the named file, from the cache if it is a regular file that is
+// cached and has not changed
+func (f *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	info, err := f.stat(name)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	if !info.Mode().IsRegular() {
+		return f.fsys.Open(name)
+	}
+
+	data, info, err := f.contents(name, info)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	if data == nil {
+		return f.fsys.Open(name) // not cached
+	}
+	return &file{Reader: bytes.NewReader(data), info: info}, nil
+}
+
+// Stat returns the FileInfo of the named file, from the cache if it was
+// checked against the file system within the revalidate interval
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	info, err := f.stat(name)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	return info, nil
+}
+
+// ReadFile returns the contents of the named file, from the cache if it is
+// cached and has not changed
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+	info, err := f.stat(name)
+	if err != nil {
+		return nil, pathError("read", name, err)
+	}
+	if !info.Mode().IsRegular() {
+		return fs.ReadFile(f.fsys, name)
+	}
+
+	data, _, err := f.contents(name, info)
+	if err != nil {
+		return nil, pathError("read", name, err)
+	}
+	if data == nil {
+		return fs.ReadFile(f.fsys, name)
+	}
+	return append([]byte(nil), data...), nil // the caller may modify it
+}
+
+// stat returns the FileInfo of name, from the cache if it may be trusted.
+// The contents of a file whose size or modification time changed are dropped.
+func (f *FS) stat(name string) (fs.FileInfo, error) {
+	now := f.now()
+	value, cached := f.cache.Get(statKey(name))
+	var old *fileInfo
+	if cached {
+		var checked time.Time
+		old, checked = decodeStat(value)
+		if old != nil && now.Sub(checked) < f.revalidate {
+			return old, nil
+		}
+	}
+
+	info, err := fs.Stat(f.fsys, name)
+	if err != nil {
+		f.cache.Remove(statKey(name))
+		f.cache.Remove(dataKey(name))
+		return nil, err
+	}
+	if old != nil && !sameFile(old, info) {
+		if _, ok := f.cache.Remove(dataKey(name)); ok {
+			atomic.AddInt64(&f.stats.Invalidations, 1)
+		}
+	}
+	f.cache.Set(statKey(name), encodeStat(info, now))
+	return info, nil
+}
+
+// contents returns the contents of the regular file name whose FileInfo is
+// info, and the FileInfo they were read with. It returns nil contents if the
+// file is too large to be cached.
+func (f *FS) contents(name string, info fs.FileInfo) ([]byte, fs.FileInfo, error) {
+	if value, ok := f.cache.Get(dataKey(name)); ok {
+		if size, modTime, data := decodeData(value); size == info.Size() && modTime.Equal(info.ModTime()) {
+			atomic.AddInt64(&f.stats.Hits, 1)
+			return data, info, nil
+		}
+		// the Stat result was trusted but the file was cached in another
+		// version, so it is read again
+		f.cache.Remove(dataKey(name))
+		atomic.AddInt64(&f.stats.Invalidations, 1)
+	}
+	if f.budget > 0 && info.Size() > f.budget/int64(f.cache.MaxSize()) {
+		atomic.AddInt64(&f.stats.TooLarge, 1)
+		return nil, info, nil
+	}
+
+	atomic.AddInt64(&f.stats.Misses, 1)
+	file, err := f.fsys.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	// the file may have changed since info, its own Stat describes what is read
+	if info, err = file.Stat(); err != nil {
+		return nil, nil, err
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	if int64(len(data)) == info.Size() {
+		f.cache.Set(dataKey(name), encodeData(info, data))
+	}
+	return data, info, nil
+}
+
+// sameFile reports whether two FileInfos describe the same version of a file
+func sameFile(a, b fs.FileInfo) bool {
+	return a.Size() == b.Size() && a.ModTime().Equal(b.ModTime()) && a.Mode() == b.Mode()
+}
+
+// pathError returns err as the error of op on name. Errors of the underlying
+// file system are returned with their own Err.
+func pathError(op, name string, err error) error {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		err = pe.Err
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// file is a file served from the cache
+type file struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+func (f *file) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *file) Close() error               { return nil }
+
+// fileInfo is a FileInfo decoded from the cache
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+func statKey(name string) string { return "stat:" + name }
+func dataKey(name string) string { return "data:" + name }
+
+// encodeStat encodes info, checked against the file system at checked:
+// size (8), mode (4), checked (8), modification time length (1), modification
+// time, name
+func encodeStat(info fs.FileInfo, checked time.Time) []byte {
+	modTime, _ := info.ModTime().MarshalBinary()
+	b := make([]byte, 21, 21+len(modTime)+len(info.Name()))
+	binary.BigEndian.PutUint64(b, uint64(info.Size()))
+	binary.BigEndian.PutUint32(b[8:], uint32(info.Mode()))
+	binary.BigEndian.PutUint64(b[12:], uint64(checked.UnixNano()))
+	b[20] = byte(len(modTime))
+	b = append(b, modTime...)
+	return append(b, info.Name()...)
+}
+
+// decodeStat decodes a value of encodeStat, or returns nil if it is malformed
+func decodeStat(b []byte) (*fileInfo, time.Time) {
+	if len(b) < 21 || len(b) < 21+int(b[20]) {
+		return nil, time.Time{}
+	}
+	fi := &fileInfo{
+		size: int64(binary.BigEndian.Uint64(b)),
+		mode: fs.FileMode(binary.BigEndian.Uint32(b[8:])),
+		name: string(b[21+int(b[20]):]),
+	}
+	if err := fi.modTime.UnmarshalBinary(b[21 : 21+int(b[20])]); err != nil {
+		return nil, time.Time{}
+	}
+	return fi, time.Unix(0, int64(binary.BigEndian.Uint64(b[12:])))
+}
+
+// encodeData encodes the contents of a file with its size and modification
+// time: size (8), modification time length (1), modification time, data
+func encodeData(info fs.FileInfo, data []byte) []byte {
+	modTime, _ := info.ModTime().MarshalBinary()
+	b := make([]byte, 9, 9+len(modTime)+len(data))
+	binary.BigEndian.PutUint64(b, uint64(info.Size()))
+	b[8] = byte(len(modTime))
+	b = append(b, modTime...)
+	return append(b, data...)
+}
+
+// decodeData decodes a value of encodeData. A malformed value decodes to a
+// size of -1, which no file has.
+func decodeData(b []byte) (int64, time.Time, []byte) {
+	var modTime time.Time
+	if len(b) < 9 || len(b) < 9+int(b[8]) || modTime.UnmarshalBinary(b[9:9+int(b[8])]) != nil {
+		return -1, time.Time{}, nil
+	}
+	return int64(binary.BigEndian.Uint64(b)), modTime, b[9+int(b[8]):]
+}
@@ -0,0 +1,185 @@
+package arcfs
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"cos316.princeton.edu/final_proj/arc"
+)
+
+// countingFS is a MapFS that counts the files it opens or reads
+type countingFS struct {
+	fstest.MapFS
+	mu    sync.Mutex
+	opens map[string]int
+}
+
+func (c *countingFS) Open(name string) (fs.File, error) {
+	c.mu.Lock()
+	c.opens[name]++
+	c.mu.Unlock()
+	return c.MapFS.Open(name)
+}
+
+func (c *countingFS) ReadFile(name string) ([]byte, error) {
+	c.mu.Lock()
+	c.opens[name]++
+	c.mu.Unlock()
+	return c.MapFS.ReadFile(name)
+}
+
+func (c *countingFS) count(name string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.opens[name]
+}
+
+// newTestFS returns an FS over a few files, with a cache of size entries
+func newTestFS(size int) (*FS, *countingFS) {
+	modTime := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	backing := &countingFS{
+		MapFS: fstest.MapFS{
+			"index.html":     {Data: []byte("<html></html>"), ModTime: modTime},
+			"css/site.css":   {Data: []byte("body {}"), ModTime: modTime},
+			"js/app.js":      {Data: []byte(strings.Repeat("x", 1000)), ModTime: modTime},
+			"img/empty.png":  {ModTime: modTime},
+			"img/nested/dir": {Mode: fs.ModeDir, ModTime: modTime},
+		},
+		opens: make(map[string]int),
+	}
+	return New(backing, arc.NewConcurrentARC(size)), backing
+}
+
+// the FS behaves like the one it wraps, cold and with everything cached
+func TestFS(t *testing.T) {
+	fsys, _ := newTestFS(100)
+	for pass := 0; pass < 2; pass++ {
+		if err := fstest.TestFS(fsys, "index.html", "css/site.css", "js/app.js", "img/empty.png"); err != nil {
+			t.Fatalf("pass %d: %v", pass, err)
+		}
+	}
+	if fsys.Stats().Hits == 0 {
+		t.Errorf("expected the second pass to be served from the cache")
+	}
+
+	// and a directory on disk
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "sub"), 0o755)
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0o644)
+	os.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("b"), 0o644)
+	disk := New(os.DirFS(dir), arc.NewConcurrentARC(100))
+	for pass := 0; pass < 2; pass++ {
+		if err := fstest.TestFS(disk, "a.txt", "sub/b.txt"); err != nil {
+			t.Fatalf("pass %d on disk: %v", pass, err)
+		}
+	}
+}
+
+// a file is read from the file system once, and served from memory after
+func TestFSCaching(t *testing.T) {
+	fsys, backing := newTestFS(100)
+	for i := 0; i < 3; i++ {
+		if data, err := fs.ReadFile(fsys, "index.html"); err != nil || string(data) != "<html></html>" {
+			t.Fatalf("ReadFile = %q, %v", data, err)
+		}
+		if data, _ := fsys.ReadFile("index.html"); string(data) != "<html></html>" {
+			t.Fatalf("ReadFile = %q", data)
+		}
+	}
+	if backing.count("index.html") != 1 {
+		t.Errorf("expected one open of the file, got %d", backing.count("index.html"))
+	}
+	if stats := fsys.Stats(); stats.Hits != 5 || stats.Misses != 1 {
+		t.Errorf("expected 5 hits and 1 miss, got %+v", stats)
+	}
+
+	// changing the contents returned does not change the cache
+	data, _ := fsys.ReadFile("index.html")
+	data[0] = 'X'
+	if data, _ := fsys.ReadFile("index.html"); data[0] != '<' {
+		t.Errorf("expected ReadFile to return a copy")
+	}
+
+	if _, err := fsys.Open("missing"); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+	if _, err := fsys.Open("../index.html"); err == nil {
+		t.Errorf("expected an error for an invalid path")
+	}
+}
+
+// a file whose size or modification time changes is read again
+func TestFSInvalidation(t *testing.T) {
+	fsys, backing := newTestFS(100)
+	fsys.ReadFile("css/site.css")
+
+	backing.MapFS["css/site.css"].Data = []byte("body { color: red }")
+	if data, _ := fsys.ReadFile("css/site.css"); string(data) != "body { color: red }" {
+		t.Errorf("expected a file of another size to be read again, got %q", data)
+	}
+
+	// same size, newer modification time
+	file := backing.MapFS["css/site.css"]
+	file.Data = []byte("body { color: 0x0 }")
+	file.ModTime = file.ModTime.Add(time.Second)
+	if data, _ := fsys.ReadFile("css/site.css"); string(data) != "body { color: 0x0 }" {
+		t.Errorf("expected a file with a newer modification time to be read again, got %q", data)
+	}
+	if stats := fsys.Stats(); stats.Invalidations != 2 || backing.count("css/site.css") != 3 {
+		t.Errorf("expected 2 invalidations and 3 opens, got %+v and %d opens", stats, backing.count("css/site.css"))
+	}
+
+	// a removed file is gone from the cache too
+	delete(backing.MapFS, "css/site.css")
+	if _, err := fsys.Stat("css/site.css"); err == nil {
+		t.Errorf("expected a removed file to be gone")
+	}
+}
+
+// within the revalidate interval, Stat results are trusted
+func TestFSRevalidateInterval(t *testing.T) {
+	fsys, backing := newTestFS(100)
+	now := time.Unix(316, 0)
+	fsys.now = func() time.Time { return now }
+	fsys.SetRevalidateInterval(time.Minute)
+	fsys.ReadFile("index.html")
+
+	backing.MapFS["index.html"].Data = []byte("<html>new</html>")
+	if data, _ := fsys.ReadFile("index.html"); string(data) != "<html></html>" {
+		t.Errorf("expected the cached file within the interval, got %q", data)
+	}
+	now = now.Add(time.Minute)
+	if data, _ := fsys.ReadFile("index.html"); string(data) != "<html>new</html>" {
+		t.Errorf("expected the new file after the interval, got %q", data)
+	}
+}
+
+// with a byte budget, files larger than the share of a key are not cached
+func TestFSByteBudget(t *testing.T) {
+	fsys, backing := newTestFS(10)
+	fsys.SetByteBudget(5000) // 500 bytes per key
+	for i := 0; i < 2; i++ {
+		if data, _ := fsys.ReadFile("js/app.js"); len(data) != 1000 {
+			t.Fatalf("expected the large file to be read, got %d bytes", len(data))
+		}
+		f, _ := fsys.Open("js/app.js")
+		var buf bytes.Buffer
+		buf.ReadFrom(f)
+		f.Close()
+		fsys.ReadFile("index.html")
+	}
+	if backing.count("js/app.js") != 4 || backing.count("index.html") != 1 {
+		t.Errorf("expected only the small file to be cached, got %d and %d reads",
+			backing.count("js/app.js"), backing.count("index.html"))
+	}
+	if fsys.Stats().TooLarge != 4 {
+		t.Errorf("expected 4 reads of a file too large, got %+v", fsys.Stats())
+	}
+}
@@ -0,0 +1,191 @@
+// Invalidation Bus
+//
+// Description:
+// A Bus carries the writes of one cache process to the other processes that
+// cache the same data, see Replicator. Every member of a bus receives the
+// events that the other members publish. Delivery is best effort: an event
+// may be lost, duplicated or arrive out of order. The versions in the events
+// make duplicates and reordering harmless (see arc/remote.go), but not
+// losses: a member that misses an event keeps serving its old copy of the
+// key until the key is written again, removed or evicted there.
+//
+// MemoryNetwork connects buses within one process, for tests. UDPBus sends
+// events as UDP datagrams, one event per datagram:
+//
+//   op (1 byte, 'S' or 'R'), version (8), origin length (1), key length (2), origin, key, value
+
+package cluster
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+// EventOp is the kind of write an event carries
+type EventOp byte
+
+const (
+	EventSet    EventOp = 'S'
+	EventRemove EventOp = 'R'
+)
+
+// maxEventSize is the largest event that fits in a UDP datagram over IPv4
+const maxEventSize = 65507
+
+var (
+	// ErrEventTooLarge is returned by Publish for an event that does not fit
+	// in a datagram
+	ErrEventTooLarge = errors.New("cluster: event too large")
+
+	// ErrBusClosed is returned by Publish after the bus is closed
+	ErrBusClosed = errors.New("cluster: bus closed")
+
+	errBadEvent = errors.New("cluster: malformed event")
+)
+
+// Event is a write to a cache, as published on a bus
+type Event struct {
+	Op      EventOp
+	Key     string
+	Value   []byte // new value of an EventSet
+	Version uint64 // version of the write in the cache that made it
+	Origin  string // name of the member that published the event
+}
+
+// Bus broadcasts events to the other members of a group of caches
+type Bus interface {
+	// Publish sends event to every other member
+	Publish(event Event) error
+
+	// Subscribe sets the function that is called with every event that
+	// another member publishes. It may be called from several goroutines at
+	// once, and must not keep the value of the event.
+	Subscribe(handler func(Event))
+
+	// Close leaves the bus
+	Close() error
+}
+
+// eventSize returns the size of event as a datagram, or ErrEventTooLarge
+func eventSize(event Event) (int, error) {
+	size := 12 + len(event.Origin) + len(event.Key) + len(event.Value)
+	if len(event.Origin) > 0xff || len(event.Key) > 0xffff || size > maxEventSize {
+		return 0, ErrEventTooLarge
+	}
+	return size, nil
+}
+
+// marshalEvent encodes event into a datagram
+func marshalEvent(event Event) ([]byte, error) {
+	size, err := eventSize(event)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 12, size)
+	b[0] = byte(event.Op)
+	binary.BigEndian.PutUint64(b[1:], event.Version)
+	b[9] = byte(len(event.Origin))
+	binary.BigEndian.PutUint16(b[10:], uint16(len(event.Key)))
+	b = append(b, event.Origin...)
+	b = append(b, event.Key...)
+	return append(b, event.Value...), nil
+}
+
+// unmarshalEvent decodes a datagram. The value of the event shares memory
+// with b.
+func unmarshalEvent(b []byte) (Event, error) {
+	if len(b) < 12 {
+		return Event{}, errBadEvent
+	}
+	event := Event{Op: EventOp(b[0]), Version: binary.BigEndian.Uint64(b[1:])}
+	if event.Op != EventSet && event.Op != EventRemove {
+		return Event{}, errBadEvent
+	}
+
+	originLen, keyLen := int(b[9]), int(binary.BigEndian.Uint16(b[10:]))
+	b = b[12:]
+	if len(b) < originLen+keyLen {
+		return Event{}, errBadEvent
+	}
+	event.Origin = string(b[:originLen])
+	event.Key = string(b[originLen : originLen+keyLen])
+	if value := b[originLen+keyLen:]; len(value) > 0 {
+		event.Value = value
+	}
+	return event, nil
+}
+
+// MemoryNetwork connects buses in the same process. Events are delivered
+// right away, in the goroutine that publishes them.
+type MemoryNetwork struct {
+	mu      sync.Mutex
+	members []*memoryBus
+}
+
+// NewMemoryNetwork creates a network without members
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{}
+}
+
+// Join returns a new member of the network
+func (n *MemoryNetwork) Join() Bus {
+	b := &memoryBus{network: n}
+	n.mu.Lock()
+	n.members = append(n.members, b)
+	n.mu.Unlock()
+	return b
+}
+
+// memoryBus is a member of a MemoryNetwork
+type memoryBus struct {
+	network *MemoryNetwork
+	handler func(Event) // nil until subscribed
+	closed  bool
+}
+
+func (b *memoryBus) Publish(event Event) error {
+	if _, err := eventSize(event); err != nil {
+		return err // the same limits as on a network
+	}
+
+	n := b.network
+	n.mu.Lock()
+	if b.closed {
+		n.mu.Unlock()
+		return ErrBusClosed
+	}
+	var handlers []func(Event)
+	for _, member := range n.members {
+		if member != b && member.handler != nil {
+			handlers = append(handlers, member.handler)
+		}
+	}
+	n.mu.Unlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+	return nil
+}
+
+func (b *memoryBus) Subscribe(handler func(Event)) {
+	b.network.mu.Lock()
+	b.handler = handler
+	b.network.mu.Unlock()
+}
+
+func (b *memoryBus) Close() error {
+	n := b.network
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	b.closed = true
+	for i, member := range n.members {
+		if member == b {
+			n.members = append(n.members[:i], n.members[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+// Distributed Cache Client
+//
+// Dependencies: ring.go, protocol.go
+//
+// Description:
+// Client spreads keys over several cache servers. Every request goes to the
+// node that owns its key on a consistent-hash ring, so all clients with the
+// same nodes agree on where a key lives, and a change of membership only
+// moves the keys of the nodes that joined or left. Keys that move are not
+// copied over: they miss on their new node, and the old node evicts them in
+// time.
+//
+// The client keeps a few idle connections to every node and reuses them.
+// There is no failover: if a node cannot be reached, requests for its keys
+// fail with the network error until the node is back or removed. A node that
+// accepts a request but never answers fails it once the request timeout is
+// over (see SetRequestTimeout), and its connection is closed.
+
+package cluster
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNoNodes is returned by requests of a client that has no nodes
+	ErrNoNodes = errors.New("cluster: no nodes")
+
+	// ErrServer is returned when a server could not serve a request
+	ErrServer = errors.New("cluster: server error")
+)
+
+const (
+	dialTimeout           = 5 * time.Second
+	defaultRequestTimeout = 5 * time.Second // to send a request and read its response
+	maxIdle               = 4               // idle connections kept per node
+)
+
+// Client is a client of a distributed cache. It is safe for concurrent use.
+type Client struct {
+	mu      sync.RWMutex
+	ring    *Ring
+	nodes   map[string]*node // by address
+	timeout time.Duration    // per request, 0 for none
+}
+
+// node is a cache server and the idle connections to it
+type node struct {
+	addr string
+
+	mu     sync.Mutex
+	idle   []*clientConn
+	closed bool // removed from the client, connections are not kept
+}
+
+// clientConn is a connection to a node
+type clientConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// NewClient creates a client without nodes. Every node is placed on the ring
+// with replicas virtual nodes per unit of weight, see NewRing.
+func NewClient(replicas int) *Client {
+	return &Client{ring: NewRing(replicas), nodes: make(map[string]*node), timeout: defaultRequestTimeout}
+}
+
+// SetRequestTimeout sets how long a request may take to be sent and answered,
+// once connected. It is 5 seconds by default, a timeout <= 0 waits forever.
+func (c *Client) SetRequestTimeout(timeout time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if timeout < 0 {
+		timeout = 0
+	}
+	c.timeout = timeout
+}
+
+// AddNode adds the server at addr with the given weight, or changes its
+// weight if it was added already
+func (c *Client) AddNode(addr string, weight int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.nodes[addr]; !ok {
+		c.nodes[addr] = &node{addr: addr}
+	}
+	c.ring.Add(addr, weight)
+}
+
+// RemoveNode removes the server at addr and closes the connections to it
+func (c *Client) RemoveNode(addr string) {
+	c.mu.Lock()
+	n, ok := c.nodes[addr]
+	delete(c.nodes, addr)
+	c.ring.Remove(addr)
+	c.mu.Unlock()
+
+	if ok {
+		n.mu.Lock()
+		n.closed = true
+		n.mu.Unlock()
+		n.close()
+	}
+}
+
+// Nodes returns the addresses of the servers, sorted
+func (c *Client) Nodes() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ring.Nodes()
+}
+
+// NodeFor returns the address of the server that owns key
+func (c *Client) NodeFor(key string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ring.Node(key)
+}
+
+// Get returns the value of key from its server, and false if the server does
+// not have it
+func (c *Client) Get(key string) ([]byte, bool, error) {
+	status, value, err := c.do(opGet, key, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	return value, status == statusOK, nil
+}
+
+// Set stores a key-value pair on the server of key, and returns whether the
+// server stored it
+func (c *Client) Set(key string, value []byte) (bool, error) {
+	status, _, err := c.do(opSet, key, value)
+	if err != nil {
+		return false, err
+	}
+	return status == statusOK, nil
+}
+
+// Remove removes key from its server and returns the value it had, if any
+func (c *Client) Remove(key string) ([]byte, bool, error) {
+	status, value, err := c.do(opRemove, key, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	return value, status == statusOK, nil
+}
+
+// Close closes all idle connections. Requests in flight finish, and the
+// client can still be used afterwards.
+func (c *Client) Close() {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, n := range c.nodes {
+		n.close()
+	}
+}
+
+// do sends a request to the node that owns key
+func (c *Client) do(op byte, key string, value []byte) (byte, []byte, error) {
+	if len(key) > maxFrameKey || len(value) > maxFrameValue {
+		return 0, nil, ErrTooLarge
+	}
+
+	c.mu.RLock()
+	addr, ok := c.ring.Node(key)
+	n := c.nodes[addr]
+	timeout := c.timeout
+	c.mu.RUnlock()
+	if !ok {
+		return 0, nil, ErrNoNodes
+	}
+
+	status, value, err := n.do(op, key, value, timeout)
+	if err == nil && status == statusError {
+		err = fmt.Errorf("%w: %s", ErrServer, value)
+	}
+	return status, value, err
+}
+
+// do sends a request over an idle connection, or a new one, and waits for
+// the response at most timeout, if it is > 0. A connection that fails or
+// times out is closed.
+func (n *node) do(op byte, key string, value []byte, timeout time.Duration) (byte, []byte, error) {
+	cc, err := n.get()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var deadline time.Time // the zero time clears the deadline of a reused connection
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	err = cc.conn.SetDeadline(deadline)
+	if err == nil {
+		err = writeRequest(cc.w, op, key, value)
+	}
+	if err == nil {
+		err = cc.w.Flush()
+	}
+	var status byte
+	if err == nil {
+		status, value, err = readResponse(cc.r)
+	}
+	if err != nil {
+		cc.conn.Close()
+		return 0, nil, err
+	}
+
+	n.put(cc)
+	return status, value, nil
+}
+
+// get returns an idle connection, or dials a new one
+func (n *node) get() (*clientConn, error) {
+	n.mu.Lock()
+	if len(n.idle) > 0 {
+		cc := n.idle[len(n.idle)-1]
+		n.idle = n.idle[:len(n.idle)-1]
+		n.mu.Unlock()
+		return cc, nil
+	}
+	n.mu.Unlock()
+
+	conn, err := net.DialTimeout("tcp", n.addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &clientConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}, nil
+}
+
+// put keeps a connection for reuse, or closes it if enough are idle
+func (n *node) put(cc *clientConn) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.closed && len(n.idle) < maxIdle {
+		n.idle = append(n.idle, cc)
+		return
+	}
+	cc.conn.Close()
+}
+
+// close closes the idle connections
+func (n *node) close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, cc := range n.idle {
+		cc.conn.Close()
+	}
+	n.idle = nil
+}
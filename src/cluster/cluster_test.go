@@ -0,0 +1,198 @@
+package cluster
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"cos316.princeton.edu/final_proj/arc"
+)
+
+// startServer serves an ARC of the given size on a loopback port, until the
+// test ends
+func startServer(t *testing.T, size int) (*Server, *arc.ConcurrentARC, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := arc.NewConcurrentARC(size)
+	server := NewServer(cache)
+	go server.Serve(l)
+	t.Cleanup(func() { server.Close() })
+	return server, cache, l.Addr().String()
+}
+
+// newTestCluster starts n servers and a client of all of them
+func newTestCluster(t *testing.T, n int) (*Client, map[string]*arc.ConcurrentARC) {
+	t.Helper()
+	client := NewClient(100)
+	t.Cleanup(client.Close)
+	caches := make(map[string]*arc.ConcurrentARC)
+	for i := 0; i < n; i++ {
+		_, cache, addr := startServer(t, 1000)
+		caches[addr] = cache
+		client.AddNode(addr, 1)
+	}
+	return client, caches
+}
+
+// every key is stored on the node that owns it, and only there
+func TestClientRoutesKeys(t *testing.T) {
+	client, caches := newTestCluster(t, 3)
+	keys := testKeys(600)
+	for _, key := range keys {
+		if ok, err := client.Set(key, []byte("value of "+key)); !ok || err != nil {
+			t.Fatalf("Set(%s) = %v, %v", key, ok, err)
+		}
+	}
+
+	for _, key := range keys {
+		value, ok, err := client.Get(key)
+		if err != nil || !ok || string(value) != "value of "+key {
+			t.Fatalf("Get(%s) = %q, %v, %v", key, value, ok, err)
+		}
+		owner, _ := client.NodeFor(key)
+		for addr, cache := range caches {
+			if _, cached := cache.Get(key); cached != (addr == owner) {
+				t.Fatalf("expected %s to be cached on %s only, found it on %s", key, owner, addr)
+			}
+		}
+	}
+
+	if value, ok, err := client.Remove("key0"); err != nil || !ok || string(value) != "value of key0" {
+		t.Errorf("Remove(key0) = %q, %v, %v", value, ok, err)
+	}
+	if _, ok, err := client.Get("key0"); ok || err != nil {
+		t.Errorf("expected key0 to be gone, got %v, %v", ok, err)
+	}
+}
+
+// a node that joins starts out empty, so about its share of the keys miss,
+// and the others are still where they were
+func TestClientMembership(t *testing.T) {
+	client, _ := newTestCluster(t, 3)
+	keys := testKeys(1000)
+	for _, key := range keys {
+		client.Set(key, []byte("v"))
+	}
+
+	_, _, addr := startServer(t, 1000)
+	client.AddNode(addr, 1)
+	misses := 0
+	for _, key := range keys {
+		if _, ok, err := client.Get(key); err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			misses++
+			if owner, _ := client.NodeFor(key); owner != addr {
+				t.Fatalf("expected only keys of the new node to miss, %s is on %s", key, owner)
+			}
+		}
+	}
+	if misses < 150 || misses > 350 {
+		t.Errorf("expected about a quarter of the keys to miss, %d did", misses)
+	}
+
+	// once the node leaves again, every key is found where it was
+	client.RemoveNode(addr)
+	for _, key := range keys {
+		if _, ok, err := client.Get(key); !ok || err != nil {
+			t.Fatalf("expected %s to be found after the new node left, got %v, %v", key, ok, err)
+		}
+	}
+}
+
+// requests for the keys of a node that is down fail, the others still work
+func TestClientNodeDown(t *testing.T) {
+	client := NewClient(100)
+	defer client.Close()
+	if _, _, err := client.Get("key"); err != ErrNoNodes {
+		t.Errorf("expected ErrNoNodes, got %v", err)
+	}
+
+	down, _, downAddr := startServer(t, 100)
+	_, _, upAddr := startServer(t, 100)
+	client.AddNode(downAddr, 1)
+	client.AddNode(upAddr, 1)
+	for _, key := range testKeys(100) {
+		client.Set(key, []byte("v"))
+	}
+	down.Close()
+
+	for _, key := range testKeys(100) {
+		_, ok, err := client.Get(key)
+		if owner, _ := client.NodeFor(key); owner == downAddr {
+			if err == nil {
+				t.Fatalf("expected an error for %s on the node that is down", key)
+			}
+		} else if !ok || err != nil {
+			t.Fatalf("expected %s to be found on the node that is up, got %v, %v", key, ok, err)
+		}
+	}
+}
+
+// a node that accepts requests but never answers fails them after the
+// request timeout, instead of blocking its callers
+func TestClientRequestTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close() // read nothing, answer nothing
+		}
+	}()
+
+	client := NewClient(100)
+	defer client.Close()
+	client.AddNode(l.Addr().String(), 1)
+	client.SetRequestTimeout(50 * time.Millisecond)
+
+	start := time.Now()
+	_, _, err = client.Get("key")
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the request to give up after the timeout, took %v", elapsed)
+	}
+	if _, ok, err := client.Get("key"); ok || err == nil {
+		t.Errorf("expected the next request to time out as well, got %v, %v", ok, err)
+	}
+}
+
+// keys and values over the limits are refused by the client, and frames that
+// announce them are rejected by the reader before the body is allocated
+func TestFrameLimits(t *testing.T) {
+	client, _ := newTestCluster(t, 1)
+	if _, err := client.Set("key", make([]byte, maxFrameValue+1)); err != ErrTooLarge {
+		t.Errorf("expected ErrTooLarge for a large value, got %v", err)
+	}
+	if _, _, err := client.Get(strings.Repeat("k", maxFrameKey+1)); err != ErrTooLarge {
+		t.Errorf("expected ErrTooLarge for a large key, got %v", err)
+	}
+	if ok, err := client.Set("key", make([]byte, maxFrameValue)); !ok || err != nil {
+		t.Errorf("expected a value at the limit to be stored, got %v, %v", ok, err)
+	}
+
+	var frame bytes.Buffer
+	w := bufio.NewWriter(&frame)
+	writeRequest(w, opSet, "key", make([]byte, 10))
+	w.Flush()
+	header := frame.Bytes()[:9]
+	binary.BigEndian.PutUint32(header[5:], maxFrameValue+1) // no body follows
+	if _, _, _, err := readRequest(bufio.NewReader(bytes.NewReader(header))); err != ErrTooLarge {
+		t.Errorf("expected ErrTooLarge for a frame over the limit, got %v", err)
+	}
+}
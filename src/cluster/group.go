@@ -0,0 +1,267 @@
+// Peer-Filled Cache Group
+//
+// Dependencies: ring.go, singleflight.go
+//
+// Description:
+// Group is a cache in the style of groupcache, shared by a set of peer
+// processes. Every process runs a Group with the same name and the same
+// list of peers, and the peers split the key space among themselves with a
+// consistent-hash ring. Each process keeps two ARCs:
+//   - the main cache holds the keys the process owns. A miss on a key it
+//     owns is loaded from the origin by the Loader.
+//   - the hot cache, a small one, holds copies of keys owned by other peers.
+//     A miss on such a key asks the owner over HTTP. The answer only goes
+//     into the hot cache the second time the key is fetched, so keys asked
+//     for once never push hot ones out, and the hot cache ends up holding
+//     the keys that are hot on this process.
+//
+// The owner of a key loads it from the origin only once: concurrent requests
+// for a key, from local callers and from peers alike, share one load. If the
+// owner cannot be reached, the process loads the key itself rather than
+// failing, and keeps it in its hot cache.
+//
+// Values never change once loaded, as in groupcache. There is no Set or
+// Remove, a value only leaves the caches by eviction.
+
+package cluster
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cos316.princeton.edu/final_proj/arc"
+)
+
+// groupPathPrefix is the start of the URL path of every group
+const groupPathPrefix = "/_arcgroup/"
+
+// defaultPeerTimeout bounds a fetch from a peer, unless SetHTTPClient is called
+const defaultPeerTimeout = 5 * time.Second
+
+// GroupStats counts what happened to the requests of a group
+type GroupStats struct {
+	Gets          int64 // calls of Get
+	MainHits      int64 // Gets answered by the main cache
+	HotHits       int64 // Gets answered by the hot cache
+	PeerLoads     int64 // keys fetched from their owner
+	PeerErrors    int64 // failed fetches from an owner
+	Loads         int64 // keys loaded from the origin
+	LoadErrors    int64 // failed loads from the origin
+	ServedToPeers int64 // requests of peers answered
+}
+
+// Group is a cache shared by a set of peers. It is safe for concurrent use,
+// and it is the http.Handler that answers the requests of the other peers.
+type Group struct {
+	name string
+	self string // URL of this process, as the peers know it
+	load arc.Loader
+
+	main *arc.ConcurrentARC // keys this process owns
+	hot  *arc.ConcurrentARC // copies of keys owned by other peers
+
+	mu     sync.RWMutex
+	ring   *Ring
+	client *http.Client
+
+	fetchedMu sync.Mutex
+	fetched   *arc.LRU // keys fetched once from their owner, not yet in hot
+
+	flight      flightGroup // Gets that missed both caches
+	ownerFlight flightGroup // loads of keys this process owns
+	stats       GroupStats  // updated atomically
+}
+
+// NewGroup creates a group. self is the base URL under which this process
+// serves the group to its peers (e.g. "http://10.0.0.1:8080"), load loads a
+// key from the origin, and size and hotSize are the sizes of the main and
+// the hot cache. Until SetPeers is called, the process owns every key.
+func NewGroup(name, self string, size, hotSize int, load arc.Loader) *Group {
+	g := &Group{
+		name:   name,
+		self:   strings.TrimSuffix(self, "/"),
+		load:   load,
+		client: &http.Client{Timeout: defaultPeerTimeout},
+		main:   arc.NewConcurrentARC(size),
+		hot:    arc.NewConcurrentARC(hotSize),
+		ring:   NewRing(100),
+
+		fetched: arc.NewLru(hotSize),
+	}
+	g.ring.Add(g.self, 1)
+	return g
+}
+
+// SetPeers replaces the peers of the group with the given base URLs. The
+// list should include this process itself, and be the same on every peer.
+func (g *Group) SetPeers(peers ...string) {
+	ring := NewRing(100)
+	for _, peer := range peers {
+		ring.Add(strings.TrimSuffix(peer, "/"), 1)
+	}
+	g.mu.Lock()
+	g.ring = ring
+	g.mu.Unlock()
+}
+
+// SetHTTPClient sets the client used to ask peers. Unless set, a fetch times
+// out after 5 seconds.
+func (g *Group) SetHTTPClient(client *http.Client) {
+	g.mu.Lock()
+	g.client = client
+	g.mu.Unlock()
+}
+
+// Path returns the URL path under which the group has to be served, for
+// http.ServeMux.Handle
+func (g *Group) Path() string {
+	return groupPathPrefix + url.PathEscape(g.name) + "/"
+}
+
+// Get returns the value of key from the caches, from its owner, or from the
+// origin if this process is the owner
+func (g *Group) Get(key string) ([]byte, error) {
+	atomic.AddInt64(&g.stats.Gets, 1)
+	if value, ok := g.main.Get(key); ok {
+		atomic.AddInt64(&g.stats.MainHits, 1)
+		return value, nil
+	}
+	if value, ok := g.hot.Get(key); ok {
+		atomic.AddInt64(&g.stats.HotHits, 1)
+		return value, nil
+	}
+
+	value, err, _ := g.flight.do(key, func() ([]byte, error) {
+		g.mu.RLock()
+		owner, _ := g.ring.Node(key)
+		client := g.client
+		g.mu.RUnlock()
+		if owner == g.self {
+			return g.owned(key)
+		}
+
+		value, err := g.fetch(client, owner, key)
+		if err == nil {
+			atomic.AddInt64(&g.stats.PeerLoads, 1)
+			if g.fetchedBefore(key) {
+				g.hot.Set(key, value)
+			}
+			return value, nil
+		}
+		atomic.AddInt64(&g.stats.PeerErrors, 1)
+
+		// the owner is unreachable, load the key here instead
+		value, err = g.loadOrigin(key)
+		if err == nil {
+			g.hot.Set(key, value)
+		}
+		return value, err
+	})
+	return value, err
+}
+
+// owned returns a key this process owns, from the main cache or the origin.
+// Local Gets and peers share one load. The owner flights are apart from the
+// flights of Get, since a Get may be waiting for a peer that is asking back.
+func (g *Group) owned(key string) ([]byte, error) {
+	value, err, _ := g.ownerFlight.do(key, func() ([]byte, error) {
+		// a flight that just finished may have filled it
+		if value, ok := g.main.Get(key); ok {
+			return value, nil
+		}
+		value, err := g.loadOrigin(key)
+		if err == nil {
+			g.main.Set(key, value)
+		}
+		return value, err
+	})
+	return value, err
+}
+
+// loadOrigin loads key with the loader
+func (g *Group) loadOrigin(key string) ([]byte, error) {
+	value, err := g.load(key)
+	if err != nil {
+		atomic.AddInt64(&g.stats.LoadErrors, 1)
+		return nil, err
+	}
+	atomic.AddInt64(&g.stats.Loads, 1)
+	return value, nil
+}
+
+// fetchedBefore records a fetch of key from its owner, and tells if the key
+// was fetched before, recently enough to still be remembered
+func (g *Group) fetchedBefore(key string) bool {
+	g.fetchedMu.Lock()
+	defer g.fetchedMu.Unlock()
+	if _, ok := g.fetched.Remove(key); ok {
+		return true
+	}
+	g.fetched.Set(key, nil)
+	return false
+}
+
+// fetch asks peer for the value of key
+func (g *Group) fetch(client *http.Client, peer, key string) ([]byte, error) {
+	resp, err := client.Get(peer + g.Path() + url.PathEscape(key))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cluster: peer %s: %s: %s", peer, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// ServeHTTP answers a peer that asks for a key this process owns. The key is
+// served as the owner would, whatever this process' ring says, so peers
+// whose rings briefly disagree never send requests in circles.
+func (g *Group) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.EscapedPath(), groupPathPrefix)
+	parts := strings.SplitN(rest, "/", 2)
+	if len(parts) != 2 || rest == r.URL.EscapedPath() {
+		http.NotFound(w, r)
+		return
+	}
+	name, err1 := url.PathUnescape(parts[0])
+	key, err2 := url.PathUnescape(parts[1])
+	if err1 != nil || err2 != nil || name != g.name {
+		http.NotFound(w, r)
+		return
+	}
+
+	atomic.AddInt64(&g.stats.ServedToPeers, 1)
+	value, err := g.owned(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(value)
+}
+
+// Stats returns a snapshot of the counters of the group
+func (g *Group) Stats() GroupStats {
+	return GroupStats{
+		Gets:          atomic.LoadInt64(&g.stats.Gets),
+		MainHits:      atomic.LoadInt64(&g.stats.MainHits),
+		HotHits:       atomic.LoadInt64(&g.stats.HotHits),
+		PeerLoads:     atomic.LoadInt64(&g.stats.PeerLoads),
+		PeerErrors:    atomic.LoadInt64(&g.stats.PeerErrors),
+		Loads:         atomic.LoadInt64(&g.stats.Loads),
+		LoadErrors:    atomic.LoadInt64(&g.stats.LoadErrors),
+		ServedToPeers: atomic.LoadInt64(&g.stats.ServedToPeers),
+	}
+}
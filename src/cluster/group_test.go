@@ -0,0 +1,183 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// origin is a Loader that counts its loads. While gated, loads block until
+// the gate is closed.
+type origin struct {
+	mu    sync.Mutex
+	loads map[string]int
+	gate  chan struct{}
+}
+
+func (o *origin) load(key string) ([]byte, error) {
+	o.mu.Lock()
+	o.loads[key]++
+	gate := o.gate
+	o.mu.Unlock()
+	if gate != nil {
+		<-gate
+	}
+	if key == "broken" {
+		return nil, errors.New("origin failed")
+	}
+	return []byte("value of " + key), nil
+}
+
+func (o *origin) count(key string) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.loads[key]
+}
+
+// newTestGroups starts n peers of a group on httptest servers, all loading
+// from the same origin
+func newTestGroups(t *testing.T, n int) ([]*Group, []*httptest.Server, *origin) {
+	t.Helper()
+	o := &origin{loads: make(map[string]int)}
+	groups := make([]*Group, n)
+	servers := make([]*httptest.Server, n)
+	var urls []string
+	for i := range groups {
+		// the URL is only known once the server runs
+		mux := http.NewServeMux()
+		servers[i] = httptest.NewServer(mux)
+		groups[i] = NewGroup("test", servers[i].URL, 100, 100, o.load)
+		mux.Handle(groups[i].Path(), groups[i])
+		urls = append(urls, servers[i].URL)
+		t.Cleanup(servers[i].Close)
+	}
+	for _, g := range groups {
+		g.SetPeers(urls...)
+	}
+	return groups, servers, o
+}
+
+// ownerOf returns the index of the peer that owns key
+func ownerOf(groups []*Group, key string) int {
+	owner, _ := groups[0].ring.Node(key)
+	for i, g := range groups {
+		if g.self == owner {
+			return i
+		}
+	}
+	return -1
+}
+
+// every key is loaded from the origin once, by its owner, whichever peer it
+// is asked of
+func TestGroupLoadsOnce(t *testing.T) {
+	groups, _, o := newTestGroups(t, 3)
+	keys := testKeys(30)
+	for round := 0; round < 3; round++ {
+		for _, g := range groups {
+			for _, key := range keys {
+				if value, err := g.Get(key); err != nil || string(value) != "value of "+key {
+					t.Fatalf("Get(%s) = %q, %v", key, value, err)
+				}
+			}
+		}
+	}
+
+	for _, key := range keys {
+		if o.count(key) != 1 {
+			t.Errorf("expected %s to be loaded once, got %d loads", key, o.count(key))
+		}
+		owner := ownerOf(groups, key)
+		if _, ok := groups[owner].main.Get(key); !ok {
+			t.Errorf("expected %s in the main cache of its owner", key)
+		}
+	}
+
+	var total GroupStats
+	for _, g := range groups {
+		stats := g.Stats()
+		total.Loads += stats.Loads
+		total.PeerLoads += stats.PeerLoads
+		total.ServedToPeers += stats.ServedToPeers
+		total.HotHits += stats.HotHits
+	}
+	// each key: 1 load by its owner, 2 fetches by each of the other peers,
+	// which only keep it in their hot caches after the second fetch and hit
+	// them in the third round
+	if total.Loads != 30 || total.PeerLoads != 120 || total.ServedToPeers != 120 || total.HotHits != 60 {
+		t.Errorf("unexpected totals %+v", total)
+	}
+}
+
+// concurrent misses on a key share one fetch and one load
+func TestGroupSingleFlight(t *testing.T) {
+	groups, _, o := newTestGroups(t, 3)
+	key := "shared"
+	o.gate = make(chan struct{})
+
+	var wg sync.WaitGroup
+	for _, g := range groups {
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func(g *Group) {
+				defer wg.Done()
+				if _, err := g.Get(key); err != nil {
+					t.Error(err)
+				}
+			}(g)
+		}
+	}
+	for o.count(key) == 0 {
+		time.Sleep(time.Millisecond) // until the owner is loading
+	}
+	close(o.gate)
+	wg.Wait()
+
+	if o.count(key) != 1 {
+		t.Errorf("expected one load for 15 concurrent Gets, got %d", o.count(key))
+	}
+	for i, g := range groups {
+		if i != ownerOf(groups, key) && g.Stats().PeerLoads > 1 {
+			t.Errorf("expected peer %d to fetch once, got %d fetches", i, g.Stats().PeerLoads)
+		}
+	}
+}
+
+// if the owner is down, a peer loads the key itself; load errors are passed
+// on and not cached
+func TestGroupFailures(t *testing.T) {
+	groups, servers, o := newTestGroups(t, 2)
+	var key string
+	for i := 0; ; i++ {
+		if key = fmt.Sprint("key", i); ownerOf(groups, key) == 1 {
+			break
+		}
+	}
+
+	servers[1].Close()
+	if value, err := groups[0].Get(key); err != nil || string(value) != "value of "+key {
+		t.Fatalf("expected the key to be loaded locally, got %q, %v", value, err)
+	}
+	if stats := groups[0].Stats(); stats.PeerErrors != 1 || stats.Loads != 1 {
+		t.Errorf("expected a failed fetch and a local load, got %+v", stats)
+	}
+	if _, ok := groups[0].hot.Get(key); !ok {
+		t.Errorf("expected the key loaded in place of its owner in the hot cache")
+	}
+
+	// broken is owned by one of the two, it fails either way
+	for _, g := range groups[:1] {
+		for i := 0; i < 2; i++ {
+			if _, err := g.Get("broken"); err == nil {
+				t.Errorf("expected the origin error")
+			}
+		}
+	}
+	if o.count("broken") != 2 {
+		t.Errorf("expected failed loads not to be cached, got %d loads", o.count("broken"))
+	}
+}
@@ -0,0 +1,106 @@
+// Cache Protocol
+//
+// Description:
+// Clients and servers talk over a TCP connection in frames. A connection
+// carries one request at a time: the client writes a request frame and
+// waits for the response frame before it sends the next request. Integers
+// are big endian.
+//
+//   request:  op (1 byte, 'G', 'S' or 'R'), key length (4), value length (4), key, value
+//   response: status (1 byte), value length (4), value
+//
+// The value of a request is empty except for Set. The status is statusOK
+// for a found key or a stored value, statusMissing for a key that is not
+// cached or a value the cache did not store, and statusError with the error
+// message as the value if the request could not be served.
+//
+// Keys are at most 64 KiB and values at most 1 MiB, the largest value an
+// off-heap ARC can store in a slab page. A frame that announces more is
+// rejected before anything is allocated for it.
+
+package cluster
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	opGet    = 'G'
+	opSet    = 'S'
+	opRemove = 'R'
+
+	statusOK      = 0
+	statusMissing = 1
+	statusError   = 2
+
+	// maxFrameKey and maxFrameValue bound the lengths in a frame, so a
+	// corrupt or hostile frame cannot make the reader allocate much
+	maxFrameKey   = 64 << 10
+	maxFrameValue = 1 << 20
+)
+
+// ErrTooLarge is returned for a key or value longer than the protocol allows,
+// and when a frame announces one
+var ErrTooLarge = errors.New("cluster: key or value too large")
+
+// writeRequest writes a request frame, without flushing w
+func writeRequest(w *bufio.Writer, op byte, key string, value []byte) error {
+	var header [9]byte
+	header[0] = op
+	binary.BigEndian.PutUint32(header[1:], uint32(len(key)))
+	binary.BigEndian.PutUint32(header[5:], uint32(len(value)))
+	w.Write(header[:])
+	w.WriteString(key)
+	_, err := w.Write(value)
+	return err
+}
+
+// readRequest reads a request frame
+func readRequest(r *bufio.Reader) (op byte, key string, value []byte, err error) {
+	var header [9]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, "", nil, err
+	}
+	keyLen := binary.BigEndian.Uint32(header[1:])
+	valueLen := binary.BigEndian.Uint32(header[5:])
+	if keyLen > maxFrameKey || valueLen > maxFrameValue {
+		return 0, "", nil, ErrTooLarge
+	}
+
+	body := make([]byte, keyLen+valueLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, "", nil, err
+	}
+	return header[0], string(body[:keyLen]), body[keyLen:], nil
+}
+
+// writeResponse writes a response frame, without flushing w
+func writeResponse(w *bufio.Writer, status byte, value []byte) error {
+	var header [5]byte
+	header[0] = status
+	binary.BigEndian.PutUint32(header[1:], uint32(len(value)))
+	w.Write(header[:])
+	_, err := w.Write(value)
+	return err
+}
+
+// readResponse reads a response frame
+func readResponse(r *bufio.Reader) (status byte, value []byte, err error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	valueLen := binary.BigEndian.Uint32(header[1:])
+	if valueLen > maxFrameValue {
+		return 0, nil, ErrTooLarge
+	}
+
+	value = make([]byte, valueLen)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return 0, nil, err
+	}
+	return header[0], value, nil
+}
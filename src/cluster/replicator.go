@@ -0,0 +1,155 @@
+// Replicated Cache
+//
+// Dependencies: bus.go
+//
+// Description:
+// Replicator keeps the ARCs of several service instances from serving stale
+// data when one of them writes a key. Every instance wraps its ARC in a
+// Replicator on a shared Bus. A Set or Remove is applied to the local ARC
+// and published; the other instances apply it with ApplySet or ApplyRemove
+// (see arc/remote.go). So an instance that caches the key gets the new value,
+// or drops the key, while the adaptation of its ARC is left alone.
+//
+// A value too large for the bus is not sent: the instance publishes a
+// removal of the key instead, so the others fetch it again when they need
+// it. The same is done when the local ARC could not store the value.
+
+package cluster
+
+import (
+	"sync/atomic"
+
+	"cos316.princeton.edu/final_proj/arc"
+)
+
+// ReplicationStats counts the events of a Replicator
+type ReplicationStats struct {
+	Published     int64 // events published
+	PublishErrors int64 // events that could not be published
+	Applied       int64 // events of other instances that changed the cache
+	Ignored       int64 // events that were stale, repeated or for keys not cached
+}
+
+// Replicator is a cache that broadcasts its writes to the other instances on
+// a bus, and applies theirs. It is safe for concurrent use.
+type Replicator struct {
+	name  string
+	cache *arc.ConcurrentARC
+	bus   Bus
+	stats ReplicationStats // updated atomically
+}
+
+// NewReplicator creates a replicator for cache, that publishes on bus under
+// name. The names of the instances on a bus must be distinct.
+func NewReplicator(name string, cache *arc.ConcurrentARC, bus Bus) *Replicator {
+	r := &Replicator{name: name, cache: cache, bus: bus}
+	bus.Subscribe(r.apply)
+	return r
+}
+
+// Get returns the value associated with the given key, if it exists.
+func (r *Replicator) Get(key string) ([]byte, bool) {
+	return r.cache.Get(key)
+}
+
+// Set puts a key-value pair into cache and publishes it. Returns true if the
+// binding was added successfully, else false.
+func (r *Replicator) Set(key string, value []byte) bool {
+	var version uint64
+	var ok bool
+	r.cache.Do(func(a *arc.ARC) {
+		if version, ok = a.SetVersioned(key, value); !ok {
+			_, version, _ = a.RemoveVersioned(key)
+		}
+	})
+
+	if ok {
+		err := r.publish(Event{Op: EventSet, Key: key, Value: value, Version: version})
+		if err != ErrEventTooLarge {
+			return true
+		}
+	}
+	// a removal of the same version wins over the Set
+	r.publish(Event{Op: EventRemove, Key: key, Version: version})
+	return ok
+}
+
+// Remove removes and returns the value associated with the given key, if it
+// exists, and publishes the removal whether it existed or not
+func (r *Replicator) Remove(key string) ([]byte, bool) {
+	var value []byte
+	var version uint64
+	var ok bool
+	r.cache.Do(func(a *arc.ARC) {
+		value, version, ok = a.RemoveVersioned(key)
+	})
+	r.publish(Event{Op: EventRemove, Key: key, Version: version})
+	return value, ok
+}
+
+// Len returns the number of entries in the cache
+func (r *Replicator) Len() int {
+	return r.cache.Len()
+}
+
+// MaxSize returns the number of entries supported by the cache
+func (r *Replicator) MaxSize() int {
+	return r.cache.MaxSize()
+}
+
+// Stats returns a snapshot of the hits and misses of the cache
+func (r *Replicator) Stats() *arc.Stats {
+	return r.cache.Stats()
+}
+
+// report hits/misses from Get calls to stdout
+func (r *Replicator) ReportStats() {
+	r.cache.ReportStats()
+}
+
+// ReplicationStats returns a snapshot of the event counters
+func (r *Replicator) ReplicationStats() ReplicationStats {
+	return ReplicationStats{
+		Published:     atomic.LoadInt64(&r.stats.Published),
+		PublishErrors: atomic.LoadInt64(&r.stats.PublishErrors),
+		Applied:       atomic.LoadInt64(&r.stats.Applied),
+		Ignored:       atomic.LoadInt64(&r.stats.Ignored),
+	}
+}
+
+// publish sends event on the bus
+func (r *Replicator) publish(event Event) error {
+	event.Origin = r.name
+	if err := r.bus.Publish(event); err != nil {
+		atomic.AddInt64(&r.stats.PublishErrors, 1)
+		return err
+	}
+	atomic.AddInt64(&r.stats.Published, 1)
+	return nil
+}
+
+// apply applies an event of another instance to the cache
+func (r *Replicator) apply(event Event) {
+	if event.Origin == r.name {
+		return // our own, looped back by a multicast group
+	}
+
+	var applied bool
+	switch event.Op {
+	case EventSet:
+		value := append([]byte(nil), event.Value...) // the bus reuses its buffer
+		r.cache.Do(func(a *arc.ARC) {
+			applied = a.ApplySet(event.Key, value, event.Version)
+		})
+	case EventRemove:
+		r.cache.Do(func(a *arc.ARC) {
+			applied = a.ApplyRemove(event.Key, event.Version)
+		})
+	}
+
+	if applied {
+		atomic.AddInt64(&r.stats.Applied, 1)
+	} else {
+		atomic.AddInt64(&r.stats.Ignored, 1)
+	}
+}
@@ -0,0 +1,188 @@
+package cluster
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"cos316.princeton.edu/final_proj/arc"
+)
+
+// newReplicators creates n replicators of size 10 on buses
+func newReplicators(buses ...Bus) []*Replicator {
+	replicators := make([]*Replicator, len(buses))
+	for i, bus := range buses {
+		replicators[i] = NewReplicator(fmt.Sprint("r", i), arc.NewConcurrentARC(10), bus)
+	}
+	return replicators
+}
+
+// a write on one instance reaches the instances that cache the key, and
+// leaves no ghosts behind
+func TestReplicator(t *testing.T) {
+	network := NewMemoryNetwork()
+	r := newReplicators(network.Join(), network.Join(), network.Join())
+	r[0].Set("k", []byte("v0"))
+	r[1].Set("k", []byte("v1"))
+	if value, _ := r[0].Get("k"); string(value) != "v1" {
+		t.Errorf("expected the Set of r1 to reach r0, got %q", value)
+	}
+	if _, ok := r[2].Get("k"); ok {
+		t.Errorf("expected r2 not to cache a key it never asked for")
+	}
+
+	r[2].Remove("k")
+	for i, replicator := range r {
+		if _, ok := replicator.Get("k"); ok {
+			t.Errorf("expected the removal to reach r%d", i)
+		}
+	}
+
+	stats := r[0].ReplicationStats()
+	if stats.Published != 1 || stats.Applied != 2 || stats.Ignored != 0 {
+		t.Errorf("unexpected stats of r0 %+v", stats)
+	}
+}
+
+// events are applied once, in version order, however they arrive
+func TestReplicatorEventOrder(t *testing.T) {
+	network := NewMemoryNetwork()
+	source := network.Join()
+	r := newReplicators(network.Join())[0]
+	r.Set("k", []byte("local"))
+	var base uint64
+	r.cache.Do(func(a *arc.ARC) {
+		_, base, _ = a.GetWithVersion("k")
+	})
+
+	for _, event := range []Event{
+		{Op: EventSet, Key: "k", Value: []byte("v5"), Version: base + 5},
+		{Op: EventSet, Key: "k", Value: []byte("v3"), Version: base + 3},
+		{Op: EventSet, Key: "k", Value: []byte("v5"), Version: base + 5},
+		{Op: EventRemove, Key: "k", Version: base + 4},
+	} {
+		source.Publish(event)
+	}
+	if value, _ := r.Get("k"); string(value) != "v5" {
+		t.Errorf("expected the newest value, got %q", value)
+	}
+	if stats := r.ReplicationStats(); stats.Applied != 1 || stats.Ignored != 3 {
+		t.Errorf("expected 1 event applied and 3 ignored, got %+v", stats)
+	}
+
+	// the next local write is newer than the events seen
+	r.Set("k", []byte("local"))
+	source.Publish(Event{Op: EventRemove, Key: "k", Version: base + 5})
+	if value, _ := r.Get("k"); string(value) != "local" {
+		t.Errorf("expected the local write to win over an older removal, got %q", value)
+	}
+}
+
+// the writes of an instance that joins after the others have written are not
+// taken for stale ones
+func TestReplicatorLateJoin(t *testing.T) {
+	network := NewMemoryNetwork()
+	r := newReplicators(network.Join(), network.Join())
+	for i := 0; i < 10; i++ {
+		r[i%2].Set("k", []byte(fmt.Sprint("v", i)))
+	}
+
+	late := NewReplicator("late", arc.NewConcurrentARC(10), network.Join())
+	late.Set("k", []byte("late"))
+	for i, replicator := range r {
+		if value, _ := replicator.Get("k"); string(value) != "late" {
+			t.Errorf("expected the write of the late instance to reach r%d, got %q", i, value)
+		}
+	}
+}
+
+// a value too large for the bus makes the others drop the key
+func TestReplicatorLargeValue(t *testing.T) {
+	network := NewMemoryNetwork()
+	r := newReplicators(network.Join(), network.Join())
+	r[1].Set("k", []byte("old"))
+
+	large := make([]byte, maxEventSize)
+	if !r[0].Set("k", large) {
+		t.Fatalf("expected the large value to be stored locally")
+	}
+	if _, ok := r[1].Get("k"); ok {
+		t.Errorf("expected the stale copy to be removed")
+	}
+	if stats := r[0].ReplicationStats(); stats.PublishErrors != 1 || stats.Published != 1 {
+		t.Errorf("expected a failed Set event and a removal, got %+v", stats)
+	}
+}
+
+// events survive the trip through a datagram
+func TestEventEncoding(t *testing.T) {
+	for _, event := range []Event{
+		{Op: EventSet, Key: "key", Value: []byte("value"), Version: 1 << 40, Origin: "r0"},
+		{Op: EventRemove, Key: "", Version: 7},
+	} {
+		b, err := marshalEvent(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := unmarshalEvent(b)
+		if err != nil || got.Op != event.Op || got.Key != event.Key || got.Version != event.Version ||
+			got.Origin != event.Origin || !bytes.Equal(got.Value, event.Value) {
+			t.Errorf("expected %+v, got %+v, %v", event, got, err)
+		}
+	}
+	if _, err := unmarshalEvent([]byte{'X', 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}); err == nil {
+		t.Errorf("expected an unknown op to be rejected")
+	}
+	if _, err := unmarshalEvent([]byte{'S', 0, 0, 0, 0, 0, 0, 0, 0, 5, 0, 0}); err == nil {
+		t.Errorf("expected a truncated event to be rejected")
+	}
+}
+
+// replicators on the loopback interface keep each other up to date
+func TestUDPBus(t *testing.T) {
+	buses := make([]*UDPBus, 2)
+	for i := range buses {
+		bus, err := NewUDPBus("127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer bus.Close()
+		buses[i] = bus
+	}
+	buses[0].AddPeer(buses[1].Addr().String())
+	buses[1].AddPeer(buses[0].Addr().String())
+	r := newReplicators(buses[0], buses[1])
+
+	r[1].Set("k", []byte("old"))
+	waitFor(t, func() bool {
+		// until r0 has seen the version of old, or the two writes would be
+		// concurrent
+		return r[0].ReplicationStats().Ignored == 1
+	})
+	r[0].Set("k", []byte("new"))
+	waitFor(t, func() bool {
+		value, _ := r[1].Get("k")
+		return string(value) == "new"
+	})
+	r[0].Remove("k")
+	waitFor(t, func() bool {
+		_, ok := r[1].Get("k")
+		return !ok
+	})
+
+	buses[0].Close()
+	if err := buses[0].Publish(Event{Op: EventRemove, Key: "k"}); err != ErrBusClosed {
+		t.Errorf("expected Publish on a closed bus to fail, got %v", err)
+	}
+}
+
+// waitFor fails the test if done does not become true within a second
+func waitFor(t *testing.T, done func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !done(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out")
+		}
+	}
+}
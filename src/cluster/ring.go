@@ -0,0 +1,111 @@
+// Consistent-Hash Ring
+//
+// Description:
+// Ring maps keys to cache nodes with consistent hashing. Every node is placed
+// on a circle of 64-bit hashes at many points (virtual nodes), and a key
+// belongs to the node of the first point at or after the key's hash. Adding
+// or removing a node only moves the keys between its points and the points
+// before them, about 1/n of all keys, instead of reshuffling everything as
+// hash(key) % n would. A node's weight multiplies its number of points, so a
+// node of weight 2 gets about twice the keys of a node of weight 1.
+
+package cluster
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// ringPoint is a virtual node, one of the points of node on the ring
+type ringPoint struct {
+	hash uint64
+	node string
+}
+
+// Ring is a consistent-hash ring of weighted nodes. It is not safe for
+// concurrent use.
+type Ring struct {
+	replicas int            // points per unit of weight
+	weights  map[string]int // weight of every node
+	points   []ringPoint    // all points, by hash
+}
+
+// NewRing creates an empty ring that places replicas points per unit of a
+// node's weight. A few hundred points per node keep the load within a few
+// percent of the weights.
+func NewRing(replicas int) *Ring {
+	return &Ring{replicas: replicas, weights: make(map[string]int)}
+}
+
+// hashKey hashes a key or a point name onto the ring. FNV-1a alone clusters
+// strings that only differ in their last characters, the mix at the end
+// (from splitmix64) spreads them over the whole ring.
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// Add puts node on the ring with the given weight, or changes its weight if
+// it is there already. A weight <= 0 removes the node.
+func (r *Ring) Add(node string, weight int) {
+	if weight <= 0 {
+		r.Remove(node)
+		return
+	}
+	r.weights[node] = weight
+	r.rebuild()
+}
+
+// Remove takes node off the ring
+func (r *Ring) Remove(node string) {
+	if _, ok := r.weights[node]; ok {
+		delete(r.weights, node)
+		r.rebuild()
+	}
+}
+
+// rebuild places the points of every node. The points of a node only depend
+// on its name and weight, so every client computes the same ring.
+func (r *Ring) rebuild() {
+	r.points = r.points[:0]
+	for node, weight := range r.weights {
+		for i := 0; i < weight*r.replicas; i++ {
+			r.points = append(r.points, ringPoint{hashKey(node + "#" + strconv.Itoa(i)), node})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		a, b := r.points[i], r.points[j]
+		return a.hash < b.hash || (a.hash == b.hash && a.node < b.node)
+	})
+}
+
+// Node returns the node that key belongs to, or false if the ring is empty
+func (r *Ring) Node(key string) (string, bool) {
+	if len(r.points) == 0 {
+		return "", false
+	}
+	hash := hashKey(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= hash })
+	if i == len(r.points) {
+		i = 0 // wrap around
+	}
+	return r.points[i].node, true
+}
+
+// Nodes returns the nodes on the ring, sorted by name
+func (r *Ring) Nodes() []string {
+	nodes := make([]string, 0, len(r.weights))
+	for node := range r.weights {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
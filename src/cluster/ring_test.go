@@ -0,0 +1,93 @@
+package cluster
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// owners returns the node of every key
+func owners(r *Ring, keys []string) map[string]string {
+	nodes := make(map[string]string, len(keys))
+	for _, key := range keys {
+		nodes[key], _ = r.Node(key)
+	}
+	return nodes
+}
+
+func testKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprint("key", i)
+	}
+	return keys
+}
+
+// keys are spread over the nodes by weight
+func TestRingBalance(t *testing.T) {
+	r := NewRing(200)
+	weights := map[string]int{"a": 1, "b": 1, "c": 2}
+	for node, weight := range weights {
+		r.Add(node, weight)
+	}
+
+	counts := make(map[string]int)
+	keys := testKeys(100_000)
+	for _, node := range owners(r, keys) {
+		counts[node]++
+	}
+	for node, weight := range weights {
+		expected := float64(len(keys)*weight) / 4
+		if math.Abs(float64(counts[node])-expected) > 0.1*expected {
+			t.Errorf("expected node %s of weight %d to own about %.0f keys, got %d", node, weight, expected, counts[node])
+		}
+	}
+
+	if _, ok := NewRing(200).Node("key"); ok {
+		t.Errorf("expected an empty ring to own nothing")
+	}
+}
+
+// a node that joins only takes keys, from all nodes, and a node that leaves
+// only gives its own keys away
+func TestRingMembership(t *testing.T) {
+	r := NewRing(200)
+	for _, node := range []string{"a", "b", "c", "d"} {
+		r.Add(node, 1)
+	}
+	keys := testKeys(100_000)
+	before := owners(r, keys)
+
+	r.Add("e", 1)
+	after := owners(r, keys)
+	moved := 0
+	for _, key := range keys {
+		if after[key] != before[key] {
+			moved++
+			if after[key] != "e" {
+				t.Fatalf("expected %s to move to the new node, it moved from %s to %s", key, before[key], after[key])
+			}
+		}
+	}
+	if share := float64(moved) / float64(len(keys)); share < 0.15 || share > 0.25 {
+		t.Errorf("expected about a fifth of the keys to move, %.2f did", share)
+	}
+
+	r.Remove("b")
+	for key, node := range owners(r, keys) {
+		if after[key] != "b" && node != after[key] {
+			t.Fatalf("expected %s to stay on %s, it moved to %s", key, after[key], node)
+		}
+	}
+
+	// the ring only depends on the members, not on the order they joined in
+	other := NewRing(200)
+	for _, node := range []string{"e", "d", "c", "a"} {
+		other.Add(node, 1)
+	}
+	for key, node := range owners(other, keys) {
+		if owner, _ := r.Node(key); owner != node {
+			t.Fatalf("expected rings with the same nodes to agree on %s", key)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Cache Server
+//
+// Dependencies: protocol.go
+//
+// Description:
+// Server serves a cache to clients over TCP, one goroutine per connection.
+// It is the per-node half of a distributed cache: each cache process runs a
+// Server in front of its own ARC, and a Client spreads the keys over them.
+
+package cluster
+
+import (
+	"bufio"
+	"net"
+	"sync"
+
+	"cos316.princeton.edu/final_proj/arc"
+)
+
+// Server serves a cache over TCP
+type Server struct {
+	cache arc.Cache
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup // connections being served
+}
+
+// NewServer creates a server for cache, which must be safe for concurrent use
+// (an arc.ConcurrentARC for example)
+func NewServer(cache arc.Cache) *Server {
+	return &Server{cache: cache, conns: make(map[net.Conn]struct{})}
+}
+
+// Serve accepts connections on l and serves them until the server is closed.
+// It returns nil after Close, and the error of l otherwise.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return nil
+	}
+	s.listener = l
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn answers the requests of one connection until it is closed
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+		s.wg.Done()
+	}()
+
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	for {
+		op, key, value, err := readRequest(r)
+		if err != nil {
+			return // the client hung up, or sent garbage
+		}
+		status, value := s.do(op, key, value)
+		if err := writeResponse(w, status, value); err != nil {
+			return
+		}
+		// don't flush while the client has already sent more requests
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// do runs a request against the cache and returns the response
+func (s *Server) do(op byte, key string, value []byte) (byte, []byte) {
+	var ok bool
+	switch op {
+	case opGet:
+		value, ok = s.cache.Get(key)
+	case opSet:
+		ok = s.cache.Set(key, value)
+		value = nil
+	case opRemove:
+		value, ok = s.cache.Remove(key)
+	default:
+		return statusError, []byte("unknown operation " + string(op))
+	}
+	if !ok {
+		return statusMissing, nil
+	}
+	return statusOK, value
+}
+
+// Close stops the server: it closes the listener and all connections, and
+// waits until they are done
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
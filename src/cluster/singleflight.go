@@ -0,0 +1,49 @@
+// Duplicate Call Suppression
+//
+// Description:
+// flightGroup runs a function at most once per key at a time. Callers that
+// ask for a key while its call is in flight wait for that call and share
+// its result, so a burst of misses on one key turns into a single load.
+
+package cluster
+
+import "sync"
+
+// flightCall is a call in flight or done
+type flightCall struct {
+	done  chan struct{}
+	value []byte
+	err   error
+}
+
+// flightGroup deduplicates concurrent calls by key
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// do runs fn for key, unless a call for key is in flight already, and
+// returns its result. shared reports whether the result came from the call
+// of another caller.
+func (g *flightGroup) do(key string, fn func() ([]byte, error)) (value []byte, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-call.done
+		return call.value, call.err, true
+	}
+	call := &flightCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	call.value, call.err = fn()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	close(call.done)
+	return call.value, call.err, false
+}
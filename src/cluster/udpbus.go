@@ -0,0 +1,147 @@
+// UDP Invalidation Bus
+//
+// Dependencies: bus.go
+//
+// Description:
+// UDPBus is a Bus over UDP. Its members either join a multicast group, and
+// every event goes to the group in one datagram, or they listen on unicast
+// addresses (on the loopback interface, for processes on one host) and send
+// every event to each peer they were given. Events are not acknowledged or
+// resent.
+
+package cluster
+
+import (
+	"net"
+	"sync"
+)
+
+// UDPBus is a member of a bus over UDP
+type UDPBus struct {
+	conn *net.UDPConn
+	done chan struct{} // closed when the read loop ends
+
+	mu      sync.Mutex
+	peers   []*net.UDPAddr
+	handler func(Event)
+	closed  bool
+}
+
+// NewUDPBus listens on addr and starts receiving events. If addr is a
+// multicast group address (e.g. "239.1.2.3:7946"), the bus joins the group
+// on the default interface and publishes to it. Otherwise it publishes to
+// the peers added with AddPeer.
+func NewUDPBus(addr string) (*UDPBus, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &UDPBus{done: make(chan struct{})}
+	if udpAddr.IP.IsMulticast() {
+		b.conn, err = net.ListenMulticastUDP("udp", nil, udpAddr)
+		b.peers = []*net.UDPAddr{udpAddr}
+	} else {
+		b.conn, err = net.ListenUDP("udp", udpAddr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	go b.readLoop()
+	return b, nil
+}
+
+// Addr returns the address the bus listens on
+func (b *UDPBus) Addr() net.Addr {
+	return b.conn.LocalAddr()
+}
+
+// AddPeer makes the bus publish to the member listening on addr as well
+func (b *UDPBus) AddPeer(addr string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.peers = append(b.peers, udpAddr)
+	b.mu.Unlock()
+	return nil
+}
+
+// Publish sends event to every peer, or to the multicast group. It returns
+// the first error, after trying every peer.
+func (b *UDPBus) Publish(event Event) error {
+	datagram, err := marshalEvent(event)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	peers, closed := b.peers, b.closed
+	b.mu.Unlock()
+	if closed {
+		return ErrBusClosed
+	}
+
+	var first error
+	for _, peer := range peers {
+		if _, err := b.conn.WriteToUDP(datagram, peer); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Subscribe sets the function that is called with every event received.
+// Events are handled one at a time, in the order they arrive.
+func (b *UDPBus) Subscribe(handler func(Event)) {
+	b.mu.Lock()
+	b.handler = handler
+	b.mu.Unlock()
+}
+
+// Close stops receiving events and closes the socket
+func (b *UDPBus) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	b.mu.Unlock()
+
+	err := b.conn.Close()
+	<-b.done
+	return err
+}
+
+// readLoop receives datagrams until the socket is closed. Datagrams that are
+// not events are dropped.
+func (b *UDPBus) readLoop() {
+	defer close(b.done)
+	buf := make([]byte, maxEventSize+1)
+	for {
+		n, _, err := b.conn.ReadFromUDP(buf)
+		if err != nil {
+			b.mu.Lock()
+			closed := b.closed
+			b.mu.Unlock()
+			if closed {
+				return
+			}
+			continue
+		}
+
+		event, err := unmarshalEvent(buf[:n])
+		if err != nil {
+			continue
+		}
+		b.mu.Lock()
+		handler := b.handler
+		b.mu.Unlock()
+		if handler != nil {
+			handler(event)
+		}
+	}
+}
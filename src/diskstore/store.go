@@ -0,0 +1,484 @@
+// Log-Structured Disk Store
+//
+// Description:
+// Store is a key-value store in a single append-only log file, meant to be a
+// second cache tier on local disk below an in-memory cache (like the L2ARC
+// of ZFS). Every Put or Delete appends a record, and an in-memory index maps
+// each key to its latest record. Records carry a CRC, so a value that was
+// damaged on disk is detected when it is read instead of being served.
+//
+// The store holds at most capacity bytes of live records. When a Put would
+// exceed it, the oldest records are evicted first, the way the L2ARC treats
+// its device as a ring buffer. Overwritten, deleted and evicted records stay
+// in the log as garbage until the log is compacted, which rewrites the live
+// records into a new file and atomically replaces the old one. Compaction
+// runs on its own once the log is twice the capacity.
+//
+// On Open the log is replayed to rebuild the index. A crash can leave a torn
+// record at the end of the log, so the log is truncated at a record that is
+// incomplete, and at damaged records that nothing valid follows. A damaged
+// record in the middle of the log is skipped by its length, and the records
+// after it are recovered. The ones before it are dropped: the damaged record
+// may have overwritten or deleted any of their keys, and serving such a key
+// would bring back a stale value. If the lengths of a record are damaged
+// there is no telling where the next one starts, so replay ends there too.
+//
+// Record layout, integers are little endian:
+//   crc      uint32, CRC-32C of everything that follows
+//   kind     uint8, recordPut or recordDelete
+//   keyLen   uint32
+//   valueLen uint32
+//   key      [keyLen]byte
+//   value    [valueLen]byte
+
+package diskstore
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	recordPut    = 1
+	recordDelete = 2
+
+	headerSize = 13 // crc, kind, keyLen, valueLen
+)
+
+var (
+	// ErrCorrupt is returned by Get if the record of a key fails its CRC. The
+	// key is dropped from the store.
+	ErrCorrupt = errors.New("diskstore: corrupt record")
+
+	// ErrTooLarge is returned by Put if a single record exceeds the capacity
+	ErrTooLarge = errors.New("diskstore: record larger than capacity")
+
+	// ErrClosed is returned by operations on a closed store
+	ErrClosed = errors.New("diskstore: store is closed")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// record locates the latest record of a key in the log
+type record struct {
+	key    string
+	offset int64 // offset of the record header
+	length int64 // length of the whole record
+}
+
+// Store is a log-structured key-value store on disk. It is safe for
+// concurrent use.
+type Store struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	size int64 // length of the log file, the offset of the next record
+
+	capacity int64 // max number of bytes of live records
+	live     int64 // number of bytes of live records
+
+	index map[string]*list.Element // maps key to its record in order
+	order *list.List               // live records, oldest first
+}
+
+// Open opens the store in the log file at path, creating it if needed, and
+// recovers the records in it. capacity is the number of bytes of live
+// records the store keeps.
+func Open(path string, capacity int64) (*Store, error) {
+	// a compaction that did not finish left its new log behind, the old
+	// log is still complete
+	os.Remove(compactPath(path))
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		path:     path,
+		file:     file,
+		capacity: capacity,
+		index:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+	if err := s.recover(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := s.evict(0); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// recover replays the log to rebuild the index, skips damaged records and
+// truncates a torn tail
+func (s *Store) recover() error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(s.file)
+	var offset int64
+	damaged := int64(-1) // offset of the first damaged record since the last valid one
+
+	for {
+		kind, key, _, length, err := readRecord(r, info.Size()-offset)
+		if err == io.EOF {
+			break
+		}
+		if err == ErrCorrupt && length > 0 {
+			if damaged < 0 {
+				damaged = offset
+			}
+			offset += length
+			continue
+		}
+		if err != nil {
+			break // a torn write, or lengths that cannot be trusted
+		}
+
+		if damaged >= 0 {
+			// a damaged record followed by a valid one was not torn by a
+			// crash, it rotted. It may have changed any key before it.
+			s.index = make(map[string]*list.Element)
+			s.order.Init()
+			s.live = 0
+			damaged = -1
+		}
+		s.unindex(key)
+		if kind == recordPut {
+			s.index[key] = s.order.PushBack(&record{key, offset, length})
+			s.live += length
+		}
+		offset += length
+	}
+
+	// damaged records at the end are a torn write as well
+	if damaged >= 0 {
+		offset = damaged
+	}
+	if offset < info.Size() {
+		if err := s.file.Truncate(offset); err != nil {
+			return err
+		}
+	}
+	s.size = offset
+	return nil
+}
+
+// readRecord reads the next record from r, which has at most limit bytes
+// left, and checks its CRC. A record that claims to be longer is corrupt,
+// it is rejected before its body is allocated. A record that was read in
+// full but is damaged returns ErrCorrupt along with its length, so it can
+// be skipped. It returns io.EOF only if r ends right before a record.
+func readRecord(r io.Reader, limit int64) (kind byte, key string, value []byte, length int64, err error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = ErrCorrupt
+		}
+		return 0, "", nil, 0, err
+	}
+
+	kind = header[4]
+	keyLen := binary.LittleEndian.Uint32(header[5:])
+	valueLen := binary.LittleEndian.Uint32(header[9:])
+	if int64(keyLen)+int64(valueLen) > limit-headerSize {
+		return 0, "", nil, 0, ErrCorrupt
+	}
+
+	body := make([]byte, int(keyLen)+int(valueLen))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, "", nil, 0, ErrCorrupt
+	}
+
+	length = int64(headerSize + len(body))
+	crc := crc32.Update(crc32.Checksum(header[4:], crcTable), crcTable, body)
+	if crc != binary.LittleEndian.Uint32(header) || (kind != recordPut && kind != recordDelete) {
+		return 0, "", nil, length, ErrCorrupt
+	}
+	return kind, string(body[:keyLen]), body[keyLen:], length, nil
+}
+
+// encodeRecord returns the bytes of a record
+func encodeRecord(kind byte, key string, value []byte) []byte {
+	buf := make([]byte, headerSize+len(key)+len(value))
+	buf[4] = kind
+	binary.LittleEndian.PutUint32(buf[5:], uint32(len(key)))
+	binary.LittleEndian.PutUint32(buf[9:], uint32(len(value)))
+	copy(buf[headerSize:], key)
+	copy(buf[headerSize+len(key):], value)
+	binary.LittleEndian.PutUint32(buf, crc32.Checksum(buf[4:], crcTable))
+	return buf
+}
+
+// append writes a record at the end of the log and returns its offset
+func (s *Store) append(buf []byte) (int64, error) {
+	offset := s.size
+	if _, err := s.file.WriteAt(buf, offset); err != nil {
+		// a partial record is cut off on the next Open, don't write after it
+		s.file.Truncate(offset)
+		return 0, err
+	}
+	s.size += int64(len(buf))
+	return offset, nil
+}
+
+// unindex drops key from the index, if it is there
+func (s *Store) unindex(key string) {
+	if elem, ok := s.index[key]; ok {
+		s.live -= elem.Value.(*record).length
+		s.order.Remove(elem)
+		delete(s.index, key)
+	}
+}
+
+// evict deletes the oldest records until another need bytes fit
+func (s *Store) evict(need int64) error {
+	for s.live+need > s.capacity && s.order.Len() > 0 {
+		if err := s.delete(s.order.Front().Value.(*record).key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Put stores value under key, replacing any value it had
+func (s *Store) Put(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return ErrClosed
+	}
+
+	buf := encodeRecord(recordPut, key, value)
+	length := int64(len(buf))
+	if length > s.capacity {
+		// don't leave the old value behind as if it was still current
+		if _, ok := s.index[key]; ok {
+			if err := s.delete(key); err != nil {
+				return err
+			}
+		}
+		return ErrTooLarge
+	}
+
+	// the old record of key stays indexed until the new one is written, so
+	// a failed append leaves the old value readable
+	need := length
+	if elem, ok := s.index[key]; ok {
+		need -= elem.Value.(*record).length
+	}
+	if err := s.evict(need); err != nil {
+		return err
+	}
+	offset, err := s.append(buf)
+	if err != nil {
+		return err
+	}
+	s.unindex(key)
+	s.index[key] = s.order.PushBack(&record{key, offset, length})
+	s.live += length
+
+	return s.maybeCompact()
+}
+
+// Get returns the value stored under key. If its record is damaged, Get
+// returns ErrCorrupt and forgets the key.
+func (s *Store) Get(key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil, false, ErrClosed
+	}
+
+	elem, ok := s.index[key]
+	if !ok {
+		return nil, false, nil
+	}
+	rec := elem.Value.(*record)
+
+	r := io.NewSectionReader(s.file, rec.offset, rec.length)
+	kind, storedKey, value, _, err := readRecord(r, rec.length)
+	if err == nil && (kind != recordPut || storedKey != key) {
+		err = ErrCorrupt
+	}
+	if err != nil {
+		if err == io.EOF {
+			err = ErrCorrupt
+		}
+		s.unindex(key)
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// Delete removes key from the store, if it is there
+func (s *Store) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return ErrClosed
+	}
+	if _, ok := s.index[key]; !ok {
+		return nil
+	}
+	if err := s.delete(key); err != nil {
+		return err
+	}
+	return s.maybeCompact()
+}
+
+// delete appends a delete record for key and drops it from the index
+func (s *Store) delete(key string) error {
+	if _, err := s.append(encodeRecord(recordDelete, key, nil)); err != nil {
+		return err
+	}
+	s.unindex(key)
+	return nil
+}
+
+// maybeCompact compacts the log once it is twice the capacity
+func (s *Store) maybeCompact() error {
+	if s.size <= 2*s.capacity {
+		return nil
+	}
+	return s.compact()
+}
+
+// Compact rewrites the live records into a new log, dropping all garbage
+func (s *Store) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return ErrClosed
+	}
+	return s.compact()
+}
+
+func (s *Store) compact() error {
+	tmpPath := compactPath(s.path)
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// copy the live records, oldest first so eviction order is kept
+	w := bufio.NewWriter(tmp)
+	moved := make([]int64, 0, s.order.Len())
+	var offset int64
+	for elem := s.order.Front(); elem != nil; elem = elem.Next() {
+		rec := elem.Value.(*record)
+		if _, err := io.Copy(w, io.NewSectionReader(s.file, rec.offset, rec.length)); err != nil {
+			return fail(err)
+		}
+		moved = append(moved, offset)
+		offset += rec.length
+	}
+	if err := w.Flush(); err != nil {
+		return fail(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fail(err)
+	}
+	syncDir(s.path)
+
+	s.file.Close()
+	s.file = tmp
+	s.size = offset
+	i := 0
+	for elem := s.order.Front(); elem != nil; elem = elem.Next() {
+		elem.Value.(*record).offset = moved[i]
+		i++
+	}
+	return nil
+}
+
+// Sync commits the log to stable storage
+func (s *Store) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return ErrClosed
+	}
+	return s.file.Sync()
+}
+
+// Close syncs and closes the log
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return ErrClosed
+	}
+	err := s.file.Sync()
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	s.file = nil
+	return err
+}
+
+// Len returns the number of keys in the store
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.index)
+}
+
+// Keys returns the keys in the store, oldest first
+func (s *Store) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.index))
+	for elem := s.order.Front(); elem != nil; elem = elem.Next() {
+		keys = append(keys, elem.Value.(*record).key)
+	}
+	return keys
+}
+
+// Live returns the number of bytes of live records
+func (s *Store) Live() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.live
+}
+
+// FileSize returns the length of the log file, live records and garbage
+func (s *Store) FileSize() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// compactPath is where a compaction writes the new log
+func compactPath(path string) string {
+	return path + ".compact"
+}
+
+// syncDir makes a rename in the directory of path durable, where supported
+func syncDir(path string) {
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return
+	}
+	dir.Sync()
+	dir.Close()
+}
@@ -0,0 +1,331 @@
+package diskstore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openTestStore opens a store in a fresh directory
+func openTestStore(t *testing.T, capacity int64) (*Store, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "l2.log")
+	s, err := Open(path, capacity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, path
+}
+
+// reopen closes s and opens its log again
+func reopen(t *testing.T, s *Store, path string) *Store {
+	t.Helper()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Open(path, s.capacity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// checkValue fails the test unless key holds value, or is missing if value is ""
+func checkValue(t *testing.T, s *Store, key, value string) {
+	t.Helper()
+	got, ok, err := s.Get(key)
+	if err != nil {
+		t.Errorf("Get(%s) failed: %v", key, err)
+	} else if value == "" && ok {
+		t.Errorf("expected %s to be missing, got %q", key, got)
+	} else if value != "" && (!ok || string(got) != value) {
+		t.Errorf("Get(%s) = %q, %v, expected %q", key, got, ok, value)
+	}
+}
+
+// recordSize is the length of the record of a key-value pair
+func recordSize(key, value string) int64 {
+	return int64(headerSize + len(key) + len(value))
+}
+
+func TestStorePutGetDelete(t *testing.T) {
+	s, _ := openTestStore(t, 1024)
+	defer s.Close()
+
+	s.Put("a", []byte("1"))
+	s.Put("b", []byte("2"))
+	s.Put("a", []byte("3"))
+	checkValue(t, s, "a", "3")
+	checkValue(t, s, "b", "2")
+	checkValue(t, s, "c", "")
+
+	if err := s.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, s, "b", "")
+	if s.Len() != 1 || s.Live() != recordSize("a", "3") {
+		t.Errorf("expected one live record, got %d keys in %d bytes", s.Len(), s.Live())
+	}
+}
+
+// the oldest records are evicted to make room, rewriting a key makes it new
+func TestStoreEvictsOldest(t *testing.T) {
+	s, _ := openTestStore(t, 4*recordSize("k0", "value"))
+	defer s.Close()
+
+	for i := 0; i < 4; i++ {
+		s.Put(fmt.Sprint("k", i), []byte("value"))
+	}
+	s.Put("k0", []byte("value"))
+	s.Put("k4", []byte("value"))
+
+	checkValue(t, s, "k1", "")
+	for _, key := range []string{"k0", "k2", "k3", "k4"} {
+		checkValue(t, s, key, "value")
+	}
+	if s.Live() > s.capacity {
+		t.Errorf("expected at most %d live bytes, got %d", s.capacity, s.Live())
+	}
+
+	if err := s.Put("big", make([]byte, s.capacity)); err != ErrTooLarge {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+	if s.Len() != 4 {
+		t.Errorf("expected a record that never fits not to evict anything, %d keys left", s.Len())
+	}
+}
+
+// the index is rebuilt from the log, including deletes and evictions
+func TestStoreRecovery(t *testing.T) {
+	s, path := openTestStore(t, 3*recordSize("k0", "value"))
+	for i := 0; i < 4; i++ {
+		s.Put(fmt.Sprint("k", i), []byte("value"))
+	}
+	s.Delete("k2")
+	s.Put("k3", []byte("newer"))
+
+	s = reopen(t, s, path)
+	defer s.Close()
+	for key, value := range map[string]string{"k0": "", "k1": "value", "k2": "", "k3": "newer"} {
+		checkValue(t, s, key, value)
+	}
+
+	// a smaller capacity evicts on open
+	s.Close()
+	s, err := Open(path, recordSize("k3", "newer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	checkValue(t, s, "k1", "")
+	checkValue(t, s, "k3", "newer")
+}
+
+// a record that was only partly written is cut off, the records before it survive
+func TestStoreTornWrite(t *testing.T) {
+	s, path := openTestStore(t, 1024)
+	s.Put("a", []byte("1"))
+	s.Put("b", []byte("2"))
+	size := s.FileSize()
+	s.Close()
+
+	torn := encodeRecord(recordPut, "c", []byte("3"))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(torn[:len(torn)-1])
+	f.Close()
+
+	s, err = Open(path, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	checkValue(t, s, "a", "1")
+	checkValue(t, s, "b", "2")
+	checkValue(t, s, "c", "")
+	if s.FileSize() != size {
+		t.Errorf("expected the torn record to be truncated to %d bytes, got %d", size, s.FileSize())
+	}
+
+	// new records go after the last good one
+	s.Put("c", []byte("3"))
+	s = reopen(t, s, path)
+	defer s.Close()
+	checkValue(t, s, "c", "3")
+}
+
+// a header whose lengths run past the end of the log is corrupt, recovery
+// cuts it off instead of allocating what it claims
+func TestStoreCorruptLengths(t *testing.T) {
+	s, path := openTestStore(t, 1024)
+	s.Put("a", []byte("1"))
+	size := s.FileSize()
+	s.Close()
+
+	header := encodeRecord(recordPut, "", nil)
+	for i := 5; i < headerSize; i++ {
+		header[i] = 0xff // keyLen and valueLen of 4 GiB each
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(append(header, "bc"...))
+	f.Close()
+
+	s, err = Open(path, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	checkValue(t, s, "a", "1")
+	if s.FileSize() != size {
+		t.Errorf("expected the bad record to be truncated to %d bytes, got %d", size, s.FileSize())
+	}
+}
+
+// a damaged value is never served, neither by Get nor after recovery
+func TestStoreCorruption(t *testing.T) {
+	s, path := openTestStore(t, 1024)
+	s.Put("a", []byte("first"))
+	s.Put("b", []byte("second"))
+	s.Put("c", []byte("third"))
+
+	// flip a byte of b's value
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := recordSize("a", "first") + headerSize + 1
+	f.WriteAt([]byte{'S'}, offset)
+	f.Close()
+
+	if _, ok, err := s.Get("b"); err != ErrCorrupt || ok {
+		t.Errorf("expected ErrCorrupt for the damaged record, got %v, %v", ok, err)
+	}
+	checkValue(t, s, "b", "")
+	checkValue(t, s, "c", "third")
+
+	// replay skips the damaged record and keeps the ones after it, but not
+	// the ones before, which it might have overwritten or deleted
+	size := s.FileSize()
+	s = reopen(t, s, path)
+	checkValue(t, s, "a", "")
+	checkValue(t, s, "b", "")
+	checkValue(t, s, "c", "third")
+	if s.FileSize() != size {
+		t.Errorf("expected the log to keep its %d bytes, got %d", size, s.FileSize())
+	}
+	if keys := s.Keys(); len(keys) != 1 || keys[0] != "c" {
+		t.Errorf("expected only c to be recovered, got %v", keys)
+	}
+
+	// a damaged record that nothing valid follows is a torn write, the
+	// records before it survive
+	s.Put("d", []byte("fourth"))
+	f, err = os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte{'F'}, size+headerSize+1)
+	f.Close()
+
+	s = reopen(t, s, path)
+	defer s.Close()
+	checkValue(t, s, "c", "third")
+	checkValue(t, s, "d", "")
+	if s.FileSize() != size {
+		t.Errorf("expected the log to be truncated to %d bytes, got %d", size, s.FileSize())
+	}
+}
+
+// a Put that fails to append keeps the old value of the key
+func TestStorePutFailure(t *testing.T) {
+	s, path := openTestStore(t, 1024)
+	defer s.Close()
+	s.Put("a", []byte("old"))
+
+	// writes to a read-only file fail, reads still work
+	readOnly, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.file.Close()
+	s.file = readOnly
+
+	if err := s.Put("a", []byte("new")); err == nil {
+		t.Fatalf("expected the Put to fail on a read-only log")
+	}
+	checkValue(t, s, "a", "old")
+	if s.Live() != recordSize("a", "old") {
+		t.Errorf("expected %d live bytes, got %d", recordSize("a", "old"), s.Live())
+	}
+}
+
+func TestStoreCompact(t *testing.T) {
+	s, path := openTestStore(t, 1024)
+	for i := 0; i < 10; i++ {
+		s.Put(fmt.Sprint("k", i%3), []byte(fmt.Sprint("v", i)))
+	}
+	s.Delete("k1")
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if s.FileSize() != s.Live() {
+		t.Errorf("expected compaction to leave only the %d live bytes, got %d", s.Live(), s.FileSize())
+	}
+	checkValue(t, s, "k0", "v9")
+	checkValue(t, s, "k2", "v8")
+
+	// the compacted log is written to and recovered like any other
+	s.Put("k1", []byte("again"))
+	s = reopen(t, s, path)
+	defer s.Close()
+	checkValue(t, s, "k0", "v9")
+	checkValue(t, s, "k1", "again")
+	checkValue(t, s, "k2", "v8")
+}
+
+// garbage never grows the log beyond twice the capacity, plus a record
+func TestStoreAutoCompact(t *testing.T) {
+	capacity := 4 * recordSize("k0", "value")
+	s, _ := openTestStore(t, capacity)
+	defer s.Close()
+
+	for i := 0; i < 200; i++ {
+		s.Put(fmt.Sprint("k", i%10), []byte("value"))
+		if s.FileSize() > 2*capacity+recordSize("k0", "value") {
+			t.Fatalf("expected the log to be compacted, it is %d bytes", s.FileSize())
+		}
+	}
+	for i := 6; i < 10; i++ {
+		checkValue(t, s, fmt.Sprint("k", i), "value")
+	}
+}
+
+// a compaction that was cut short leaves the old log in charge
+func TestStoreInterruptedCompaction(t *testing.T) {
+	s, path := openTestStore(t, 1024)
+	s.Put("a", []byte("1"))
+	s.Close()
+	if err := os.WriteFile(compactPath(path), []byte("half a log"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Open(path, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, s, "a", "1")
+	if _, err := os.Stat(compactPath(path)); !os.IsNotExist(err) {
+		t.Errorf("expected the leftover compaction file to be removed, got %v", err)
+	}
+
+	s.Close()
+	if err := s.Put("b", nil); err != ErrClosed {
+		t.Errorf("expected ErrClosed after Close, got %v", err)
+	}
+}
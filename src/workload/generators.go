@@ -0,0 +1,253 @@
+// Synthetic Workload Generators
+//
+// Description:
+// Seeded, reproducible key generators for exercising the caches in package
+// arc. Every generator that uses randomness owns its own *rand.Rand built
+// from the seed it was given, so the same seed always produces the same
+// sequence of keys, no matter what else is running. Keys are the generator's
+// prefix followed by a decimal number (k0, k1, ...), which keeps them
+// compatible with ARC's scan detection.
+
+package workload
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Generator produces a stream of keys. Generators are not safe for
+// concurrent use.
+type Generator interface {
+	Next() string
+}
+
+// Keys returns the next n keys of gen
+func Keys(gen Generator, n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = gen.Next()
+	}
+	return keys
+}
+
+/******************************************************************************/
+/*                          Popularity distributions                          */
+/******************************************************************************/
+
+// Zipf draws keys out of n with probability proportional to 1/(rank+1)^skew,
+// so key 0 is the most popular. Unlike rand.Zipf any skew >= 0 is allowed,
+// and skew 0 is a uniform distribution.
+type Zipf struct {
+	r      *rand.Rand
+	prefix string
+	cdf    []float64 // cdf[i] is the probability of drawing a key <= i
+}
+
+// NewZipf returns a Zipfian generator over n keys. Less than 1 key is taken
+// as 1.
+func NewZipf(seed int64, prefix string, n int, skew float64) *Zipf {
+	n = atLeastOne(n)
+	cdf := make([]float64, n)
+	sum := 0.0
+	for i := range cdf {
+		sum += 1 / math.Pow(float64(i+1), skew)
+		cdf[i] = sum
+	}
+	for i := range cdf {
+		cdf[i] /= sum
+	}
+
+	return &Zipf{r: rand.New(rand.NewSource(seed)), prefix: prefix, cdf: cdf}
+}
+
+// Next returns the next key
+func (z *Zipf) Next() string {
+	i := sort.SearchFloat64s(z.cdf, z.r.Float64())
+	if i >= len(z.cdf) {
+		i = len(z.cdf) - 1 // guards against rounding in the last bucket
+	}
+	return fmt.Sprint(z.prefix, i)
+}
+
+// Uniform draws keys out of n with equal probability
+type Uniform struct {
+	r      *rand.Rand
+	prefix string
+	n      int
+}
+
+// NewUniform returns a uniform generator over n keys. Less than 1 key is
+// taken as 1.
+func NewUniform(seed int64, prefix string, n int) *Uniform {
+	return &Uniform{r: rand.New(rand.NewSource(seed)), prefix: prefix, n: atLeastOne(n)}
+}
+
+// Next returns the next key
+func (u *Uniform) Next() string {
+	return fmt.Sprint(u.prefix, u.r.Intn(u.n))
+}
+
+/******************************************************************************/
+/*                            Access patterns                                 */
+/******************************************************************************/
+
+// Scan is a one-shot sequential scan, every key is requested exactly once
+type Scan struct {
+	prefix string
+	next   int
+}
+
+// NewScan returns a scan that starts at key start and never wraps
+func NewScan(prefix string, start int) *Scan {
+	return &Scan{prefix: prefix, next: start}
+}
+
+// Next returns the next key
+func (s *Scan) Next() string {
+	key := fmt.Sprint(s.prefix, s.next)
+	s.next++
+	return key
+}
+
+// Loop requests keys 0..n-1 in order, over and over
+type Loop struct {
+	prefix string
+	n      int
+	next   int
+}
+
+// NewLoop returns a loop over n keys. Less than 1 key is taken as 1.
+func NewLoop(prefix string, n int) *Loop {
+	return &Loop{prefix: prefix, n: atLeastOne(n)}
+}
+
+// Next returns the next key
+func (l *Loop) Next() string {
+	key := fmt.Sprint(l.prefix, l.next)
+	l.next = (l.next + 1) % l.n
+	return key
+}
+
+// Temporal has temporal locality: with probability reuse it requests one of
+// the last window keys again, otherwise a uniformly chosen key out of n
+type Temporal struct {
+	r      *rand.Rand
+	prefix string
+	n      int
+	reuse  float64
+	recent []string // ring of the last window keys
+	pos    int
+}
+
+// NewTemporal returns a generator with temporal locality. Less than 1 key is
+// taken as 1.
+func NewTemporal(seed int64, prefix string, n int, reuse float64, window int) *Temporal {
+	return &Temporal{
+		r:      rand.New(rand.NewSource(seed)),
+		prefix: prefix,
+		n:      atLeastOne(n),
+		reuse:  reuse,
+		recent: make([]string, 0, window),
+	}
+}
+
+// Next returns the next key
+func (t *Temporal) Next() string {
+	var key string
+	if len(t.recent) > 0 && t.r.Float64() < t.reuse {
+		key = t.recent[t.r.Intn(len(t.recent))]
+	} else {
+		key = fmt.Sprint(t.prefix, t.r.Intn(t.n))
+	}
+
+	if len(t.recent) < cap(t.recent) {
+		t.recent = append(t.recent, key)
+	} else if cap(t.recent) > 0 {
+		t.recent[t.pos] = key
+		t.pos = (t.pos + 1) % cap(t.recent)
+	}
+	return key
+}
+
+// ShiftingHotSet requests keys uniformly out of a hot set of hot keys, and
+// moves the hot set to fresh keys every phaseLen requests
+type ShiftingHotSet struct {
+	r        *rand.Rand
+	prefix   string
+	hot      int
+	phaseLen int
+	count    int
+}
+
+// NewShiftingHotSet returns a generator whose hot set shifts every phaseLen
+// requests. A hot set or phase of less than 1 is taken as 1.
+func NewShiftingHotSet(seed int64, prefix string, hot, phaseLen int) *ShiftingHotSet {
+	return &ShiftingHotSet{
+		r:        rand.New(rand.NewSource(seed)),
+		prefix:   prefix,
+		hot:      atLeastOne(hot),
+		phaseLen: atLeastOne(phaseLen),
+	}
+}
+
+// Next returns the next key
+func (s *ShiftingHotSet) Next() string {
+	phase := s.count / s.phaseLen
+	s.count++
+	return fmt.Sprint(s.prefix, phase*s.hot+s.r.Intn(s.hot))
+}
+
+/******************************************************************************/
+/*                                 Mixtures                                   */
+/******************************************************************************/
+
+// Weighted pairs a generator with its relative weight in a Mix
+type Weighted struct {
+	Gen    Generator
+	Weight float64
+}
+
+// Mix picks one of its generators at random for every request, in proportion
+// to their weights. Each generator keeps its own state, so a scan inside a
+// mix is still sequential, just interleaved with other traffic.
+type Mix struct {
+	r     *rand.Rand
+	parts []Weighted
+	total float64
+}
+
+// NewMix returns a mixture of the given generators. It panics if there are
+// none, since an empty mix has no key to return.
+func NewMix(seed int64, parts ...Weighted) *Mix {
+	if len(parts) == 0 {
+		panic("workload: NewMix needs at least one generator")
+	}
+	total := 0.0
+	for _, part := range parts {
+		total += part.Weight
+	}
+	return &Mix{r: rand.New(rand.NewSource(seed)), parts: parts, total: total}
+}
+
+// Next returns the next key
+func (m *Mix) Next() string {
+	pick := m.r.Float64() * m.total
+	for _, part := range m.parts {
+		if pick < part.Weight {
+			return part.Gen.Next()
+		}
+		pick -= part.Weight
+	}
+	return m.parts[len(m.parts)-1].Gen.Next()
+}
+
+// atLeastOne clamps a key count to 1, so generators never draw from or loop
+// over an empty range
+func atLeastOne(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
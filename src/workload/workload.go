@@ -0,0 +1,81 @@
+// Workloads and Trace Files
+//
+// Dependencies: generators.go
+//
+// Description:
+// A Workload turns a key generator into a stream of read and write requests
+// with a configurable read/write ratio, and can write that stream out as a
+// trace file for the simulator in package arc (arc.ReplayTrace). Trace lines
+// use the Wikipedia 2019 CDN trace format, "timestamp key size", with an
+// extra fourth column that is "r" for reads and "w" for writes.
+
+package workload
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+)
+
+// Op is a single request of a workload
+type Op struct {
+	Key   string
+	Write bool
+}
+
+// Workload issues requests for the keys of a generator
+type Workload struct {
+	gen        Generator
+	writeRatio float64 // fraction of requests that are writes, in [0, 1]
+	valueSize  int     // size column written to trace files
+	r          *rand.Rand
+}
+
+// New returns a workload over gen where writeRatio of the requests are writes
+func New(seed int64, gen Generator, writeRatio float64) *Workload {
+	return &Workload{
+		gen:        gen,
+		writeRatio: writeRatio,
+		valueSize:  1,
+		r:          rand.New(rand.NewSource(seed)),
+	}
+}
+
+// SetValueSize sets the object size recorded in trace files
+func (w *Workload) SetValueSize(size int) {
+	w.valueSize = size
+}
+
+// Next returns the next request
+func (w *Workload) Next() Op {
+	// always draw, so the keys do not depend on the write ratio
+	write := w.r.Float64() < w.writeRatio
+	return Op{Key: w.gen.Next(), Write: write}
+}
+
+// Ops returns the next n requests
+func (w *Workload) Ops(n int) []Op {
+	ops := make([]Op, n)
+	for i := range ops {
+		ops[i] = w.Next()
+	}
+	return ops
+}
+
+// WriteTrace writes the next n requests of the workload to out, one line per
+// request, in a format arc.ReplayTrace understands
+func (w *Workload) WriteTrace(out io.Writer, n int) error {
+	buf := bufio.NewWriter(out)
+	for i := 0; i < n; i++ {
+		op := w.Next()
+		kind := "r"
+		if op.Write {
+			kind = "w"
+		}
+		if _, err := fmt.Fprintf(buf, "%d %s %d %s\n", i, op.Key, w.valueSize, kind); err != nil {
+			return err
+		}
+	}
+	return buf.Flush()
+}
@@ -0,0 +1,205 @@
+package workload
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cos316.princeton.edu/final_proj/arc"
+)
+
+// same seed, same keys; different seed, different keys
+func TestGeneratorsDeterministic(t *testing.T) {
+	builders := map[string]func(seed int64) Generator{
+		"zipf":     func(seed int64) Generator { return NewZipf(seed, "z", 100, 0.9) },
+		"uniform":  func(seed int64) Generator { return NewUniform(seed, "u", 100) },
+		"temporal": func(seed int64) Generator { return NewTemporal(seed, "t", 100, 0.5, 10) },
+		"hotset":   func(seed int64) Generator { return NewShiftingHotSet(seed, "h", 10, 50) },
+		"mix": func(seed int64) Generator {
+			return NewMix(seed,
+				Weighted{NewZipf(seed, "z", 100, 1.2), 3},
+				Weighted{NewScan("s", 0), 1})
+		},
+	}
+
+	for name, build := range builders {
+		if !reflect.DeepEqual(Keys(build(42), 500), Keys(build(42), 500)) {
+			t.Errorf("%s: same seed produced different keys", name)
+		}
+		if reflect.DeepEqual(Keys(build(42), 500), Keys(build(43), 500)) {
+			t.Errorf("%s: different seeds produced the same keys", name)
+		}
+	}
+}
+
+// counts returns how often every key was drawn
+func counts(keys []string) map[string]int {
+	c := make(map[string]int)
+	for _, key := range keys {
+		c[key]++
+	}
+	return c
+}
+
+func TestZipfSkew(t *testing.T) {
+	skewed := counts(Keys(NewZipf(1, "z", 100, 1.2), 50000))
+	if skewed["z0"] <= skewed["z1"] || skewed["z1"] <= skewed["z10"] || skewed["z10"] <= skewed["z99"] {
+		t.Errorf("expected popularity to drop with rank, got z0=%d z1=%d z10=%d z99=%d",
+			skewed["z0"], skewed["z1"], skewed["z10"], skewed["z99"])
+	}
+
+	// skew 0 is uniform, every key should be close to 500 draws
+	flat := counts(Keys(NewZipf(1, "z", 100, 0), 50000))
+	for i := 0; i < 100; i++ {
+		if c := flat[fmt.Sprint("z", i)]; c < 350 || c > 650 {
+			t.Errorf("expected a uniform spread with skew 0, z%d drawn %d times", i, c)
+		}
+	}
+}
+
+func TestScanAndLoop(t *testing.T) {
+	if got := Keys(NewScan("s", 7), 3); !reflect.DeepEqual(got, []string{"s7", "s8", "s9"}) {
+		t.Errorf("unexpected scan %v", got)
+	}
+	if got := Keys(NewLoop("l", 3), 7); !reflect.DeepEqual(got, []string{"l0", "l1", "l2", "l0", "l1", "l2", "l0"}) {
+		t.Errorf("unexpected loop %v", got)
+	}
+}
+
+func TestTemporalReuse(t *testing.T) {
+	// with full reuse, everything after the first key repeats the window
+	keys := Keys(NewTemporal(1, "t", 1000000, 1, 5), 100)
+	if len(counts(keys)) != 1 {
+		t.Errorf("expected full reuse to repeat the first key, got %d distinct keys", len(counts(keys)))
+	}
+
+	// without reuse, almost every key out of a huge space is new
+	keys = Keys(NewTemporal(1, "t", 1000000, 0, 5), 100)
+	if len(counts(keys)) < 95 {
+		t.Errorf("expected no reuse, got %d distinct keys", len(counts(keys)))
+	}
+}
+
+func TestShiftingHotSet(t *testing.T) {
+	keys := Keys(NewShiftingHotSet(1, "h", 10, 100), 300)
+	for i, key := range keys {
+		var n int
+		fmt.Sscanf(key, "h%d", &n)
+		if phase := i / 100; n < phase*10 || n >= (phase+1)*10 {
+			t.Fatalf("request %d in phase %d drew %s, outside the hot set", i, phase, key)
+		}
+	}
+
+	// a hot set and phase of 0 are clamped to 1, a new key every request
+	keys = Keys(NewShiftingHotSet(1, "h", 0, 0), 3)
+	if keys[0] != "h0" || keys[1] != "h1" || keys[2] != "h2" {
+		t.Errorf("expected h0 h1 h2, got %v", keys)
+	}
+}
+
+// generators over less than one key draw from a single key instead of
+// panicking or producing negative keys
+func TestEmptyKeyRange(t *testing.T) {
+	gens := map[string]Generator{
+		"zipf":     NewZipf(1, "k", 0, 1),
+		"uniform":  NewUniform(1, "k", 0),
+		"loop":     NewLoop("k", 0),
+		"temporal": NewTemporal(1, "k", -3, 0, 2),
+	}
+	for name, gen := range gens {
+		for _, key := range Keys(gen, 5) {
+			if key != "k0" {
+				t.Errorf("expected %s over no keys to return k0, got %s", name, key)
+			}
+		}
+	}
+}
+
+func TestEmptyMix(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected NewMix without generators to panic")
+		}
+	}()
+	NewMix(1)
+}
+
+func TestMixWeights(t *testing.T) {
+	keys := Keys(NewMix(1, Weighted{NewScan("a", 0), 3}, Weighted{NewScan("b", 0), 1}), 40000)
+	a := 0
+	for _, key := range keys {
+		if strings.HasPrefix(key, "a") {
+			a++
+		}
+	}
+	if a < 29000 || a > 31000 {
+		t.Errorf("expected about 30000 requests from the 3:1 side, got %d", a)
+	}
+
+	// each part keeps its own state, so interleaved scans stay sequential
+	next := map[byte]int{}
+	for _, key := range keys {
+		var n int
+		fmt.Sscanf(key[1:], "%d", &n)
+		if n != next[key[0]] {
+			t.Fatalf("scan %c out of order, expected %d got %s", key[0], next[key[0]], key)
+		}
+		next[key[0]]++
+	}
+}
+
+func TestWriteRatio(t *testing.T) {
+	ops := New(1, NewUniform(1, "u", 100), 0.25).Ops(40000)
+	writes := 0
+	for _, op := range ops {
+		if op.Write {
+			writes++
+		}
+	}
+	if writes < 9500 || writes > 10500 {
+		t.Errorf("expected about 10000 writes, got %d", writes)
+	}
+
+	// the write ratio does not change which keys are drawn
+	reads := New(1, NewUniform(1, "u", 100), 0).Ops(100)
+	for i := range reads {
+		if reads[i].Key != ops[i].Key {
+			t.Fatalf("key %d differs between write ratios", i)
+		}
+	}
+}
+
+// trace files can be replayed by the simulator, writes are plain Sets
+func TestWriteTraceReplay(t *testing.T) {
+	var trace bytes.Buffer
+	w := New(1, NewLoop("k", 4), 0.5)
+	w.SetValueSize(512)
+	if err := w.WriteTrace(&trace, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(trace.String()), "\n")
+	if len(lines) != 100 || !strings.HasPrefix(lines[1], "1 k1 512 ") {
+		t.Fatalf("unexpected trace format: %q", lines[:2])
+	}
+
+	reads := 0
+	for _, line := range lines {
+		if strings.HasSuffix(line, " r") {
+			reads++
+		}
+	}
+
+	lru := arc.NewLru(10)
+	if err := arc.ReplayTrace(&trace, 0, 0, lru); err != nil {
+		t.Fatal(err)
+	}
+	if stats := lru.Stats(); stats.Hits+stats.Misses != reads {
+		t.Errorf("expected one Get per read (%d), got %+v", reads, *stats)
+	}
+	if lru.Len() != 4 {
+		t.Errorf("expected the 4 loop keys to be cached, got %d", lru.Len())
+	}
+}